@@ -1,19 +1,23 @@
 -- com/List.lua
+--[[meta
+{
+  "description": "Returns a list of available methods",
+  "params": {
+    "type": "object",
+    "properties": {
+      "layer": {
+        "type": "string",
+        "description": "select which layer list to display"
+      }
+    }
+  }
+}
+]]
 
 local session = require("internal.session")
 
 local params = session.request.params.get()
 
-if params.about then
-  session.response.result = {
-    description = "Returns a list of available methods",
-    params = {
-      layer = "select which layer list to display"
-    }
-  }
-  return
-end
-
 local function isValidName(name)
   return name:match("^[%w]+$") ~= nil
 end
@@ -12,7 +12,7 @@ local log = require("internal.log")
 local db = require("internal.database.sqlite").connect("db/unit.db", {log = true})
 local session = require("internal.session")
 local crypt = require("internal.crypt.bcrypt")
-local sha256 = require("internal.crypt.sha256")
+local random = require("internal.crypt.random")
 
 local common = require("com/Unit/_common")
 local errors = require("com/Unit/_errors")
@@ -35,7 +35,7 @@ if not ok then
 end
 
 local hashPass = crypt.generate(params.password, crypt.DefaultCost)
-local unitID = string.sub(sha256.hash(session.__seed), 1, 16)
+local unitID = random.hex(8)
 
 local ctx, err = db:exec(
   "INSERT INTO units (user_id, username, email, password) VALUES (?, ?, ?, ?)",
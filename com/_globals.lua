@@ -10,9 +10,34 @@
 ---@class SessionOut
 ---@field result Any|string? Result payload (table or primitive)
 ---@field error { code: integer, message: string, data: Any }? Optional error info
+---@field streaming boolean Whether the client receives notifications (WebSocket or Server-Sent Events)
+---@field stream fun(item: Any): boolean, string? Send an item to the client as a "session.stream" notification
+
+---@class SessionCertificate
+---@field subject string Subject of the client certificate
+---@field common_name string Common name of the subject
+---@field organization string[] Organizations of the subject
+---@field organizational_unit string[] Organizational units of the subject
+---@field issuer string Issuer of the certificate
+---@field serial string Serial number
+---@field fingerprint string Hex SHA-256 of the certificate
+---@field dns_names string[] DNS subject alternative names
+---@field email_addresses string[] Email subject alternative names
+---@field ip_addresses string[] IP subject alternative names
+---@field uris string[] URI subject alternative names
+
+---@class SessionAuth
+---@field authenticated boolean Whether the client presented valid credentials
+---@field principal string? Principal ID of the client
+---@field method string? How the client was authenticated, "api-key", "jwt" or "certificate"
+---@field roles string[] Roles of the principal
+---@field permissions string[] Permissions of the principal
+---@field claims AnyTable? Claims of the JWT, empty for API keys
+---@field certificate SessionCertificate? Verified TLS client certificate
 
 ---@class SessionModule
 ---@field request SessionIn Input context (read-only)
+---@field auth SessionAuth Authenticated client (read-only)
 ---@field response SessionOut Output context (write results/errors)
 
 --- Global log module interface
@@ -26,20 +51,108 @@
 ---@field event_warn fun(msg: string) Log event warning
 
 --- Global net module interface
+---@class HttpRequest
+---@field method string? HTTP method, "GET" by default
+---@field url string Request URL
+---@field headers table<string, string|string[]>? Request headers
+---@field body string|AnyTable? Request body, a table is sent as JSON
+---@field json Any? Value sent as a JSON body
+---@field timeout number|string? Seconds or a duration like "1m", at most lua.http.timeout which is the default
+---@field follow_redirects boolean? Follow redirects, true by default
+---@field max_body integer? Largest response body read, at most lua.http.max_body which is the default
+---@field log boolean? Log the request
+
 ---@class HttpResponse
 ---@field status integer HTTP status code
 ---@field status_text string HTTP status text
 ---@field body string Response body
+---@field json Any? Decoded body of a JSON response
 ---@field content_length integer Content length
 ---@field headers AnyTable Map of headers
+---@field url string URL of the response after redirects
 
+--- Destinations are checked against lua.http.egress, a denied request returns an error
 ---@class HttpModule
----@field get fun(log: boolean, url: string): HttpResponse, string? Perform GET
----@field post fun(log: boolean, url: string, content_type: string, payload: string): HttpResponse, string? Perform POST
+---@field request fun(req: HttpRequest): HttpResponse?, string? Perform a request
+---@field get_request fun(log: boolean, url: string): HttpResponse?, string? Perform GET
+---@field post_request fun(log: boolean, url: string, content_type: string, payload: string): HttpResponse?, string? Perform POST
 
 ---@class NetModule
 ---@field http HttpModule HTTP client functions
 
+---@class JsonEncodeOptions
+---@field pretty boolean? Indent with two spaces
+---@field indent (string|integer)? Indent with the string or that many spaces, 16 at most
+
+--- require("internal.json"), decoded arrays and objects come back marked.
+--- Tables keyed 1..n are sent as arrays, any other table, the empty one too,
+--- as an object. Integers a Lua number cannot hold arrive as strings.
+---@class JsonModule
+---@field null userdata Stands for null inside tables, decode returns it for null
+---@field array fun(t: table?): table Mark a table to be encoded as an array, even when empty
+---@field object fun(t: table?): table Mark a table to be encoded as an object, even when empty
+---@field encode fun(value: Any, opts: JsonEncodeOptions?): string?, string? Encode a value
+---@field decode fun(s: string): Any?, string? Decode a document
+
+--- Binary results are strings of raw bytes unless an encoding is given:
+--- "raw", "hex", "base64" or "base64url" (unpadded).
+---@alias Encoding "raw"|"hex"|"base64"|"base64url"
+
+--- require("internal.crypt.hmac"), MACs are hex by default
+---@class HmacModule
+---@field sha256 fun(key: string, data: string, enc: Encoding?): string?, string? HMAC-SHA256
+---@field sha512 fun(key: string, data: string, enc: Encoding?): string?, string? HMAC-SHA512
+---@field verify fun(alg: "sha256"|"sha512", key: string, data: string, mac: string, enc: Encoding?): boolean, string? Compare in constant time
+
+--- require("internal.crypt.sha256"), a number is hashed as tostring writes it:
+--- integers in the int64 range in plain digits, others like 1.5, 1e+20 or 1e-05
+---@class Sha256Module
+---@field hash fun(data: string|number, enc: Encoding?): string?, string? SHA-256 digest, hex by default
+
+--- require("internal.crypt.random"), backed by crypto/rand, at most 65536 bytes a call
+---@class RandomModule
+---@field bytes fun(n: integer, enc: Encoding?): string?, string? n random bytes, raw by default
+---@field hex fun(n: integer?): string?, string? n bytes (16) as hex
+---@field token fun(n: integer?): string?, string? n bytes (32) as base64url, for reset links and keys
+---@field int fun(min: integer, max: integer): integer?, string? An integer in [min, max]
+
+---@class AesOptions
+---@field aad string? Additional data authenticated but not encrypted
+---@field encoding Encoding? Encoding of the ciphertext, raw by default
+
+--- require("internal.crypt.aes"), AES-GCM with a 16, 24 or 32 byte key,
+--- the random nonce is put ahead of the ciphertext
+---@class AesModule
+---@field encrypt fun(key: string, plaintext: string, opts: AesOptions?): string?, string? Seal the plaintext
+---@field decrypt fun(key: string, ciphertext: string, opts: AesOptions?): string?, string? Open what encrypt returned
+
+--- require("internal.crypt.ed25519"), keys and signatures share one encoding
+---@class Ed25519Module
+---@field generate fun(enc: Encoding?): string?, string? The public key and the 32 byte private seed
+---@field sign fun(private: string, message: string, enc: Encoding?): string?, string? Sign with the seed or the 64 byte key
+---@field verify fun(public: string, message: string, signature: string, enc: Encoding?): boolean, string? Check a signature
+
+---@class Argon2Options
+---@field time integer? Passes, 2 by default
+---@field memory integer? Memory in KiB, 19456 by default, at most lua.argon2.max_memory
+---@field threads integer? Lanes, 1 by default
+---@field key_len integer? 32 by default
+---@field salt_len integer? 16 by default
+
+--- require("internal.crypt.argon2"), hashes in the $argon2id$v=19$... format
+---@class Argon2Module
+---@field hash fun(password: string, opts: Argon2Options?): string?, string? Hash with a random salt
+---@field verify fun(password: string, hash: string): boolean, string? Check a password against a hash, false for a hash above lua.argon2.max_memory
+
+---@class Base64Options
+---@field url boolean? Use the URL alphabet
+---@field padding boolean? Set to false to leave out the padding
+
+--- require("internal.encoding.base64") and require("internal.encoding.hex")
+---@class EncodingModule
+---@field encode fun(data: string, opts: Base64Options?): string Encode bytes
+---@field decode fun(text: string, opts: Base64Options?): string?, string? Decode text
+
 --- Global variables declaration
 ---@global
 ---@type SessionModule
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"github.com/akyaiy/GoSally-mvp/src/hooks"
+	"github.com/spf13/cobra"
+)
+
+var openrpcCmd = &cobra.Command{
+	Use:   "openrpc",
+	Short: "Print the OpenRPC document of the com directory",
+	Long: `
+"openrpc" walks the com directory from the configuration file and prints
+an OpenRPC document describing every method, the same one the node serves
+on GET /com/openrpc.json`,
+	Run: hooks.OpenRPC,
+}
+
+func init() {
+	rootCmd.AddCommand(openrpcCmd)
+}
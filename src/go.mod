@@ -15,9 +15,11 @@ require (
 	modernc.org/sqlite v1.38.2
 )
 
+require github.com/gorilla/websocket v1.5.3
+
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
-	github.com/fsnotify/fsnotify v1.9.0 // indirect
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
@@ -41,5 +43,5 @@ require (
 
 require (
 	github.com/go-chi/cors v1.2.2
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,46 @@
+package hooks
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/colors"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/acl"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+)
+
+// newACL loads the policy of the acl section
+func newACL(x *app.AppX) (*acl.ACL, error) {
+	cfg := x.Config.Conf.ACL
+	proxies, err := clientip.ParseProxies(*cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return acl.New(&acl.Init{
+		File:           *cfg.File,
+		TrustedProxies: proxies,
+	})
+}
+
+// reloadACLOnSIGHUP reads the policy again on every SIGHUP until ctx is done,
+// a broken file is reported and the previous policy stays
+func reloadACLOnSIGHUP(ctx context.Context, x *app.AppX, a *acl.ACL) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := a.Reload(); err != nil {
+				x.Log.Printf("%s: ACL policy was not reloaded: %s", colors.PrintError(), err.Error())
+				continue
+			}
+			x.Log.Printf("ACL policy reloaded, %d rules", a.Rules())
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package hooks
+
+import (
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+)
+
+// newAuthenticator builds the authenticator of the auth section
+func newAuthenticator(x *app.AppX) (*auth.Authenticator, error) {
+	cfg := x.Config.Conf.Auth
+	return auth.New(&auth.Init{
+		APIKeysFile:    *cfg.APIKeysFile,
+		HS256Secret:    *cfg.JWT.HS256SecretFile,
+		RS256PublicKey: *cfg.JWT.RS256PublicKeyFile,
+		EdDSAPublicKey: *cfg.JWT.EdDSAPublicKeyFile,
+		Issuer:         *cfg.JWT.Issuer,
+		Audience:       *cfg.JWT.Audience,
+		Leeway:         *cfg.JWT.Leeway,
+		Claims: auth.ClaimNames{
+			Principal:   *cfg.JWT.PrincipalClaim,
+			Roles:       *cfg.JWT.RolesClaim,
+			Permissions: *cfg.JWT.PermissionsClaim,
+		},
+		Protected: *cfg.Protected,
+	})
+}
@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/colors"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/certs"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+)
+
+// loadCertificate loads the certificate of the tls section. With tls.self_signed
+// a missing certificate is replaced by a generated one in the meta directory,
+// the returned renew function replaces it again before it expires.
+func loadCertificate(cs *corestate.CoreState, x *app.AppX) (*certs.Store, func(), error) {
+	cfg := x.Config.Conf.TLS
+	certFile, keyFile := *cfg.CertFile, *cfg.KeyFile
+	if exists(certFile) && exists(keyFile) {
+		store, err := certs.Load(certFile, keyFile)
+		return store, nil, err
+	}
+	if !*cfg.SelfSigned {
+		return nil, nil, fmt.Errorf("tls.cert_file %q or tls.key_file %q does not exist, set tls.self_signed to generate a certificate", certFile, keyFile)
+	}
+
+	dir := filepath.Join(cs.NodePath, cs.MetaDir, "tls")
+	certFile, keyFile = filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	hosts := certificateHosts(x)
+	if !exists(certFile) || !exists(keyFile) {
+		if err := certs.Generate(certFile, keyFile, hosts); err != nil {
+			return nil, nil, fmt.Errorf("cannot generate a certificate: %w", err)
+		}
+		x.Log.Printf("Generated a self-signed certificate %s for %v", certFile, hosts)
+	}
+	store, err := certs.Load(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	renew := func() {
+		if time.Until(store.Leaf().NotAfter) > certs.RenewBefore {
+			return
+		}
+		if err := certs.Generate(certFile, keyFile, hosts); err != nil {
+			x.Log.Printf("%s: Cannot renew the self-signed certificate: %s", colors.PrintError(), err.Error())
+			return
+		}
+		x.Log.Printf("Renewed the self-signed certificate %s", certFile)
+	}
+	renew()
+	_, err = store.Reload()
+	return store, renew, err
+}
+
+// watchCertificate picks up new certificate files until ctx is done
+func watchCertificate(ctx context.Context, x *app.AppX, store *certs.Store, renew func()) {
+	store.Watch(ctx, *x.Config.Conf.TLS.ReloadInterval, renew, func(reloaded bool, err error) {
+		if err != nil {
+			x.Log.Printf("%s: TLS certificate was not reloaded: %s", colors.PrintError(), err.Error())
+			return
+		}
+		x.Log.Printf("TLS certificate reloaded, SHA-256 fingerprint %s", certs.Fingerprint(store.Leaf()))
+	})
+}
+
+// certificateHosts are the names a generated certificate is valid for:
+// the node name, the hostname and the listen address, or every address
+// of the machine when the node listens on all of them
+func certificateHosts(x *app.AppX) []string {
+	hosts := []string{*x.Config.Conf.Node.Name}
+	if hostname, err := os.Hostname(); err == nil {
+		hosts = append(hosts, hostname)
+	}
+	hosts = append(hosts, "localhost", "127.0.0.1", "::1")
+
+	address := *x.Config.Conf.HTTPServer.Address
+	if ip := net.ParseIP(address); ip == nil || !ip.IsUnspecified() {
+		hosts = append(hosts, address)
+	} else if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+				hosts = append(hosts, ipnet.IP.String())
+			}
+		}
+	}
+
+	var unique []string
+	for _, host := range hosts {
+		if host != "" && !slices.Contains(unique, host) {
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
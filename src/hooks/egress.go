@@ -0,0 +1,26 @@
+package hooks
+
+import (
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/egress"
+)
+
+// newEgressPolicy builds the policy of the lua.http.egress section
+func newEgressPolicy(x *app.AppX) (*egress.Policy, error) {
+	cfg := x.Config.Conf.Lua.HTTP.Egress
+	var rules []egress.Rule
+	for _, rule := range *cfg.Rules {
+		rules = append(rules, egress.Rule{
+			Methods:          rule.Methods,
+			Hosts:            rule.Hosts,
+			CIDRs:            rule.CIDRs,
+			Schemes:          rule.Schemes,
+			ForwardInitiator: rule.ForwardInitiator,
+		})
+	}
+	return egress.New(&egress.Init{
+		Rules:        rules,
+		Default:      *cfg.Default,
+		BlockPrivate: *cfg.BlockPrivate,
+	})
+}
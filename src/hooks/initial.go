@@ -58,7 +58,13 @@ func InitConfigLoadHook(_ context.Context, cs *corestate.CoreState, x *app.AppX)
 	}
 	cs.NodePath = *x.Config.Env.NodePath
 
-	if cfgPath := x.Config.CMDLine.Run.ConfigPath; cfgPath != "" {
+	loadConf(x, x.Config.CMDLine.Run.ConfigPath)
+}
+
+// loadConf loads the configuration file given on the command line,
+// falling back to the one from the environment
+func loadConf(x *app.AppX, cfgPath string) {
+	if cfgPath != "" {
 		x.Config.Env.ConfigPath = &cfgPath
 	}
 	if err := x.Config.LoadConf(*x.Config.Env.ConfigPath); err != nil {
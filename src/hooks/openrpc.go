@@ -0,0 +1,83 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/gateway"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/session"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv1"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv2"
+	"github.com/spf13/cobra"
+)
+
+// OpenRPC prints the OpenRPC document of the com directory without starting the node
+func OpenRPC(cmd *cobra.Command, args []string) {
+	NodeApp.InitialHooks(
+		InitGlobalLoggerHook, InitCorestateHook, InitOpenRPCHook, InitConfigReplHook,
+	)
+
+	NodeApp.Run(OpenRPCHook)
+}
+
+// InitOpenRPCHook keeps stdout for the document and loads the configuration
+func InitOpenRPCHook(_ context.Context, cs *corestate.CoreState, x *app.AppX) {
+	x.Log.SetOutput(os.Stderr)
+	x.SLog = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	if err := x.Config.LoadEnv(); err != nil {
+		x.Log.Fatalf("env load error: %s", err)
+	}
+	cs.NodePath = *x.Config.Env.NodePath
+	loadConf(x, x.Config.CMDLine.OpenRPC.ConfigPath)
+}
+
+func OpenRPCHook(_ context.Context, cs *corestate.CoreState, x *app.AppX) error {
+	opts := x.Config.CMDLine.OpenRPC
+
+	serverv1 := sv1.InitV1Server(&sv1.HandlerV1InitStruct{
+		X:          x,
+		CS:         cs,
+		AllowedCmd: regexp.MustCompile(AllowedCmdPattern),
+		Ver:        "v1",
+	})
+	defer serverv1.Shutdown()
+	serverv2 := sv2.InitServer(&sv2.HandlerInitStruct{
+		X:          x,
+		CS:         cs,
+		AllowedCmd: regexp.MustCompile(AllowedCmdPattern),
+		Ver:        "v2",
+	})
+	defer serverv2.Shutdown()
+
+	s := gateway.InitGateway(&gateway.GatewayServerInit{
+		SM: session.New(time.Minute),
+		CS: cs,
+		X:  x,
+	}, serverv1, serverv2)
+
+	doc, err := s.OpenRPC(opts.ContextVersion, opts.ServerURL)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "" {
+		file, err := os.Create(opts.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
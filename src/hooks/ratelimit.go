@@ -0,0 +1,29 @@
+package hooks
+
+import (
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/ratelimit"
+)
+
+// newRateLimiter builds the limiter of the rate_limit section
+func newRateLimiter(x *app.AppX) (*ratelimit.Limiter, error) {
+	cfg := x.Config.Conf.RateLimit
+	proxies, err := clientip.ParseProxies(*cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ratelimit.Rule
+	for _, rule := range *cfg.Rules {
+		rules = append(rules, ratelimit.Rule{
+			Methods: rule.Methods,
+			Key:     rule.Key,
+			Rate:    rule.Rate,
+			Burst:   rule.Burst,
+		})
+	}
+	return ratelimit.New(&ratelimit.Init{
+		Rules:          rules,
+		TrustedProxies: proxies,
+	})
+}
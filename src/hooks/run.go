@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/colors"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/certs"
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/run_manager"
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/update"
@@ -19,7 +20,10 @@ import (
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/logs"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/acl"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/gateway"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/ratelimit"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/session"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv1"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv2"
@@ -44,29 +48,30 @@ func Run(cmd *cobra.Command, args []string) {
 
 func RunHook(ctx context.Context, cs *corestate.CoreState, x *app.AppX) error {
 	ctxMain, cancelMain := context.WithCancel(ctx)
-	runLockFile := run_manager.File("run.lock")
-	_, err := runLockFile.Open()
+	egressPolicy, err := newEgressPolicy(x)
 	if err != nil {
-		x.Log.Fatalf("cannot open run.lock: %s", err)
-	}
-
-	_, err = runLockFile.Watch(ctxMain, func() {
-		x.Log.Printf("run.lock was touched")
 		_ = run_manager.Clean()
-		cancelMain()
-	})
-	if err != nil {
-		x.Log.Printf("watch error: %s", err)
+		x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
 	}
-
 	serverv1 := sv1.InitV1Server(&sv1.HandlerV1InitStruct{
 		X:          x,
 		CS:         cs,
 		AllowedCmd: regexp.MustCompile(AllowedCmdPattern),
 		Ver:        "v1",
+		Egress:     egressPolicy,
 	})
 
-	sv2 := sv2.InitServer(&sv2.HandlerInitStruct{
+	if *x.Config.Conf.Lua.Precompile {
+		count, err := serverv1.Precompile()
+		if err != nil {
+			x.Log.Printf("%s: Lua precompilation failed:\n%s", colors.PrintError(), err.Error())
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: the com directory contains broken scripts")
+		}
+		x.Log.Printf("Precompiled %d Lua scripts", count)
+	}
+
+	serverv2 := sv2.InitServer(&sv2.HandlerInitStruct{
 		X:          x,
 		CS:         cs,
 		AllowedCmd: regexp.MustCompile(AllowedCmdPattern),
@@ -75,21 +80,100 @@ func RunHook(ctx context.Context, cs *corestate.CoreState, x *app.AppX) error {
 
 	session_manager := session.New(*x.Config.Conf.HTTPServer.SessionTTL)
 
+	var authenticator *auth.Authenticator
+	if *x.Config.Conf.Auth.Enabled {
+		var err error
+		authenticator, err = newAuthenticator(x)
+		if err != nil {
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+		}
+	}
+
+	var policy *acl.ACL
+	if *x.Config.Conf.ACL.Enabled {
+		var err error
+		policy, err = newACL(x)
+		if err != nil {
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+		}
+		x.Log.Printf("ACL policy loaded, %d rules", policy.Rules())
+		go func() {
+			defer utils.CatchPanicWithCancel(cancelMain)
+			reloadACLOnSIGHUP(ctxMain, x, policy)
+		}()
+	}
+
+	var limiter *ratelimit.Limiter
+	if *x.Config.Conf.RateLimit.Enabled {
+		limiter, err = newRateLimiter(x)
+		if err != nil {
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+		}
+	}
+
 	s := gateway.InitGateway(&gateway.GatewayServerInit{
-		SM: session_manager,
-		CS: cs,
-		X:  x,
-	}, serverv1, sv2)
+		SM:             session_manager,
+		CS:             cs,
+		X:              x,
+		Auth:           authenticator,
+		ACL:            policy,
+		RateLimit:      limiter,
+		AllowedOrigins: *x.Config.Conf.HTTPServer.AllowedOrigins,
+	}, serverv1, serverv2)
+
+	if *x.Config.Conf.Node.HotReload {
+		if err := s.WatchComDir(ctxMain); err != nil {
+			x.Log.Printf("%s: Hot reload is disabled, cannot watch the com directory: %s", colors.PrintWarn(), err.Error())
+		}
+	}
+
+	// sockets go to run.lock before it is watched
+	sockets, err := listenSockets(x)
+	if err != nil {
+		_ = run_manager.Clean()
+		x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+	}
+
+	runLockFile := run_manager.File("run.lock")
+	_, err = runLockFile.Open()
+	if err != nil {
+		x.Log.Fatalf("cannot open run.lock: %s", err)
+	}
+
+	_, err = runLockFile.Watch(ctxMain, func() {
+		x.Log.Printf("run.lock was touched")
+		_ = run_manager.Clean()
+		cancelMain()
+	})
+	if err != nil {
+		x.Log.Printf("watch error: %s", err)
+	}
+
+	for _, l := range sockets {
+		go func() {
+			defer utils.CatchPanicWithCancel(cancelMain)
+			if err := s.ServeSocket(ctxMain, netutil.LimitListener(l, 100)); err != nil {
+				x.Log.Printf("%s: Socket listener on %s failed: %s", colors.PrintError(), l.Addr().String(), err.Error())
+				cancelMain()
+			}
+		}()
+	}
 
 	r := chi.NewRouter()
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"POST"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Session-UUID"},
+		AllowOriginFunc:  s.AllowOrigin,
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-CSRF-Token", "X-Session-UUID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
+	r.Use(s.AuthMiddleware)
 	r.HandleFunc(config.ComDirRoute, s.Handle)
+	r.Get(config.ComDirRoute+"/openrpc.json", s.HandleOpenRPC)
+	r.Get(config.ComDirRoute+"/ws", s.HandleWS)
 	r.Route("/favicon.ico", func(r chi.Router) {
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusNoContent)
@@ -104,6 +188,26 @@ func RunHook(ctx context.Context, cs *corestate.CoreState, x *app.AppX) error {
 			Level:  slog.LevelError,
 		}, "", 0),
 	}
+	srv.RegisterOnShutdown(s.CloseStreams)
+	if *x.Config.Conf.TLS.TlsEnabled {
+		srv.TLSConfig, err = tlsConfig(x)
+		if err != nil {
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+		}
+		store, renew, err := loadCertificate(cs, x)
+		if err != nil {
+			_ = run_manager.Clean()
+			x.Log.Fatalf("Unable to continue node operation: %s", err.Error())
+		}
+		srv.TLSConfig.GetCertificate = store.GetCertificate
+		certFile, _ := store.Files()
+		x.Log.Printf("TLS certificate %s, SHA-256 fingerprint %s", certFile, certs.Fingerprint(store.Leaf()))
+		go func() {
+			defer utils.CatchPanicWithCancel(cancelMain)
+			watchCertificate(ctxMain, x, store, renew)
+		}()
+	}
 
 	NodeApp.Fallback(func(ctx context.Context, cs *corestate.CoreState, x *app.AppX) {
 		if err := srv.Shutdown(ctxMain); err != nil {
@@ -112,6 +216,10 @@ func RunHook(ctx context.Context, cs *corestate.CoreState, x *app.AppX) error {
 			x.Log.Printf("Server stopped gracefully")
 		}
 
+		serverv1.Shutdown()
+		serverv2.Shutdown()
+		x.Log.Printf("Module workers stopped")
+
 		x.Log.Println("Cleaning up...")
 
 		if err := run_manager.Clean(); err != nil {
@@ -131,7 +239,7 @@ func RunHook(ctx context.Context, cs *corestate.CoreState, x *app.AppX) error {
 			}
 			x.Log.Printf("Serving on %s port %s with TLS... (https://%s%s)", *x.Config.Conf.HTTPServer.Address, *x.Config.Conf.HTTPServer.Port, fmt.Sprintf("%s:%s", *x.Config.Conf.HTTPServer.Address, *x.Config.Conf.HTTPServer.Port), config.ComDirRoute)
 			limitedListener := netutil.LimitListener(listener, 100)
-			if err := srv.ServeTLS(limitedListener, *x.Config.Conf.TLS.CertFile, *x.Config.Conf.TLS.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			if err := srv.ServeTLS(limitedListener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				x.Log.Printf("%s: Failed to start HTTPS server: %s", colors.PrintError(), err.Error())
 				cancelMain()
 			}
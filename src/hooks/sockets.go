@@ -0,0 +1,156 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/run_manager"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"gopkg.in/ini.v1"
+)
+
+// listenSockets opens the Unix and TCP listeners enabled in the config and
+// records where they are in the [runtime] section of run.lock.
+// Must be called before run.lock is watched, writing it counts as a touch.
+func listenSockets(x *app.AppX) ([]net.Listener, error) {
+	cfg := x.Config.Conf.Sockets
+	var listeners []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+	runtime := map[string]string{}
+
+	if *cfg.Unix.Enabled {
+		l, err := listenUnix(*cfg.Unix.Path, *cfg.Unix.Mode, *cfg.Unix.Owner)
+		if err != nil {
+			return fail(err)
+		}
+		listeners = append(listeners, l)
+		runtime["socket"] = *cfg.Unix.Path
+		x.Log.Printf("Serving on unix socket %s...", *cfg.Unix.Path)
+	}
+	if *cfg.TCP.Enabled {
+		l, err := net.Listen("tcp", *cfg.TCP.Address)
+		if err != nil {
+			return fail(fmt.Errorf("cannot listen on %s: %w", *cfg.TCP.Address, err))
+		}
+		listeners = append(listeners, l)
+		runtime["socket-tcp"] = l.Addr().String()
+		x.Log.Printf("Serving on tcp socket %s...", l.Addr().String())
+	}
+
+	if len(runtime) == 0 {
+		return nil, nil
+	}
+	lockPath, err := run_manager.Get("run.lock")
+	if err != nil {
+		return fail(err)
+	}
+	lockFile, err := ini.Load(lockPath)
+	if err != nil {
+		return fail(err)
+	}
+	secRun := lockFile.Section("runtime")
+	for key, value := range runtime {
+		secRun.Key(key).SetValue(value)
+	}
+	if err := lockFile.SaveTo(lockPath); err != nil {
+		return fail(err)
+	}
+	return listeners, nil
+}
+
+func listenUnix(path, mode, owner string) (net.Listener, error) {
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket mode %q", mode)
+	}
+	uid, gid := -1, -1
+	if owner != "" {
+		if uid, gid, err = lookupOwner(owner); err != nil {
+			return nil, err
+		}
+	}
+	// a socket left by a node that was killed blocks the path
+	if info, err := os.Lstat(path); err == nil && info.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// the socket is created in a directory only the node may enter and moved
+	// to the path once its mode and owner are set, nobody can connect before
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".socket-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %w", path, err)
+	}
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, fs.FileMode(perm)); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Chown(tmp, uid, gid); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener removes the socket under the path it was moved to on Close
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if rmErr := os.Remove(l.path); rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) && err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// lookupOwner resolves "user" or "user:group", -1 leaves the id unchanged
+func lookupOwner(owner string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,50 @@
+package hooks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+)
+
+// Client certificate modes of tls.client_auth
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
+// tlsConfig builds the TLS settings of the HTTP server. The server certificate
+// is not part of them: RunHook sets GetCertificate to the certs.Store of
+// loadCertificate, which watchCertificate reloads when the files change.
+func tlsConfig(x *app.AppX) (*tls.Config, error) {
+	cfg := x.Config.Conf.TLS
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	switch *cfg.ClientAuth {
+	case ClientAuthNone, "":
+		return conf, nil
+	case ClientAuthOptional:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("tls.client_auth must be %q, %q or %q, not %q", ClientAuthNone, ClientAuthOptional, ClientAuthRequired, *cfg.ClientAuth)
+	}
+
+	if *cfg.ClientCAFile == "" {
+		return nil, fmt.Errorf("tls.client_auth is %q but tls.client_ca_file is not set", *cfg.ClientAuth)
+	}
+	data, err := os.ReadFile(*cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", *cfg.ClientCAFile)
+	}
+	conf.ClientCAs = pool
+	return conf, nil
+}
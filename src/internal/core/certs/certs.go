@@ -0,0 +1,206 @@
+// Package certs keeps the TLS certificate of the node. The certificate is read
+// from disk again whenever its files change, so renewing it needs no restart,
+// and a self-signed one can be generated for nodes without a PKI.
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lifetime of generated certificates
+var (
+	SelfSignedValidity = 365 * 24 * time.Hour
+	// RenewBefore is how long before expiry a generated certificate is replaced
+	RenewBefore = 30 * 24 * time.Hour
+)
+
+// Generate writes a self-signed ECDSA P-256 certificate valid for the hosts,
+// names or IP addresses, and its key. The key file is only readable by the owner.
+func Generate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"GoSally node"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(SelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		// a leaf only, trusting it must not let its key sign other certificates
+		IsCA: false,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return err
+		}
+	}
+	// the key goes first, a certificate without its key would be loaded in vain
+	if err := writeFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return writeFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// writeFile replaces the file at once, a reader never sees half of it
+func writeFile(name string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Fingerprint is the SHA-256 of the certificate as colon separated hex,
+// the way browsers and openssl show it
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// Store holds the certificate loaded from a pair of files
+type Store struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+
+	mu sync.Mutex
+	// modified are the modification times of the files when they were loaded
+	modified [2]time.Time
+}
+
+// Load reads the certificate and key files
+func Load(certFile, keyFile string) (*Store, error) {
+	s := &Store{certFile: certFile, keyFile: keyFile}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the files again if either of them changed since the last load
+// and reports whether it did. On error the current certificate stays.
+func (s *Store) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var modified [2]time.Time
+	for i, file := range []string{s.certFile, s.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modified[i] = info.ModTime()
+	}
+	if s.cert.Load() != nil && modified == s.modified {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	s.modified = modified
+	s.cert.Store(&cert)
+	return true, nil
+}
+
+// Watch reloads the files every interval until ctx is done and calls
+// callback after every reload, and once for a failure that repeats.
+// before is called ahead of every attempt, it may be nil. Watch blocks.
+func (s *Store) Watch(ctx context.Context, interval time.Duration, before func(), callback func(reloaded bool, err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if before != nil {
+				before()
+			}
+			reloaded, err := s.Reload()
+			switch {
+			case err != nil && err.Error() != lastErr:
+				lastErr = err.Error()
+				callback(false, err)
+			case err == nil:
+				lastErr = ""
+				if reloaded {
+					callback(true, nil)
+				}
+			}
+		}
+	}
+}
+
+// GetCertificate serves as tls.Config.GetCertificate
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.Load(), nil
+}
+
+// Leaf returns the parsed certificate
+func (s *Store) Leaf() *x509.Certificate {
+	return s.cert.Load().Leaf
+}
+
+// Files returns the certificate and key files of the store
+func (s *Store) Files() (certFile, keyFile string) {
+	return s.certFile, s.keyFile
+}
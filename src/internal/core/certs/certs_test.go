@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls", "node.crt"), filepath.Join(dir, "tls", "node.key")
+	if err := Generate(certFile, keyFile, []string{"pi-01", "localhost", "192.168.1.20", "::1"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	s, err := Load(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := s.Leaf()
+	if _, ok := leaf.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("key is %T, want ECDSA", leaf.PublicKey)
+	}
+	if leaf.Subject.CommonName != "pi-01" || !slices.Equal(leaf.DNSNames, []string{"pi-01", "localhost"}) || len(leaf.IPAddresses) != 2 {
+		t.Errorf("names = %s %v %v", leaf.Subject.CommonName, leaf.DNSNames, leaf.IPAddresses)
+	}
+	if err := leaf.VerifyHostname("192.168.1.20"); err != nil {
+		t.Error(err)
+	}
+	if leaf.IsCA || leaf.KeyUsage != x509.KeyUsageDigitalSignature {
+		t.Errorf("IsCA = %v, key usage = %v; want a leaf for signatures only", leaf.IsCA, leaf.KeyUsage)
+	}
+	// clients trust it by pinning it as the root of itself
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "pi-01", Roots: roots}); err != nil {
+		t.Error(err)
+	}
+	if left := time.Until(leaf.NotAfter); left < SelfSignedValidity-2*time.Hour {
+		t.Errorf("valid for %v only", left)
+	}
+
+	fp := Fingerprint(leaf)
+	if len(fp) != 32*3-1 || strings.Count(fp, ":") != 31 {
+		t.Errorf("fingerprint = %q", fp)
+	}
+}
+
+func TestStore_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	if err := Generate(certFile, keyFile, []string{"first"}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := s.Reload(); reloaded || err != nil {
+		t.Errorf("unchanged files: reloaded = %v, err = %v", reloaded, err)
+	}
+
+	if err := Generate(certFile, keyFile, []string{"second"}); err != nil {
+		t.Fatal(err)
+	}
+	// the modification time may not move on coarse file systems
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if reloaded, err := s.Reload(); !reloaded || err != nil {
+		t.Fatalf("changed files: reloaded = %v, err = %v", reloaded, err)
+	}
+	cert, _ := s.GetCertificate(nil)
+	if cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("serving %q after the reload", cert.Leaf.Subject.CommonName)
+	}
+
+	// a broken file leaves the certificate in place
+	os.WriteFile(certFile, []byte("garbage"), 0o644)
+	os.Chtimes(certFile, future.Add(time.Minute), future.Add(time.Minute))
+	if _, err := s.Reload(); err == nil {
+		t.Error("a broken certificate was loaded")
+	}
+	if s.Leaf().Subject.CommonName != "second" {
+		t.Error("a broken file replaced the certificate")
+	}
+}
@@ -0,0 +1,148 @@
+// Package dirwatch reports changes in a directory tree. It relies on fsnotify
+// and falls back to polling modification times, like RunFileManager.Watch,
+// where fsnotify cannot be used.
+package dirwatch
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+type Mode string
+
+const (
+	ModeNotify Mode = "fsnotify"
+	ModePoll   Mode = "polling"
+)
+
+// Debounce is the quiet time after the last event before the callback is called,
+// so a deploy that writes many files causes a single call
+var Debounce = 250 * time.Millisecond
+
+// Watch calls callback every time something under dir changes, until ctx is done.
+// Calls are never concurrent. interval is the polling period of the fallback mode.
+func Watch(ctx context.Context, dir string, interval time.Duration, callback func()) (Mode, error) {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = addTree(w, dir); err != nil {
+			w.Close()
+		}
+	}
+	if err == nil {
+		go notify(ctx, w, callback)
+		return ModeNotify, nil
+	}
+
+	state, err := scan(dir)
+	if err != nil {
+		return "", err
+	}
+	go poll(ctx, dir, interval, state, callback)
+	return ModePoll, nil
+}
+
+// addTree watches the directory and all of its subdirectories,
+// fsnotify is not recursive by itself
+func addTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
+
+func notify(ctx context.Context, w *fsnotify.Watcher, callback func()) {
+	defer w.Close()
+
+	timer := time.NewTimer(Debounce)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					_ = addTree(w, event.Name)
+				}
+			}
+			timer.Reset(Debounce)
+		case _, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// events may have been lost, better to look at everything again
+			timer.Reset(Debounce)
+		case <-timer.C:
+			callback()
+		}
+	}
+}
+
+type stamp struct {
+	modTime int64
+	size    int64
+	mode    fs.FileMode
+}
+
+func scan(dir string) (map[string]stamp, error) {
+	state := make(map[string]stamp)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state[path] = stamp{modTime: info.ModTime().UnixNano(), size: info.Size(), mode: info.Mode()}
+		return nil
+	})
+	return state, err
+}
+
+func poll(ctx context.Context, dir string, interval time.Duration, state map[string]stamp, callback func()) {
+	ticker := time.NewTicker(max(interval, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := scan(dir)
+		if err != nil {
+			// the directory is being rewritten, try again on the next tick
+			continue
+		}
+		if !sameState(state, current) {
+			state = current
+			callback()
+		}
+	}
+}
+
+func sameState(a, b map[string]stamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, s := range a {
+		if other, ok := b[path]; !ok || other != s {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package dirwatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPoll(t *testing.T) {
+	dir := t.TempDir()
+	state, err := scan(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go poll(ctx, dir, time.Second, state, func() { calls <- struct{}{} })
+
+	if err := os.WriteFile(filepath.Join(dir, "Echo.lua"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calls:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the new file was not noticed")
+	}
+
+	select {
+	case <-calls:
+		t.Fatal("callback called without a change")
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
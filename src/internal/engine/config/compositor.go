@@ -47,20 +47,75 @@ func (c *Compositor) LoadConf(path string) error {
 	v.SetDefault("node.mode", "dev")
 	v.SetDefault("node.show_config", "false")
 	v.SetDefault("node.com_dir", "./com/")
+	v.SetDefault("node.hot_reload", false)
+	v.SetDefault("node.reload_interval", "2s")
+	v.SetDefault("node.batch_concurrency", 4)
 	v.SetDefault("http_server.address", "0.0.0.0")
 	v.SetDefault("http_server.port", "8080")
 	v.SetDefault("http_server.session_ttl", "30m")
 	v.SetDefault("http_server.timeout", "5s")
 	v.SetDefault("http_server.idle_timeout", "60s")
+	v.SetDefault("http_server.allowed_origins", []string{})
 	v.SetDefault("tls.enabled", false)
 	v.SetDefault("tls.cert_file", "./cert/server.crt")
 	v.SetDefault("tls.key_file", "./cert/server.key")
+	v.SetDefault("tls.client_ca_file", "")
+	v.SetDefault("tls.client_auth", "none")
+	v.SetDefault("tls.self_signed", false)
+	v.SetDefault("tls.reload_interval", "1m")
 	v.SetDefault("updates.enabled", false)
 	v.SetDefault("updates.check_interval", "2h")
 	v.SetDefault("updates.wanted_version", "latest-stable")
 	v.SetDefault("log.json_format", "false")
 	v.SetDefault("log.level", "info")
 	v.SetDefault("log.output", "%2%")
+	v.SetDefault("lua.pool_size", 16)
+	v.SetDefault("lua.precompile", false)
+	v.SetDefault("lua.timeout", "10s")
+	v.SetDefault("lua.instruction_limit", 0)
+	v.SetDefault("lua.registry_size", 1024*20)
+	v.SetDefault("lua.registry_max_size", 1024*80)
+	v.SetDefault("lua.callstack_size", 256)
+	v.SetDefault("lua.memory_limit", 0)
+	v.SetDefault("lua.memory_check_interval", 50000)
+	v.SetDefault("lua.argon2.max_memory", 64*1024)
+	v.SetDefault("lua.argon2.max_concurrent", 2)
+	v.SetDefault("lua.http.timeout", "30s")
+	v.SetDefault("lua.http.max_body", 10<<20)
+	v.SetDefault("lua.http.max_redirects", 10)
+	v.SetDefault("lua.http.egress.default", "allow")
+	v.SetDefault("lua.http.egress.block_private", true)
+	v.SetDefault("lua.http.egress.rules", []map[string]any{})
+	v.SetDefault("sv2.timeout", "10s")
+	v.SetDefault("sv2.max_output", 1<<20)
+	v.SetDefault("sockets.unix.enabled", false)
+	v.SetDefault("sockets.unix.path", "%tmp%/node.sock")
+	v.SetDefault("sockets.unix.mode", "0600")
+	v.SetDefault("sockets.unix.owner", "")
+	v.SetDefault("sockets.tcp.enabled", false)
+	v.SetDefault("sockets.tcp.address", "127.0.0.1:8081")
+	v.SetDefault("limits.max_body_size", 1<<20)
+	v.SetDefault("limits.max_batch_length", 100)
+	v.SetDefault("limits.max_depth", 64)
+	v.SetDefault("limits.max_in_flight", 256)
+	v.SetDefault("rate_limit.enabled", false)
+	v.SetDefault("rate_limit.trusted_proxies", []string{})
+	v.SetDefault("rate_limit.rules", []map[string]any{})
+	v.SetDefault("auth.enabled", false)
+	v.SetDefault("auth.api_keys_file", "")
+	v.SetDefault("auth.jwt.hs256_secret_file", "")
+	v.SetDefault("auth.jwt.rs256_public_key_file", "")
+	v.SetDefault("auth.jwt.eddsa_public_key_file", "")
+	v.SetDefault("auth.jwt.issuer", "")
+	v.SetDefault("auth.jwt.audience", "")
+	v.SetDefault("auth.jwt.leeway", "30s")
+	v.SetDefault("auth.jwt.principal_claim", "sub")
+	v.SetDefault("auth.jwt.roles_claim", "roles")
+	v.SetDefault("auth.jwt.permissions_claim", "scope")
+	v.SetDefault("auth.protected", []string{})
+	v.SetDefault("acl.enabled", false)
+	v.SetDefault("acl.file", "./cfg/acl.yaml")
+	v.SetDefault("acl.trusted_proxies", []string{})
 	v.SetDefault("disable_warnings", []string{})
 
 	if err := v.ReadInConfig(); err != nil {
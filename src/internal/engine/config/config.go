@@ -23,6 +23,13 @@ type Conf struct {
 	TLS             *TLS        `mapstructure:"tls"`
 	Updates         *Updates    `mapstructure:"updates"`
 	Log             *Log        `mapstructure:"log"`
+	Lua             *Lua        `mapstructure:"lua"`
+	SV2             *SV2        `mapstructure:"sv2"`
+	Sockets         *Sockets    `mapstructure:"sockets"`
+	Limits          *Limits     `mapstructure:"limits"`
+	RateLimit       *RateLimit  `mapstructure:"rate_limit"`
+	Auth            *Auth       `mapstructure:"auth"`
+	ACL             *ACL        `mapstructure:"acl"`
 	DisableWarnings *[]string   `mapstructure:"disable_warnings"`
 }
 
@@ -31,6 +38,14 @@ type Node struct {
 	Name       *string `mapstructure:"name"`
 	ShowConfig *bool   `mapstructure:"show_config"`
 	ComDir     *string `mapstructure:"com_dir"`
+	// HotReload watches ComDir and picks up changed methods without a restart,
+	// it is off unless enabled
+	HotReload *bool `mapstructure:"hot_reload"`
+	// ReloadInterval is how often ComDir is scanned when fsnotify is unavailable
+	ReloadInterval *time.Duration `mapstructure:"reload_interval"`
+	// BatchConcurrency is the number of requests of one batch handled at the same time,
+	// 1 handles them one after another, 0 all at once
+	BatchConcurrency *int `mapstructure:"batch_concurrency"`
 }
 
 type HTTPServer struct {
@@ -39,12 +54,25 @@ type HTTPServer struct {
 	SessionTTL  *time.Duration `mapstructure:"session_ttl"`
 	Timeout     *time.Duration `mapstructure:"timeout"`
 	IdleTimeout *time.Duration `mapstructure:"idle_timeout"`
+	// AllowedOrigins are the origins like "https://panel.example.com" whose pages may
+	// open WebSockets and read cross-origin answers, "*" allows any.
+	// The node's own origin is always allowed.
+	AllowedOrigins *[]string `mapstructure:"allowed_origins"`
 }
 
 type TLS struct {
 	TlsEnabled *bool   `mapstructure:"enabled"`
 	CertFile   *string `mapstructure:"cert_file"`
 	KeyFile    *string `mapstructure:"key_file"`
+	// ClientCAFile is a PEM bundle of the CAs client certificates are verified against
+	ClientCAFile *string `mapstructure:"client_ca_file"`
+	// ClientAuth is "none", "optional" (verified if presented) or "required"
+	ClientAuth *string `mapstructure:"client_auth"`
+	// SelfSigned generates a certificate into the meta directory
+	// when CertFile or KeyFile does not exist, and renews it before it expires
+	SelfSigned *bool `mapstructure:"self_signed"`
+	// ReloadInterval is how often the certificate files are checked for changes
+	ReloadInterval *time.Duration `mapstructure:"reload_interval"`
 }
 
 type Updates struct {
@@ -60,6 +88,183 @@ type Log struct {
 	OutPath *string `mapstructure:"output"`
 }
 
+// Lua contains settings for Lua methods (context-version "v1")
+type Lua struct {
+	// PoolSize caps the number of Lua states running at the same time
+	PoolSize *int `mapstructure:"pool_size"`
+	// Precompile compiles the whole com directory at startup,
+	// the node refuses to start if any script has a syntax error
+	Precompile *bool `mapstructure:"precompile"`
+	// Timeout is the default deadline of a script, a method sidecar can override it
+	Timeout *time.Duration `mapstructure:"timeout"`
+	// InstructionLimit is the default number of VM instructions a script may run, 0 is unlimited
+	InstructionLimit *int64 `mapstructure:"instruction_limit"`
+	// RegistrySize, RegistryMaxSize and CallStackSize bound the stacks of every Lua state
+	RegistrySize    *int `mapstructure:"registry_size"`
+	RegistryMaxSize *int `mapstructure:"registry_max_size"`
+	CallStackSize   *int `mapstructure:"callstack_size"`
+	// MemoryLimit is the approximate number of bytes a script may hold, 0 is unlimited
+	MemoryLimit *int64 `mapstructure:"memory_limit"`
+	// MemoryCheckInterval is the number of instructions between two memory estimates,
+	// big strings created in between and the builtins building strings are charged at once
+	MemoryCheckInterval *int64 `mapstructure:"memory_check_interval"`
+	// HTTP holds the defaults of net.http.request
+	HTTP *LuaHTTP `mapstructure:"http"`
+	// Argon2 bounds internal.crypt.argon2
+	Argon2 *LuaArgon2 `mapstructure:"argon2"`
+}
+
+// LuaArgon2 limits the argon2 calls of the scripts, which allocate
+// outside the Lua state
+type LuaArgon2 struct {
+	// MaxMemory is the most memory in KiB of one hash or verify,
+	// a stored hash asking for more is rejected
+	MaxMemory *int `mapstructure:"max_memory"`
+	// MaxConcurrent is the number of calls running at the same time on the node,
+	// the others wait for a free slot until their script's deadline
+	MaxConcurrent *int `mapstructure:"max_concurrent"`
+}
+
+// LuaHTTP contains the defaults of the HTTP client of the scripts,
+// a request may lower the timeout and max_body
+type LuaHTTP struct {
+	Timeout *time.Duration `mapstructure:"timeout"`
+	// MaxBody is the largest response body read, 0 is unlimited
+	MaxBody      *int64 `mapstructure:"max_body"`
+	MaxRedirects *int   `mapstructure:"max_redirects"`
+	// Egress restricts the destinations of the scripts
+	Egress *LuaEgress `mapstructure:"egress"`
+}
+
+// LuaEgress is the policy of outgoing HTTP requests of the scripts.
+// The first rule matching the calling method and the destination applies.
+type LuaEgress struct {
+	// Default is "allow" or "deny" for destinations no rule matches
+	Default *string `mapstructure:"default"`
+	// BlockPrivate refuses loopback, private and link-local addresses
+	// after DNS resolution, unless the cidrs of the rule contain them
+	BlockPrivate *bool            `mapstructure:"block_private"`
+	Rules        *[]LuaEgressRule `mapstructure:"rules"`
+}
+
+// LuaEgressRule allows the methods matching a pattern like "Unit.*" to reach
+// the hosts and cidrs. Only its destinations receive the initiator headers
+// if ForwardInitiator is set.
+type LuaEgressRule struct {
+	Methods          string   `mapstructure:"methods"`
+	Hosts            []string `mapstructure:"hosts"`
+	CIDRs            []string `mapstructure:"cidrs"`
+	Schemes          []string `mapstructure:"schemes"`
+	ForwardInitiator bool     `mapstructure:"forward_initiator"`
+}
+
+// SV2 contains settings for process modules (context-version "v2")
+type SV2 struct {
+	// Timeout is the maximum lifetime of a module process for one call
+	Timeout *time.Duration `mapstructure:"timeout"`
+	// MaxOutput limits the size of the module's stdout in bytes
+	MaxOutput *int `mapstructure:"max_output"`
+}
+
+// Sockets contains settings for JSON-RPC listeners without HTTP.
+// Messages are either one per line or framed with a Content-Length header.
+type Sockets struct {
+	Unix *UnixSocket `mapstructure:"unix"`
+	TCP  *TCPSocket  `mapstructure:"tcp"`
+}
+
+type UnixSocket struct {
+	Enabled *bool   `mapstructure:"enabled"`
+	Path    *string `mapstructure:"path"`
+	// Mode is the octal permission of the socket file, like "0660"
+	Mode *string `mapstructure:"mode"`
+	// Owner is "user" or "user:group", the socket keeps the node's owner if empty
+	Owner *string `mapstructure:"owner"`
+}
+
+type TCPSocket struct {
+	Enabled *bool   `mapstructure:"enabled"`
+	Address *string `mapstructure:"address"`
+}
+
+// Limits protect the node from requests that are too large or too many, 0 disables a limit
+type Limits struct {
+	// MaxBodySize is the size of a request body or message in bytes
+	MaxBodySize *int64 `mapstructure:"max_body_size"`
+	// MaxBatchLength is the number of requests in one batch
+	MaxBatchLength *int `mapstructure:"max_batch_length"`
+	// MaxDepth is how deep objects and arrays of a request may be nested
+	MaxDepth *int `mapstructure:"max_depth"`
+	// MaxInFlight is the number of requests the node handles at the same time,
+	// over every transport and session
+	MaxInFlight *int `mapstructure:"max_in_flight"`
+}
+
+// RateLimit limits how often clients call methods, whatever the transport
+type RateLimit struct {
+	Enabled *bool `mapstructure:"enabled"`
+	// TrustedProxies are the CIDRs of the reverse proxies whose
+	// X-Forwarded-For is believed when limiting by ip
+	TrustedProxies *[]string        `mapstructure:"trusted_proxies"`
+	Rules          *[]RateLimitRule `mapstructure:"rules"`
+}
+
+// RateLimitRule limits the methods matching a pattern like "Unit.*",
+// the first matching rule applies
+type RateLimitRule struct {
+	Methods string `mapstructure:"methods"`
+	// Key is "ip", "session" or "principal", the last two count by ip
+	// when the request has no verified principal
+	Key string `mapstructure:"key"`
+	// Rate is the number of calls per second, Burst the number of calls at once
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// Auth authenticates the clients with API keys and JWTs.
+// Unix and TCP socket connections are never authenticated.
+type Auth struct {
+	Enabled *bool `mapstructure:"enabled"`
+	// APIKeysFile is a YAML list of {principal, key or sha256, roles, permissions}
+	APIKeysFile *string `mapstructure:"api_keys_file"`
+	JWT         *JWT    `mapstructure:"jwt"`
+	// Protected are patterns like "Unit.*" of the methods that need a principal,
+	// methods with permissions in their metadata need one as well
+	Protected *[]string `mapstructure:"protected"`
+}
+
+// JWT configures the keys tokens are verified with, an algorithm
+// without a key is rejected. Tokens must carry "exp", a WebSocket
+// opened with a token is closed when it expires.
+type JWT struct {
+	HS256SecretFile    *string        `mapstructure:"hs256_secret_file"`
+	RS256PublicKeyFile *string        `mapstructure:"rs256_public_key_file"`
+	EdDSAPublicKeyFile *string        `mapstructure:"eddsa_public_key_file"`
+	Issuer             *string        `mapstructure:"issuer"`
+	Audience           *string        `mapstructure:"audience"`
+	Leeway             *time.Duration `mapstructure:"leeway"`
+	// PrincipalClaim, RolesClaim and PermissionsClaim name the claims
+	// the principal is read from
+	PrincipalClaim   *string `mapstructure:"principal_claim"`
+	RolesClaim       *string `mapstructure:"roles_claim"`
+	PermissionsClaim *string `mapstructure:"permissions_claim"`
+}
+
+// ACL checks every call against the policy file, see package acl.
+// The file is read again on SIGHUP. It must set "default" to "allow" or
+// "deny" for the methods no rule matches, the node refuses a file without it:
+//
+//	default: deny
+//	rules:
+//	  - methods: "Unit.*"
+//	    roles: [ops]
+type ACL struct {
+	Enabled *bool   `mapstructure:"enabled"`
+	File    *string `mapstructure:"file"`
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For is believed
+	TrustedProxies *[]string `mapstructure:"trusted_proxies"`
+}
+
 // ConfigEnv structure for environment variables
 type Env struct {
 	ConfigPath     *string `mapstructure:"config_path"`
@@ -68,8 +273,9 @@ type Env struct {
 }
 
 type CMDLine struct {
-	Run  Run
-	Node Root
+	Run     Run
+	Node    Root
+	OpenRPC OpenRPC
 }
 
 type Root struct {
@@ -80,3 +286,10 @@ type Run struct {
 	ConfigPath string `persistent:"true" full:"config" short:"c" def:"./config.yaml" desc:"Path to configuration file"`
 	Test       []int  `persistent:"true" full:"test" short:"t" def:"" desc:"js test"`
 }
+
+type OpenRPC struct {
+	ConfigPath     string `full:"config" short:"c" def:"./config.yaml" desc:"Path to configuration file"`
+	Output         string `full:"output" short:"o" def:"" desc:"Write the document to the file instead of stdout"`
+	ContextVersion string `full:"context-version" short:"v" def:"" desc:"Describe only the methods of the context version"`
+	ServerURL      string `full:"server-url" short:"s" def:"" desc:"URL of the node to put into the document"`
+}
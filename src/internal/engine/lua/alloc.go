@@ -0,0 +1,169 @@
+package lua
+
+import (
+	"math"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Allocate charges n bytes against the memory limit of the script running
+// in the state, if any. Go functions call it before building a value whose
+// size is not bounded by their arguments.
+func Allocate(L *lua.LState, n int64) error {
+	if c, ok := L.Context().(*ExecContext); ok {
+		return c.Allocate(n)
+	}
+	return nil
+}
+
+// mustAllocate is Allocate raising the error in the script
+func mustAllocate(L *lua.LState, n int64) {
+	if err := Allocate(L, n); err != nil {
+		L.RaiseError("%s", err.Error())
+	}
+}
+
+// numberSize is the most a number takes as a string
+const numberSize = 24
+
+// LimitAllocations replaces the builtins able to build a string much larger
+// than their arguments in one call with versions that charge it first:
+// string.rep, string.format, string.gsub and table.concat.
+// It is meant for LuaPoolInit.Prepare.
+func LimitAllocations(L *lua.LState) {
+	if str, ok := L.GetGlobal("string").(*lua.LTable); ok {
+		wrap(L, str, "rep", func(L *lua.LState) {
+			s, n := L.CheckString(1), L.CheckInt(2)
+			if n > 0 && len(s) > 0 {
+				if n > math.MaxInt64/len(s) {
+					mustAllocate(L, math.MaxInt64)
+				}
+				mustAllocate(L, int64(len(s))*int64(n))
+			}
+		})
+		wrap(L, str, "format", func(L *lua.LState) {
+			format := L.CheckString(1)
+			checkFormat(L, format)
+			// %q may double a string, other verbs are bounded by the width
+			size := int64(len(format))
+			for i := 2; i <= L.GetTop(); i++ {
+				size += 2*int64(len(lua.LVAsString(L.Get(i)))) + 100
+			}
+			mustAllocate(L, size)
+		})
+		wrap(L, str, "gsub", func(L *lua.LState) {
+			s := L.CheckString(1)
+			matches := int64(len(s)) + 1
+			if n, ok := L.Get(4).(lua.LNumber); ok {
+				matches = min(matches, max(int64(n), 0))
+			}
+			switch repl := L.Get(3).(type) {
+			case lua.LString:
+				// every %0..%9 of the replacement may expand to the whole string
+				escapes := int64(0)
+				for i := 0; i+1 < len(repl); i++ {
+					if repl[i] == '%' {
+						escapes++
+						i++
+					}
+				}
+				mustAllocate(L, int64(len(s))+matches*(int64(len(repl))+escapes*int64(len(s))))
+			case *lua.LFunction, *lua.LTable:
+				// the values are only known one match at a time
+				L.Replace(3, chargedReplacement(L, repl))
+			}
+		})
+	}
+	if tbl, ok := L.GetGlobal("table").(*lua.LTable); ok {
+		wrap(L, tbl, "concat", func(L *lua.LState) {
+			t := L.CheckTable(1)
+			sep := int64(len(L.OptString(2, "")))
+			i, j := L.OptInt(3, 1), L.OptInt(4, t.Len())
+			var size int64
+			for k := max(i, 1); k <= min(j, t.Len()); k++ {
+				switch v := t.RawGetInt(k).(type) {
+				case lua.LString:
+					size += int64(len(v)) + sep
+				case lua.LNumber:
+					size += numberSize + sep
+				}
+			}
+			mustAllocate(L, size)
+		})
+	}
+}
+
+// wrap replaces the function of the table with one calling check first
+func wrap(L *lua.LState, tbl *lua.LTable, name string, check func(L *lua.LState)) {
+	orig, ok := tbl.RawGetString(name).(*lua.LFunction)
+	if !ok || !orig.IsG {
+		return
+	}
+	tbl.RawSetString(name, L.NewFunction(func(L *lua.LState) int {
+		if c, ok := L.Context().(*ExecContext); ok && c.memLimit > 0 {
+			c.hold()
+			defer c.release()
+		}
+		check(L)
+		return orig.GFunction(L)
+	}))
+}
+
+// chargedReplacement returns a function for string.gsub doing what the
+// function or table repl does and charging every replacement
+func chargedReplacement(L *lua.LState, repl lua.LValue) *lua.LFunction {
+	return L.NewFunction(func(L *lua.LState) int {
+		var v lua.LValue
+		if fn, ok := repl.(*lua.LFunction); ok {
+			n := L.GetTop()
+			L.Push(fn)
+			for i := 1; i <= n; i++ {
+				L.Push(L.Get(i))
+			}
+			L.Call(n, 1)
+			v = L.Get(-1)
+			L.Pop(1)
+		} else {
+			v = L.GetTable(repl, L.Get(1))
+		}
+		switch v := v.(type) {
+		case lua.LString:
+			mustAllocate(L, int64(len(v)))
+		case lua.LNumber:
+			mustAllocate(L, numberSize)
+		}
+		L.Push(v)
+		return 1
+	})
+}
+
+// checkFormat rejects widths and precisions of more than two digits like
+// Lua does, string.format passes them to fmt which would pad to any size
+func checkFormat(L *lua.LState, format string) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && (format[i] == '-' || format[i] == '+' || format[i] == ' ' || format[i] == '#' || format[i] == '0') {
+			i++
+		}
+		digits := 0
+		for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+			digits++
+			i++
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			precision := 0
+			for i < len(format) && format[i] >= '0' && format[i] <= '9' {
+				precision++
+				i++
+			}
+			digits = max(digits, precision)
+		}
+		if digits > 2 {
+			L.RaiseError("invalid format (width or precision too long)")
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package lua
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// ProtoCache keeps compiled Lua chunks by file path, along with one value
+// the caller derives from the file, like the metadata of a method.
+// A chunk is compiled again as soon as the file's mtime or size changes,
+// the value is loaded again when the file or its companion file changes.
+// Compiled chunks are immutable, so one chunk is shared by all states.
+type ProtoCache struct {
+	mu      sync.RWMutex
+	entries map[string]*protoEntry
+}
+
+// protoEntry is replaced as a whole, never modified once stored
+type protoEntry struct {
+	stamp fileStamp
+	// proto and value are nil until they are asked for
+	proto *lua.FunctionProto
+	value any
+	// companion is the stamp of the companion file value was loaded with, zero without one
+	companion fileStamp
+}
+
+// fileStamp tells whether a file changed
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(info fs.FileInfo) fileStamp {
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}
+}
+
+func (s fileStamp) same(other fileStamp) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+func NewProtoCache() *ProtoCache {
+	return &ProtoCache{
+		entries: make(map[string]*protoEntry),
+	}
+}
+
+// lookup returns the entry of the file if it is still up to date,
+// and the current stamp of the file
+func (c *ProtoCache) lookup(path string) (*protoEntry, fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		c.Invalidate(path)
+		return nil, fileStamp{}, err
+	}
+	stamp := stampOf(info)
+
+	c.mu.RLock()
+	entry, ok := c.entries[path]
+	c.mu.RUnlock()
+	if !ok || !entry.stamp.same(stamp) {
+		return nil, stamp, nil
+	}
+	return entry, stamp, nil
+}
+
+// store updates the entry of the file seen with the stamp,
+// starting a new one if the file changed meanwhile
+func (c *ProtoCache) store(path string, stamp fileStamp, update func(*protoEntry)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := protoEntry{stamp: stamp}
+	if old, ok := c.entries[path]; ok && old.stamp.same(stamp) {
+		entry = *old
+	}
+	update(&entry)
+	c.entries[path] = &entry
+}
+
+// Get returns the compiled chunk of the file, compiling it if needed
+func (c *ProtoCache) Get(path string) (*lua.FunctionProto, error) {
+	entry, stamp, err := c.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil && entry.proto != nil {
+		return entry.proto, nil
+	}
+
+	proto, err := CompileFile(path)
+	if err != nil {
+		c.Invalidate(path)
+		return nil, err
+	}
+	c.store(path, stamp, func(e *protoEntry) { e.proto = proto })
+	return proto, nil
+}
+
+// Attached returns the value load derives from the file and its companion,
+// calling load only when one of them changed. A missing companion is not
+// an error, load decides what it means. The returned value is shared
+// and must not be modified.
+func (c *ProtoCache) Attached(path, companion string, load func() (any, error)) (any, error) {
+	entry, stamp, err := c.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	var companionStamp fileStamp
+	switch info, err := os.Stat(companion); {
+	case err == nil:
+		companionStamp = stampOf(info)
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+	if entry != nil && entry.value != nil && entry.companion.same(companionStamp) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, stamp, func(e *protoEntry) { e.value, e.companion = value, companionStamp })
+	return value, nil
+}
+
+// Invalidate drops the compiled chunk of the file
+func (c *ProtoCache) Invalidate(path string) {
+	c.mu.Lock()
+	delete(c.entries, path)
+	c.mu.Unlock()
+}
+
+// Purge drops every compiled chunk
+func (c *ProtoCache) Purge() {
+	c.mu.Lock()
+	c.entries = make(map[string]*protoEntry)
+	c.mu.Unlock()
+}
+
+// Precompile compiles every .lua file under dir and returns the number of
+// compiled files. All syntax errors are collected, not just the first one.
+func (c *ProtoCache) Precompile(dir string) (int, error) {
+	var count int
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".lua") {
+			return nil
+		}
+		if _, err := c.Get(path); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return count, errors.Join(errs...)
+}
+
+// CompileFile parses and compiles a Lua file without running it
+func CompileFile(path string) (*lua.FunctionProto, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	chunk, err := parse.Parse(bufio.NewReader(file), path)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, path)
+}
+
+// DoProto runs a compiled chunk the same way L.DoFile runs a file
+func DoProto(L *lua.LState, proto *lua.FunctionProto) error {
+	L.Push(L.NewFunctionFromProto(proto))
+	return L.PCall(0, lua.MultRet, nil)
+}
@@ -0,0 +1,165 @@
+package lua
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(tb testing.TB, path, content string) {
+	tb.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestProtoCache_Invalidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Method.lua")
+	writeFile(t, path, `return 1`)
+	c := NewProtoCache()
+
+	first, err := c.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Get(path); again != first {
+		t.Error("an unchanged file was compiled again")
+	}
+
+	// another size
+	writeFile(t, path, `return 10`)
+	sized, err := c.Get(path)
+	if err != nil || sized == first {
+		t.Errorf("a file of another size was not compiled again: %v", err)
+	}
+
+	// the same size, another mtime
+	writeFile(t, path, `return 20`)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	touched, err := c.Get(path)
+	if err != nil || touched == sized {
+		t.Errorf("a file with another mtime was not compiled again: %v", err)
+	}
+
+	// a broken file is not served from the cache
+	writeFile(t, path, `return (`)
+	if _, err := c.Get(path); err == nil {
+		t.Error("a syntax error was not reported")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("removed file = %v, want not exist", err)
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("%d entries left for removed files", len(c.entries))
+	}
+}
+
+func TestProtoCache_Attached(t *testing.T) {
+	dir := t.TempDir()
+	path, companion := filepath.Join(dir, "Method.lua"), filepath.Join(dir, "Method.meta.json")
+	writeFile(t, path, "return 1\n")
+	c := NewProtoCache()
+
+	// load reads the companion if there is one, the script otherwise
+	loads := 0
+	load := func() (any, error) {
+		loads++
+		data, err := os.ReadFile(companion)
+		if errors.Is(err, fs.ErrNotExist) {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(string(data), "broken") {
+			return nil, errors.New("broken")
+		}
+		return string(data), nil
+	}
+	attached := func() any {
+		t.Helper()
+		v, err := c.Attached(path, companion, load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if v := attached(); v != "return 1\n" {
+		t.Fatalf("value = %q", v)
+	}
+	proto, err := c.Get(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached()
+	if loads != 1 {
+		t.Errorf("an unchanged value was loaded %d times", loads)
+	}
+	if again, _ := c.Get(path); again != proto {
+		t.Error("loading the value dropped the compiled chunk")
+	}
+
+	writeFile(t, companion, "from the companion")
+	if v := attached(); v != "from the companion" {
+		t.Errorf("after adding the companion = %q", v)
+	}
+	if again, _ := c.Get(path); again != proto {
+		t.Error("a changed companion dropped the compiled chunk")
+	}
+	if err := os.Remove(companion); err != nil {
+		t.Fatal(err)
+	}
+	if v := attached(); v != "return 1\n" {
+		t.Errorf("after removing the companion = %q", v)
+	}
+
+	writeFile(t, companion, "broken")
+	if _, err := c.Attached(path, companion, load); err == nil {
+		t.Error("an error of load was not reported")
+	}
+	writeFile(t, path, "return 22\n")
+	writeFile(t, companion, "changed")
+	if v := attached(); v != "changed" {
+		t.Errorf("after changing the script = %q", v)
+	}
+}
+
+func TestProtoCache_Precompile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "Echo.lua"), `return 1`)
+	writeFile(t, filepath.Join(dir, "Unit", "Get.lua"), `local x = 1 return x`)
+	writeFile(t, filepath.Join(dir, "Broken.lua"), `return (`)
+	writeFile(t, filepath.Join(dir, "Unit", "Broken.lua"), `end`)
+	writeFile(t, filepath.Join(dir, "Tools", "Disk.sh"), `#!/bin/sh`)
+	c := NewProtoCache()
+
+	n, err := c.Precompile(dir)
+	if n != 2 {
+		t.Errorf("compiled %d scripts, want 2", n)
+	}
+	// every broken script is reported
+	if err == nil || !strings.Contains(err.Error(), "Broken.lua") || !strings.Contains(err.Error(), filepath.Join("Unit", "Broken.lua")) {
+		t.Errorf("err = %v, want both broken scripts", err)
+	}
+	if _, ok := c.entries[filepath.Join(dir, "Unit", "Get.lua")]; !ok {
+		t.Error("a compiled script is not cached")
+	}
+
+	if _, err := c.Precompile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing directory was not reported")
+	}
+}
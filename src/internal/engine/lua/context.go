@@ -0,0 +1,182 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"unsafe"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ErrInstructionLimit is the cancellation cause of a script
+// that has used up its instruction budget
+var ErrInstructionLimit = errors.New("instruction limit exceeded")
+
+// ExecContext is meant for LState.SetContext. gopher-lua checks Done before
+// every VM instruction, which makes it possible to count instructions
+// without patching the VM. Instructions of coroutines are not counted.
+type ExecContext struct {
+	context.Context
+	cancel context.CancelCauseFunc
+
+	limit int64
+	count atomic.Int64
+
+	// memory limit, estimated every memInterval instructions.
+	// memUsed is the last estimate plus the strings and allocations
+	// seen since, seen holds the strings already added to it.
+	state       *lua.LState
+	memLimit    int64
+	memInterval int64
+	memUsed     int64
+	seen        map[*byte]struct{}
+	// held is what was charged while a builtin of LimitAllocations runs,
+	// its result is not in the state yet so estimates do not see it
+	held    int64
+	holding int
+}
+
+// NewExecContext returns a context that is canceled with ErrInstructionLimit
+// after limit instructions. A limit of 0 or less means no limit.
+func NewExecContext(parent context.Context, limit int64) (*ExecContext, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	ec := &ExecContext{
+		Context: ctx,
+		cancel:  cancel,
+		limit:   limit,
+	}
+	return ec, func() { cancel(context.Canceled) }
+}
+
+// WatchMemory makes the context cancel with ErrMemoryLimit once EstimateMemory
+// of the state goes above limit. The estimate is taken every interval
+// instructions, from inside the VM loop, so it is safe to walk the state.
+// Between two estimates every new string the running function holds is
+// added up, a string grown by .. in a loop triggers the estimate long before
+// the interval is over. Builtins that allocate in one call are charged by
+// Allocate, see LimitAllocations.
+func (c *ExecContext) WatchMemory(L *lua.LState, limit, interval int64) {
+	c.state = L
+	c.memLimit = limit
+	c.memInterval = max(interval, 1)
+	c.seen = make(map[*byte]struct{})
+	c.measure()
+}
+
+// Done is called by the VM before every instruction. It must not be passed
+// to code running on other goroutines, see BaseContext.
+func (c *ExecContext) Done() <-chan struct{} {
+	n := c.count.Add(1)
+	if c.limit > 0 && n == c.limit+1 {
+		c.cancel(ErrInstructionLimit)
+	}
+	if c.memLimit > 0 {
+		if n%c.memInterval == 0 {
+			c.measure()
+		} else {
+			c.scanStrings()
+		}
+	}
+	return c.Context.Done()
+}
+
+// Allocate charges n bytes the state is about to allocate outside the VM loop.
+// When they do not fit, the context is canceled with ErrMemoryLimit and
+// ErrMemoryLimit is returned, the caller must not allocate them then.
+func (c *ExecContext) Allocate(n int64) error {
+	if c.memLimit <= 0 || n <= 0 {
+		return nil
+	}
+	if n > c.memLimit-c.memUsed {
+		// the charges since the last estimate may be gone already
+		c.measure()
+		if n > c.memLimit-c.memUsed {
+			c.cancel(ErrMemoryLimit)
+			return ErrMemoryLimit
+		}
+	}
+	c.memUsed += n
+	if c.holding > 0 {
+		c.held += n
+	}
+	return nil
+}
+
+// hold keeps the charges until release, for a builtin that charges and
+// allocates piece by piece or calls back into the script
+func (c *ExecContext) hold() {
+	c.holding++
+}
+
+func (c *ExecContext) release() {
+	c.holding--
+	if c.holding == 0 {
+		c.held = 0
+	}
+}
+
+// measure replaces the running total with a fresh estimate
+func (c *ExecContext) measure() {
+	c.memUsed = EstimateMemory(c.state, c.memLimit) + c.held
+	if c.memUsed > c.memLimit {
+		c.cancel(ErrMemoryLimit)
+	}
+	// the strings held right now are part of the estimate
+	clear(c.seen)
+	c.eachString(func(p *byte, _ int) bool {
+		c.seen[p] = struct{}{}
+		return true
+	})
+}
+
+// scanStrings adds the strings of the running function the total has not
+// seen yet and takes an estimate when they do not fit
+func (c *ExecContext) scanStrings() {
+	c.eachString(func(p *byte, size int) bool {
+		if _, ok := c.seen[p]; ok {
+			return true
+		}
+		c.seen[p] = struct{}{}
+		c.memUsed += int64(size)
+		if c.memUsed > c.memLimit {
+			c.measure()
+			return false
+		}
+		return true
+	})
+}
+
+// trackedStringSize is the size from which strings are tracked between
+// estimates, smaller ones take many instructions to add up
+const trackedStringSize = 256
+
+// eachString calls fn with the data and length of every string
+// of at least trackedStringSize bytes in the registers of the running function
+func (c *ExecContext) eachString(fn func(p *byte, size int) bool) {
+	L := c.state
+	for i := 1; i <= L.GetTop(); i++ {
+		s, ok := L.Get(i).(lua.LString)
+		if !ok || len(s) < trackedStringSize {
+			continue
+		}
+		if !fn(unsafe.StringData(string(s)), len(s)) {
+			return
+		}
+	}
+}
+
+// BaseContext returns the context an ExecContext was made from, for the
+// requests a script starts: their Done is called by other goroutines,
+// it must not count instructions or walk the state.
+func BaseContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*ExecContext); ok {
+		return c.Context
+	}
+	return ctx
+}
+
+// Instructions returns the number of instructions executed so far
+func (c *ExecContext) Instructions() int64 {
+	return c.count.Load()
+}
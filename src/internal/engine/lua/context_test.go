@@ -0,0 +1,148 @@
+package lua
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runLimited runs the chunk in a new state under an ExecContext
+// and returns the error of the chunk and the cause of the context
+func runLimited(tb testing.TB, chunk string, instructions, memory int64) (error, error) {
+	tb.Helper()
+	L := lua.NewState()
+	tb.Cleanup(L.Close)
+	LimitAllocations(L)
+	ec, cancel := NewExecContext(context.Background(), instructions)
+	tb.Cleanup(cancel)
+	if memory > 0 {
+		ec.WatchMemory(L, memory, 50000)
+	}
+	L.SetContext(ec)
+	err := L.DoString(chunk)
+	return err, context.Cause(ec)
+}
+
+func TestExecContext_Instructions(t *testing.T) {
+	err, cause := runLimited(t, `while true do end`, 1000, 0)
+	if err == nil || !errors.Is(cause, ErrInstructionLimit) {
+		t.Errorf("endless loop = %v, cause %v; want %v", err, cause, ErrInstructionLimit)
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	ec, cancel := NewExecContext(context.Background(), 0)
+	defer cancel()
+	L.SetContext(ec)
+	if err := L.DoString(`local n = 0 for i = 1, 1000 do n = n + i end`); err != nil {
+		t.Fatal(err)
+	}
+	n := ec.Instructions()
+	if n < 1000 {
+		t.Errorf("Instructions = %d, want at least one per iteration", n)
+	}
+	// other goroutines wait on the base context, that is not an instruction
+	cancel()
+	<-BaseContext(ec).Done()
+	if ec.Instructions() != n {
+		t.Errorf("BaseContext counts instructions")
+	}
+}
+
+func TestExecContext_Deadline(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ec, cancelExec := NewExecContext(ctx, 0)
+	defer cancelExec()
+	L.SetContext(ec)
+	if err := L.DoString(`while true do end`); err == nil || !errors.Is(context.Cause(ec), context.DeadlineExceeded) {
+		t.Errorf("endless loop = %v, cause %v; want the deadline", err, context.Cause(ec))
+	}
+}
+
+func TestExecContext_Memory(t *testing.T) {
+	const limit = 4 << 20
+	tests := []struct {
+		name  string
+		chunk string
+	}{
+		{"string.rep", `local s = string.rep("x", 1e9)`},
+		{"string.rep method", `local s = ("x"):rep(1e9)`},
+		{"string.rep overflow", `local s = string.rep("xx", 2^62)`},
+		{"concat doubling", `local s = "x" for i = 1, 100 do s = s .. s end`},
+		{"concat of many", `local s = string.rep("x", 1e5) local t = {} for i = 1, 200 do t[i] = s end local r = s .. s .. s .. s for i = 1, 100 do r = r .. r end`},
+		{"concat in a table", `local s = string.rep("x", 1e5) local t = {} for i = 1, 1e4 do t[i] = s .. i end`},
+		{"table.concat", `local s = string.rep("x", 1e5) local t = {} for i = 1, 1e4 do t[i] = s end local r = table.concat(t)`},
+		{"string.format", `local s = string.rep("x", 1e5) local t = {} for i = 1, 1e3 do t[i] = s end local r = string.format(string.rep("%s", 1e3), unpack(t))`},
+		{"string.gsub", `local s = string.rep("x", 1e4) local r = s:gsub("x", s)`},
+		{"string.gsub with captures", `local s = string.rep("x", 1e4) local r = s:gsub("x", "%0%0")`},
+		{"string.gsub with a function", `local s = string.rep("x", 1e4) local r = s:gsub("x", function() return s end)`},
+		{"string.gsub with a table", `local s = string.rep("x", 1e4) local r = s:gsub("x", {x = s})`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err, cause := runLimited(t, tt.chunk, 0, limit)
+			if err == nil || !errors.Is(cause, ErrMemoryLimit) {
+				t.Errorf("%s = %v, cause %v; want %v", tt.chunk, err, cause, ErrMemoryLimit)
+			}
+		})
+	}
+}
+
+func TestExecContext_MemoryWithin(t *testing.T) {
+	for _, chunk := range []string{
+		`local s = string.rep("x", 1e6)`,
+		`local s = "x" for i = 1, 20 do s = s .. s end`,
+		`local t = {} for i = 1, 1e3 do t[i] = "item " .. i end local r = table.concat(t, ",")`,
+		`local r = string.format("%5.2f %-10s %q", 1.5, "a", "b")`,
+		`local r = ("hello world"):gsub("o", {o = "0"})`,
+		// the same big string held for a long time is counted once
+		`local s = string.rep("x", 3e6) for i = 1, 1e5 do local n = #s end`,
+	} {
+		if err, cause := runLimited(t, chunk, 0, 8<<20); err != nil {
+			t.Errorf("%s = %v, cause %v", chunk, err, cause)
+		}
+	}
+}
+
+func TestLimitAllocations_Results(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+	LimitAllocations(L)
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{`return string.rep("ab", 3)`, "ababab"},
+		{`return string.rep("ab", -1)`, ""},
+		{`return table.concat({1, "b", 3}, "-")`, "1-b-3"},
+		{`return table.concat({1, 2, 3}, "", 2)`, "23"},
+		{`return string.format("%03d|%.2f|%s", 7, 1.5, "x")`, "007|1.50|x"},
+		{`return (("a.b"):gsub("%.", "%%"))`, "a%b"},
+		{`return (("abc"):gsub("%w", function(c) return c:upper() end))`, "ABC"},
+		{`return (("abc"):gsub("%w", function(c) if c == "b" then return nil end return "x" end))`, "xbx"},
+		{`return (("$a $b"):gsub("%$(%w)", {a = 1}))`, "1 $b"},
+		{`return (("abc"):gsub("()", {[1] = "<", [4] = ">"}))`, "<abc>"},
+	}
+	for _, tt := range tests {
+		if err := L.DoString(tt.chunk); err != nil {
+			t.Errorf("%s: %v", tt.chunk, err)
+			continue
+		}
+		got := L.Get(-1).String()
+		L.Pop(1)
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.chunk, got, tt.want)
+		}
+	}
+
+	if err := L.DoString(`return string.format("%1000d", 1)`); err == nil || !strings.Contains(err.Error(), "invalid format") {
+		t.Errorf("a three digit width = %v, want an error", err)
+	}
+}
@@ -0,0 +1,130 @@
+package lua
+
+import (
+	"errors"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ErrMemoryLimit is the cancellation cause of a script
+// whose state has grown past its memory limit
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// Rough per-value costs used by EstimateMemory. They follow the sizes of
+// gopher-lua structures on 64-bit platforms closely enough for a limit,
+// not for accounting.
+const (
+	valueCost    = 16
+	tableCost    = 96
+	entryCost    = 40
+	functionCost = 80
+	userDataCost = 48
+)
+
+// EstimateMemory approximates the memory held by the state: everything
+// reachable from the globals, the registry, the locals of running
+// functions and their upvalues. The walk stops as soon as the estimate
+// is above stopAt, so checking against a limit stays cheap when the limit
+// is exceeded by a lot. A stopAt of 0 or less walks everything.
+//
+// It must be called from the goroutine that runs the state.
+func EstimateMemory(L *lua.LState, stopAt int64) int64 {
+	m := &memWalker{
+		stopAt: stopAt,
+		seen:   make(map[any]struct{}),
+	}
+	m.visit(L.G.Global)
+	m.visit(L.G.Registry)
+	for level := 0; !m.done(); level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			break
+		}
+		for n := 1; !m.done(); n++ {
+			name, val := L.GetLocal(dbg, n)
+			if name == "" {
+				break
+			}
+			m.visit(val)
+		}
+	}
+	for i := 1; i <= L.GetTop() && !m.done(); i++ {
+		m.visit(L.Get(i))
+	}
+	return m.total
+}
+
+type memWalker struct {
+	total  int64
+	stopAt int64
+	seen   map[any]struct{}
+}
+
+func (m *memWalker) done() bool {
+	return m.stopAt > 0 && m.total > m.stopAt
+}
+
+func (m *memWalker) visit(v lua.LValue) {
+	if m.done() {
+		return
+	}
+	switch val := v.(type) {
+	case lua.LString:
+		m.total += valueCost + int64(len(val))
+	case *lua.LTable:
+		if m.mark(val) {
+			return
+		}
+		m.total += tableCost
+		val.ForEach(func(k, v lua.LValue) {
+			m.total += entryCost
+			m.visit(k)
+			m.visit(v)
+		})
+		if val.Metatable != nil && val.Metatable != lua.LNil {
+			m.visit(val.Metatable)
+		}
+	case *lua.LFunction:
+		if m.mark(val) {
+			return
+		}
+		m.total += functionCost
+		for _, uv := range val.Upvalues {
+			if uv != nil {
+				m.visit(uv.Value())
+			}
+		}
+	case *lua.LUserData:
+		if m.mark(val) {
+			return
+		}
+		m.total += userDataCost
+		if val.Metatable != nil && val.Metatable != lua.LNil {
+			m.visit(val.Metatable)
+		}
+	case *lua.LState:
+		m.total += userDataCost
+	default:
+		m.total += valueCost
+	}
+}
+
+// mark reports whether the value was already counted
+func (m *memWalker) mark(v any) bool {
+	if _, ok := m.seen[v]; ok {
+		return true
+	}
+	m.seen[v] = struct{}{}
+	return false
+}
+
+// IsStackOverflow reports whether the error comes from the state hitting
+// its registry or call stack size
+func IsStackOverflow(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "registry overflow") || strings.Contains(msg, "stack overflow")
+}
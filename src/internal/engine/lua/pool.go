@@ -1,35 +1,216 @@
 package lua
 
 import (
+	"context"
 	"sync"
 
 	lua "github.com/yuin/gopher-lua"
 )
 
+// LuaPoolInit structure is only for initialization
+type LuaPoolInit struct {
+	// Size caps the number of states in use at the same time.
+	// Get blocks while all of them are busy.
+	Size int
+	// MaxIdle is the number of states kept between requests, 0 closes every returned state
+	MaxIdle int
+	// Prepare is called once for every new state, before its baseline is taken.
+	// This is the place to sandbox the state.
+	Prepare func(L *lua.LState)
+	// Options are passed to lua.NewState, they set the registry and call stack sizes
+	Options lua.Options
+}
+
+// LuaPool hands out prepared Lua states and brings them back
+// to their baseline when they are returned.
 type LuaPool struct {
-	pool sync.Pool
+	sem  chan struct{}
+	idle chan *lua.LState
+
+	prepare func(L *lua.LState)
+	options lua.Options
+
+	mu        sync.Mutex
+	baselines map[*lua.LState]*baseline
 }
 
-func NewLuaPool() *LuaPool {
-	return &LuaPool{
-		pool: sync.Pool{
-			New: func() any {
-				L := lua.NewState()
+// baseline is a copy of every table reachable from the globals, the registry
+// and the string metatable, with the metatables of those tables, taken right
+// after Prepare. Tables created later are dropped with the fields holding them.
+type baseline struct {
+	tables map[*lua.LTable]*tableState
+	// funcs are the environments of the Lua functions found in the tables
+	funcs map[*lua.LFunction]*lua.LTable
+	// udata are the metatables of the userdata found in the tables
+	udata      map[*lua.LUserData]lua.LValue
+	env        *lua.LTable
+	stringMeta lua.LValue
+}
+
+type tableState struct {
+	fields map[lua.LValue]lua.LValue
+	meta   lua.LValue
+}
 
-				return L
-			},
-		},
+func NewLuaPool(o *LuaPoolInit) *LuaPool {
+	size := max(o.Size, 1)
+	return &LuaPool{
+		sem:       make(chan struct{}, size),
+		idle:      make(chan *lua.LState, min(max(o.MaxIdle, 0), size)),
+		prepare:   o.Prepare,
+		options:   o.Options,
+		baselines: make(map[*lua.LState]*baseline),
 	}
 }
 
-func (lp *LuaPool) Get() *lua.LState {
-	return lp.pool.Get().(*lua.LState)
+// Get returns a prepared state, waiting for a free slot if the pool is exhausted
+func (lp *LuaPool) Get(ctx context.Context) (*lua.LState, error) {
+	select {
+	case lp.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case L := <-lp.idle:
+		return L, nil
+	default:
+	}
+
+	L := lua.NewState(lp.options)
+	if lp.prepare != nil {
+		lp.prepare(L)
+	}
+	lp.mu.Lock()
+	lp.baselines[L] = takeBaseline(L)
+	lp.mu.Unlock()
+	return L, nil
 }
 
+// Put resets the state and keeps it for the next Get
 func (lp *LuaPool) Put(L *lua.LState) {
+	defer func() { <-lp.sem }()
+
+	lp.mu.Lock()
+	base, ok := lp.baselines[L]
+	lp.mu.Unlock()
+	if !ok {
+		L.Close()
+		return
+	}
+
+	base.restore(L)
+	select {
+	case lp.idle <- L:
+	default:
+		lp.forget(L)
+	}
+}
+
+// Discard closes the state instead of returning it to the pool.
+// Used when the state can no longer be trusted, e.g. after a panic.
+func (lp *LuaPool) Discard(L *lua.LState) {
+	defer func() { <-lp.sem }()
+	lp.forget(L)
+}
+
+// Close closes every idle state
+func (lp *LuaPool) Close() {
+	for {
+		select {
+		case L := <-lp.idle:
+			lp.forget(L)
+		default:
+			return
+		}
+	}
+}
+
+func (lp *LuaPool) forget(L *lua.LState) {
+	lp.mu.Lock()
+	delete(lp.baselines, L)
+	lp.mu.Unlock()
 	L.Close()
+}
+
+func takeBaseline(L *lua.LState) *baseline {
+	b := &baseline{
+		tables:     make(map[*lua.LTable]*tableState),
+		funcs:      make(map[*lua.LFunction]*lua.LTable),
+		udata:      make(map[*lua.LUserData]lua.LValue),
+		env:        L.Env,
+		stringMeta: L.GetMetatable(lua.LString("")),
+	}
+	b.walk(L.G.Global)
+	b.walk(L.G.Registry)
+	b.walk(L.Env)
+	b.walk(b.stringMeta)
+	return b
+}
 
-	newL := lua.NewState()
+func (b *baseline) walk(v lua.LValue) {
+	switch v := v.(type) {
+	case *lua.LTable:
+		if _, ok := b.tables[v]; ok {
+			return
+		}
+		state := &tableState{fields: make(map[lua.LValue]lua.LValue), meta: v.Metatable}
+		b.tables[v] = state
+		v.ForEach(func(k, val lua.LValue) {
+			state.fields[k] = val
+		})
+		for k, val := range state.fields {
+			b.walk(k)
+			b.walk(val)
+		}
+		b.walk(v.Metatable)
+	case *lua.LFunction:
+		if _, ok := b.funcs[v]; ok || v.IsG {
+			return
+		}
+		b.funcs[v] = v.Env
+		b.walk(v.Env)
+	case *lua.LUserData:
+		if _, ok := b.udata[v]; ok {
+			return
+		}
+		b.udata[v] = v.Metatable
+		b.walk(v.Metatable)
+	}
+}
 
-	lp.pool.Put(newL)
+// restore drops everything the previous script added to the baseline tables,
+// puts back whatever it replaced or removed and resets the metatables
+// and environments it changed
+func (b *baseline) restore(L *lua.LState) {
+	L.SetTop(0)
+	if L.Context() != nil {
+		L.RemoveContext()
+	}
+
+	L.Env = b.env
+	L.SetMetatable(lua.LString(""), b.stringMeta)
+	for fn, env := range b.funcs {
+		fn.Env = env
+	}
+	for ud, meta := range b.udata {
+		ud.Metatable = meta
+	}
+	for tbl, state := range b.tables {
+		tbl.Metatable = state.meta
+		var extra []lua.LValue
+		tbl.ForEach(func(k, _ lua.LValue) {
+			if _, ok := state.fields[k]; !ok {
+				extra = append(extra, k)
+			}
+		})
+		for _, k := range extra {
+			tbl.RawSet(k, lua.LNil)
+		}
+		for k, v := range state.fields {
+			if tbl.RawGet(k) != v {
+				tbl.RawSet(k, v)
+			}
+		}
+	}
 }
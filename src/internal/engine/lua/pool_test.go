@@ -0,0 +1,150 @@
+package lua
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestLuaPool_Reset(t *testing.T) {
+	lp := NewLuaPool(&LuaPoolInit{
+		Size:    1,
+		MaxIdle: 1,
+		Prepare: func(L *lua.LState) {
+			L.SetGlobal("print", lua.LNil)
+		},
+	})
+	defer lp.Close()
+
+	L, err := lp.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	L.PreloadModule("leaky", func(L *lua.LState) int {
+		L.Push(lua.LString("module"))
+		return 1
+	})
+	if err := L.DoString(`
+		leaked = true
+		string.upper = nil
+		print = function() end
+		require("leaky")
+		package.path = package.path .. ";/leaked/?.lua"
+	`); err != nil {
+		t.Fatal(err)
+	}
+	path := L.GetField(L.GetGlobal("package"), "path").String()
+	lp.Put(L)
+
+	L2, err := lp.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lp.Put(L2)
+	if L2 != L {
+		t.Fatalf("expected the idle state to be reused")
+	}
+
+	tests := []struct {
+		expr string
+		want lua.LValue
+	}{
+		{"return leaked", lua.LNil},
+		{"return type(string.upper)", lua.LString("function")},
+		{"return print", lua.LNil},
+		{`return package.loaded["leaky"]`, lua.LNil},
+		{`return package.preload["leaky"]`, lua.LNil},
+		{"return package.path == " + strconv.Quote(path), lua.LFalse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			fn, err := L2.LoadString(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			L2.Push(fn)
+			if err := L2.PCall(0, 1, nil); err != nil {
+				t.Fatal(err)
+			}
+			got := L2.Get(-1)
+			L2.Pop(1)
+			if got != tt.want {
+				t.Errorf("%s = %v; want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuaPool_Size(t *testing.T) {
+	lp := NewLuaPool(&LuaPoolInit{Size: 1, MaxIdle: 1})
+	defer lp.Close()
+
+	L, err := lp.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lp.Get(ctx); err == nil {
+		t.Errorf("Get on an exhausted pool = nil error; want context error")
+	}
+	lp.Put(L)
+}
+
+func TestLuaPool_ResetMetatables(t *testing.T) {
+	lp := NewLuaPool(&LuaPoolInit{Size: 1, MaxIdle: 1})
+	defer lp.Close()
+
+	L, err := lp.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`
+		setmetatable(_G, {__index = function() return "hijacked" end, __newindex = function() end})
+		getmetatable("").__index = {upper = function() return "hijacked" end}
+		setmetatable(string, {__index = function() return "hijacked" end})
+		string.format = nil
+		table.insert = function() end
+		setfenv(0, {})
+	`); err != nil {
+		t.Fatal(err)
+	}
+	lp.Put(L)
+
+	L2, err := lp.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lp.Put(L2)
+	if L2 != L {
+		t.Fatalf("expected the idle state to be reused")
+	}
+
+	tests := []struct {
+		expr string
+		want lua.LValue
+	}{
+		{"return getmetatable(_G)", lua.LNil},
+		{"return undefined_global", lua.LNil},
+		{`return ("x"):upper()`, lua.LString("X")},
+		{"return getmetatable(string)", lua.LNil},
+		{"return string.missing", lua.LNil},
+		{`return string.format("%d", 1)`, lua.LString("1")},
+		{"local t = {}; table.insert(t, 1); return #t", lua.LNumber(1)},
+		{"return type(print)", lua.LString("function")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			if err := L2.DoString(tt.expr); err != nil {
+				t.Fatal(err)
+			}
+			got := L2.Get(-1)
+			L2.Pop(1)
+			if got != tt.want {
+				t.Errorf("%s = %v; want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
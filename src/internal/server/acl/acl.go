@@ -0,0 +1,203 @@
+// Package acl decides which clients may call which methods. The policy is a
+// YAML file of rules, the first rule whose pattern matches the method decides:
+//
+//	default: deny
+//	rules:
+//	  - methods: "Access.Delete"
+//	    roles: [admin]
+//	  - methods: "Unit.*"
+//	    principals: [deploy]
+//	    ips: [10.0.0.0/8, 192.168.1.10]
+//	  - methods: "Node.*"
+//	    certificates: ["*.lab.internal"]
+//	  - methods: "Public.*"
+//
+// A client matching any of the roles, principals, IP ranges or certificates
+// of the rule is allowed, a rule without any of them allows everyone.
+// Certificate patterns are matched against the common name and every
+// subject alternative name of the verified TLS client certificate. Methods no rule
+// matches follow the default, which must be set to "allow" or "deny": a file
+// without it is rejected rather than guessed to be open.
+package acl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a rule of the policy file
+type Rule struct {
+	// Methods is a path.Match pattern like "Unit.*"
+	Methods    string   `yaml:"methods"`
+	Roles      []string `yaml:"roles"`
+	Principals []string `yaml:"principals"`
+	// IPs are addresses or CIDR ranges
+	IPs []string `yaml:"ips"`
+	// Certificates are path.Match patterns of client certificate names
+	Certificates []string `yaml:"certificates"`
+}
+
+type policy struct {
+	allow bool
+	rules []rule
+}
+
+type rule struct {
+	Rule
+	prefixes clientip.Proxies
+}
+
+// ACL holds the policy of the file, it is safe for concurrent use
+// and may be reloaded at any time
+type ACL struct {
+	file    string
+	proxies clientip.Proxies
+	policy  atomic.Pointer[policy]
+}
+
+// Init structure is only for initialization
+type Init struct {
+	File           string
+	TrustedProxies clientip.Proxies
+}
+
+// Decision is the outcome of a check
+type Decision struct {
+	Allowed bool
+	// Rule is the pattern of the deciding rule, empty when the default applied
+	Rule      string
+	Principal string
+	IP        netip.Addr
+}
+
+// New loads the policy file
+func New(o *Init) (*ACL, error) {
+	a := &ACL{file: o.File, proxies: o.TrustedProxies}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the policy file again. On error the current policy stays.
+func (a *ACL) Reload() error {
+	p, err := load(a.file)
+	if err != nil {
+		return err
+	}
+	a.policy.Store(p)
+	return nil
+}
+
+// Rules returns the number of rules of the current policy
+func (a *ACL) Rules() int {
+	return len(a.policy.Load().rules)
+}
+
+func load(file string) (*policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Default string `yaml:"default"`
+		Rules   []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	p := &policy{}
+	switch strings.ToLower(doc.Default) {
+	case "allow":
+		p.allow = true
+	case "deny":
+	case "":
+		return nil, fmt.Errorf("%s: default must be set to \"allow\" or \"deny\"", file)
+	default:
+		return nil, fmt.Errorf("%s: default must be \"allow\" or \"deny\", not %q", file, doc.Default)
+	}
+	for i, r := range doc.Rules {
+		if _, err := path.Match(r.Methods, ""); err != nil || r.Methods == "" {
+			return nil, fmt.Errorf("%s: rule %d: invalid pattern %q", file, i+1, r.Methods)
+		}
+		for _, pattern := range r.Certificates {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: rule %d: invalid certificate pattern %q", file, i+1, pattern)
+			}
+		}
+		prefixes, err := clientip.ParseProxies(r.IPs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", file, i+1, err)
+		}
+		p.rules = append(p.rules, rule{Rule: r, prefixes: prefixes})
+	}
+	return p, nil
+}
+
+// Check decides whether the client of r may call the method.
+// The principal is taken from ctx, see auth.WithPrincipal.
+func (a *ACL) Check(ctx context.Context, r *http.Request, method string) Decision {
+	d := Decision{IP: a.proxies.Of(r)}
+	p, authenticated := auth.PrincipalFrom(ctx)
+	if authenticated {
+		d.Principal = p.ID
+	}
+	cert, _ := auth.CertificateFrom(ctx)
+
+	policy := a.policy.Load()
+	for _, rule := range policy.rules {
+		if ok, _ := path.Match(rule.Methods, method); !ok {
+			continue
+		}
+		d.Rule = rule.Methods
+		d.Allowed = rule.allows(p, authenticated, d.IP, cert)
+		return d
+	}
+	d.Allowed = policy.allow
+	return d
+}
+
+func (r *rule) allows(p *auth.Principal, authenticated bool, ip netip.Addr, cert *auth.Certificate) bool {
+	if len(r.Roles) == 0 && len(r.Principals) == 0 && len(r.prefixes) == 0 && len(r.Certificates) == 0 {
+		return true
+	}
+	if authenticated {
+		if slices.Contains(r.Principals, p.ID) {
+			return true
+		}
+		for _, role := range p.Roles {
+			if slices.Contains(r.Roles, role) {
+				return true
+			}
+		}
+	}
+	if ip.IsValid() {
+		for _, prefix := range r.prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+	}
+	if cert != nil {
+		for _, name := range cert.Names() {
+			for _, pattern := range r.Certificates {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package acl
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - methods: "Access.Delete"
+    roles: [admin]
+  - methods: "Unit.*"
+    principals: [deploy]
+    ips: [10.0.0.0/8, 192.168.1.10]
+  - methods: "Public.*"
+`
+
+func writePolicy(tb testing.TB, file, content string) {
+	tb.Helper()
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	writePolicy(t, file, testPolicy)
+	proxies, _ := clientip.ParseProxies([]string{"127.0.0.1"})
+	a, err := New(&Init{File: file, TrustedProxies: proxies})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := &auth.Principal{ID: "alice", Roles: []string{"admin"}}
+	deploy := &auth.Principal{ID: "deploy"}
+	tests := []struct {
+		method    string
+		principal *auth.Principal
+		ip        string
+		forwarded string
+		allowed   bool
+		rule      string
+	}{
+		{"Access.Delete", admin, "203.0.113.1", "", true, "Access.Delete"},
+		{"Access.Delete", deploy, "203.0.113.1", "", false, "Access.Delete"},
+		{"Access.Delete", nil, "10.0.0.1", "", false, "Access.Delete"},
+		{"Unit.Restart", deploy, "203.0.113.1", "", true, "Unit.*"},
+		{"Unit.Restart", nil, "10.1.2.3", "", true, "Unit.*"},
+		{"Unit.Restart", nil, "192.168.1.10", "", true, "Unit.*"},
+		{"Unit.Restart", nil, "192.168.1.11", "", false, "Unit.*"},
+		{"Unit.Restart", nil, "127.0.0.1", "10.9.9.9", true, "Unit.*"},
+		{"Unit.Restart", nil, "203.0.113.1", "10.9.9.9", false, "Unit.*"},
+		{"Public.Ping", nil, "203.0.113.1", "", true, "Public.*"},
+		{"Other", admin, "10.0.0.1", "", false, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/com", nil)
+		r.RemoteAddr = tt.ip + ":4000"
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		ctx := context.Background()
+		if tt.principal != nil {
+			ctx = auth.WithPrincipal(ctx, tt.principal)
+		}
+		d := a.Check(ctx, r, tt.method)
+		if d.Allowed != tt.allowed || d.Rule != tt.rule {
+			t.Errorf("%s from %s %+v: %+v, want allowed %v by %q", tt.method, tt.ip, tt.principal, d, tt.allowed, tt.rule)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	writePolicy(t, file, "default: allow\nrules:\n  - methods: Secret\n    roles: [admin]\n")
+	a, err := New(&Init{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/com", nil)
+	if a.Check(context.Background(), r, "Secret").Allowed {
+		t.Fatal("Secret is allowed before the reload")
+	}
+	if !a.Check(context.Background(), r, "Other").Allowed {
+		t.Fatal("the default is not allow")
+	}
+
+	writePolicy(t, file, "default: allow\nrules:\n  - methods: Secret\n")
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Check(context.Background(), r, "Secret").Allowed {
+		t.Error("Secret is denied after the reload")
+	}
+
+	for _, broken := range []string{
+		"default: maybe\n",
+		"rules:\n  - methods: Secret\n",
+		"default: allow\nrules:\n  - methods: \"[\"\n",
+		"default: allow\nrules:\n  - methods: Secret\n    ips: [not-an-ip]\n",
+		"rules: {",
+	} {
+		writePolicy(t, file, broken)
+		if err := a.Reload(); err == nil {
+			t.Errorf("policy %q was accepted", broken)
+		}
+	}
+	if a.Rules() != 1 || !a.Check(context.Background(), r, "Secret").Allowed {
+		t.Error("a broken file replaced the policy")
+	}
+}
+
+func TestCheck_Certificates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl.yaml")
+	writePolicy(t, file, "default: deny\nrules:\n  - methods: \"Node.*\"\n    certificates: [\"*.lab.internal\", \"spiffe://lab/*\"]\n")
+	a, err := New(&Init{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/com", nil)
+	tests := []struct {
+		cert    *auth.Certificate
+		allowed bool
+	}{
+		{nil, false},
+		{&auth.Certificate{CommonName: "node-a.lab.internal"}, true},
+		{&auth.Certificate{CommonName: "node-a", DNSNames: []string{"node-a.lab.internal"}}, true},
+		{&auth.Certificate{CommonName: "node-a", URIs: []string{"spiffe://lab/node-a"}}, true},
+		{&auth.Certificate{CommonName: "node-a.example.com"}, false},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		if tt.cert != nil {
+			ctx = auth.WithCertificate(ctx, tt.cert)
+		}
+		if d := a.Check(ctx, r, "Node.Sync"); d.Allowed != tt.allowed {
+			t.Errorf("%+v: allowed = %v, want %v", tt.cert, d.Allowed, tt.allowed)
+		}
+	}
+}
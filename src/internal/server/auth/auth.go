@@ -0,0 +1,105 @@
+// Package auth authenticates the clients of the node. A client presents an
+// API key or a JWT in the Authorization header, or a TLS client certificate,
+// the gateway turns it into a Principal and puts it into the request context,
+// where the servers and the scripts find it.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// Ways a principal was authenticated
+const (
+	MethodAPIKey = "api-key"
+	MethodJWT    = "jwt"
+	// MethodCertificate is a verified TLS client certificate
+	MethodCertificate = "certificate"
+)
+
+// Principal is an authenticated client
+type Principal struct {
+	ID string `json:"id"`
+	// Method is MethodAPIKey, MethodJWT or MethodCertificate
+	Method      string   `json:"method"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	// Claims are the claims of the JWT, nil for API keys
+	Claims map[string]any `json:"claims,omitempty"`
+	// Expires is when the credentials stop being accepted, zero if they do not expire.
+	// Connections kept open past it are closed.
+	Expires time.Time `json:"-"`
+}
+
+// AllPermissions granted to a principal allows every permission
+var AllPermissions = "*"
+
+// Has reports whether the principal was granted the permission
+func (p *Principal) Has(permission string) bool {
+	return slices.Contains(p.Permissions, permission) || slices.Contains(p.Permissions, AllPermissions)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal of the request
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal of the request, if it was authenticated
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+var (
+	// ErrUnauthenticated is returned when a method needs a principal and there is none
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrInvalidCredentials is returned for an unknown API key or a token that fails verification
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// PermissionError lists the permissions the principal lacks
+type PermissionError struct {
+	Missing []string
+}
+
+func (e *PermissionError) Error() string {
+	return "permission denied"
+}
+
+// Authorize checks that the principal of ctx has every required permission.
+// A method that requires nothing is open to everyone.
+func Authorize(ctx context.Context, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	p, ok := PrincipalFrom(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+	var missing []string
+	for _, permission := range required {
+		if !p.Has(permission) {
+			missing = append(missing, permission)
+		}
+	}
+	if missing != nil {
+		return &PermissionError{Missing: missing}
+	}
+	return nil
+}
+
+// ErrorResponse turns an error of this package into the JSON-RPC error
+func ErrorResponse(err error, id *json.RawMessage) *rpc.RPCResponse {
+	var perm *PermissionError
+	if errors.As(err, &perm) {
+		return rpc.NewError(rpc.ErrPermissionDenied, rpc.ErrPermissionDeniedS, map[string]any{"missing": perm.Missing}, id)
+	}
+	return rpc.NewError(rpc.ErrUnauthorized, rpc.ErrUnauthorizedS, err.Error(), id)
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func writeFile(tb testing.TB, name string, data []byte) string {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func publicPEM(tb testing.TB, key any) []byte {
+	tb.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func sign(tb testing.TB, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	tb.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthenticate_APIKey(t *testing.T) {
+	keys := writeFile(t, "keys.yaml", []byte(`
+- principal: deploy
+  key: plain-secret
+  roles: [ops]
+  permissions: [deploy.run]
+- principal: backup
+  sha256: `+hashKey("hashed-secret")+`
+`))
+	a, err := New(&Init{APIKeysFile: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		header, value string
+		principal     string
+		err           error
+	}{
+		{"X-API-Key", "plain-secret", "deploy", nil},
+		{"Authorization", "ApiKey hashed-secret", "backup", nil},
+		{"Authorization", "Bearer plain-secret", "deploy", nil},
+		{"X-API-Key", "wrong", "", ErrInvalidCredentials},
+		{"X-Other", "plain-secret", "", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/com", nil)
+		r.Header.Set(tt.header, tt.value)
+		p, err := a.Authenticate(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: %s: err = %v, want %v", tt.header, tt.value, err, tt.err)
+			continue
+		}
+		switch {
+		case tt.principal == "" && p != nil:
+			t.Errorf("%s: %s: principal = %+v, want none", tt.header, tt.value, p)
+		case tt.principal != "" && (p == nil || p.ID != tt.principal || p.Method != MethodAPIKey):
+			t.Errorf("%s: %s: principal = %+v, want %s", tt.header, tt.value, p, tt.principal)
+		}
+	}
+}
+
+func TestNew_InvalidAPIKeys(t *testing.T) {
+	keys := writeFile(t, "keys.yaml", []byte("- principal: nobody\n  sha256: abc\n"))
+	if _, err := New(&Init{APIKeysFile: keys}); err == nil {
+		t.Error("an entry with a short digest was accepted")
+	}
+}
+
+func TestAuthenticate_JWT(t *testing.T) {
+	secret := []byte("hs256-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := New(&Init{
+		HS256Secret:    writeFile(t, "secret", append(secret, '\n')),
+		RS256PublicKey: writeFile(t, "rsa.pem", publicPEM(t, &rsaKey.PublicKey)),
+		EdDSAPublicKey: writeFile(t, "ed.pem", publicPEM(t, edPub)),
+		Issuer:         "issuer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(sub string, exp time.Duration) jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   sub,
+			"iss":   "issuer",
+			"exp":   time.Now().Add(exp).Unix(),
+			"roles": []string{"admin"},
+			"scope": "unit.read unit.write",
+		}
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"hs256", sign(t, jwt.SigningMethodHS256, secret, claims("alice", time.Minute)), true},
+		{"rs256", sign(t, jwt.SigningMethodRS256, rsaKey, claims("bob", time.Minute)), true},
+		{"eddsa", sign(t, jwt.SigningMethodEdDSA, edKey, claims("carol", time.Minute)), true},
+		{"expired", sign(t, jwt.SigningMethodHS256, secret, claims("alice", -time.Hour)), false},
+		{"wrong key", sign(t, jwt.SigningMethodRS256, otherRSA, claims("bob", time.Minute)), false},
+		{"alg without key", sign(t, jwt.SigningMethodHS512, secret, claims("alice", time.Minute)), false},
+		{"no subject", sign(t, jwt.SigningMethodHS256, secret, claims("", time.Minute)), false},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "alice", "iss": "other"}), false},
+		{"no expiry", sign(t, jwt.SigningMethodHS256, secret, jwt.MapClaims{"sub": "alice", "iss": "issuer"}), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/com", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		p, err := a.Authenticate(r)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("%s: err = %v, want ErrInvalidCredentials", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if p.Method != MethodJWT || !slices.Equal(p.Roles, []string{"admin"}) || !slices.Equal(p.Permissions, []string{"unit.read", "unit.write"}) {
+			t.Errorf("%s: principal = %+v", tt.name, p)
+		}
+		if until := time.Until(p.Expires); until <= 0 || until > time.Minute {
+			t.Errorf("%s: principal expires in %v, want the exp of the token", tt.name, until)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	if err := Authorize(ctx, nil); err != nil {
+		t.Errorf("open method: %v", err)
+	}
+	if err := Authorize(ctx, []string{"unit.read"}); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("no principal: err = %v", err)
+	}
+
+	ctx = WithPrincipal(ctx, &Principal{ID: "alice", Permissions: []string{"unit.read"}})
+	if err := Authorize(ctx, []string{"unit.read"}); err != nil {
+		t.Errorf("granted: %v", err)
+	}
+	var perm *PermissionError
+	if err := Authorize(ctx, []string{"unit.read", "unit.write"}); !errors.As(err, &perm) || !slices.Equal(perm.Missing, []string{"unit.write"}) {
+		t.Errorf("missing permission: err = %v", err)
+	}
+
+	ctx = WithPrincipal(ctx, &Principal{ID: "root", Permissions: []string{AllPermissions}})
+	if err := Authorize(ctx, []string{"anything"}); err != nil {
+		t.Errorf("all permissions: %v", err)
+	}
+}
+
+func TestProtected(t *testing.T) {
+	a, err := New(&Init{Protected: []string{"Admin.*", "Reboot"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, want := range map[string]bool{"Admin.Users": true, "Reboot": true, "Echo": false, "Admin": false} {
+		if got := a.Protected(method); got != want {
+			t.Errorf("Protected(%q) = %v, want %v", method, got, want)
+		}
+	}
+	if _, err := New(&Init{Protected: []string{"["}}); err == nil {
+		t.Error("an invalid pattern was accepted")
+	}
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Authenticator verifies the credentials of requests
+type Authenticator struct {
+	// keys are the API keys by the hex SHA-256 of the key
+	keys      map[string]*Principal
+	jwtKeys   map[string]any
+	parser    *jwt.Parser
+	claims    ClaimNames
+	leeway    time.Duration
+	protected []string
+}
+
+// ClaimNames are the JWT claims the principal is read from,
+// "sub", "roles" and "scope" by default
+type ClaimNames struct {
+	Principal   string
+	Roles       string
+	Permissions string
+}
+
+// Init structure is only for initialization, every field is optional
+type Init struct {
+	// APIKeysFile is a YAML list of APIKey
+	APIKeysFile string
+	// HS256Secret, RS256PublicKey and EdDSAPublicKey are files with the keys
+	// the tokens are verified with, PEM for the public keys.
+	// Only the algorithms with a key are accepted.
+	HS256Secret    string
+	RS256PublicKey string
+	EdDSAPublicKey string
+	// Issuer and Audience are required in tokens if set, "exp" always is
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	Claims   ClaimNames
+	// Protected are path.Match patterns of methods that need a principal
+	Protected []string
+}
+
+// APIKey is an entry of the API keys file. Key is the key itself,
+// or SHA256 its hex digest so the file does not hold the secret.
+type APIKey struct {
+	Principal   string   `yaml:"principal"`
+	Key         string   `yaml:"key"`
+	SHA256      string   `yaml:"sha256"`
+	Roles       []string `yaml:"roles"`
+	Permissions []string `yaml:"permissions"`
+}
+
+// New loads the keys of the authenticator
+func New(o *Init) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:      make(map[string]*Principal),
+		jwtKeys:   make(map[string]any),
+		claims:    o.Claims,
+		leeway:    o.Leeway,
+		protected: o.Protected,
+	}
+	if a.claims.Principal == "" {
+		a.claims.Principal = "sub"
+	}
+	if a.claims.Roles == "" {
+		a.claims.Roles = "roles"
+	}
+	if a.claims.Permissions == "" {
+		a.claims.Permissions = "scope"
+	}
+	for _, pattern := range o.Protected {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid protected method pattern %q", pattern)
+		}
+	}
+
+	if o.APIKeysFile != "" {
+		if err := a.loadAPIKeys(o.APIKeysFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.loadJWTKeys(o); err != nil {
+		return nil, err
+	}
+
+	methods := make([]string, 0, len(a.jwtKeys))
+	for alg := range a.jwtKeys {
+		methods = append(methods, alg)
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithLeeway(o.Leeway), jwt.WithExpirationRequired()}
+	if o.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(o.Issuer))
+	}
+	if o.Audience != "" {
+		opts = append(opts, jwt.WithAudience(o.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+func (a *Authenticator) loadAPIKeys(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var entries []APIKey
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	for i, e := range entries {
+		digest := strings.ToLower(e.SHA256)
+		if e.Key != "" {
+			digest = hashKey(e.Key)
+		}
+		if e.Principal == "" || len(digest) != sha256.Size*2 {
+			return fmt.Errorf("%s: entry %d needs a principal and a key or its sha256", file, i+1)
+		}
+		a.keys[digest] = &Principal{
+			ID:          e.Principal,
+			Method:      MethodAPIKey,
+			Roles:       nonNil(e.Roles),
+			Permissions: nonNil(e.Permissions),
+		}
+	}
+	return nil
+}
+
+func (a *Authenticator) loadJWTKeys(o *Init) error {
+	if o.HS256Secret != "" {
+		secret, err := os.ReadFile(o.HS256Secret)
+		if err != nil {
+			return err
+		}
+		a.jwtKeys[jwt.SigningMethodHS256.Alg()] = []byte(strings.TrimSpace(string(secret)))
+	}
+	if o.RS256PublicKey != "" {
+		data, err := os.ReadFile(o.RS256PublicKey)
+		if err != nil {
+			return err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.RS256PublicKey, err)
+		}
+		a.jwtKeys[jwt.SigningMethodRS256.Alg()] = key
+	}
+	if o.EdDSAPublicKey != "" {
+		data, err := os.ReadFile(o.EdDSAPublicKey)
+		if err != nil {
+			return err
+		}
+		key, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.EdDSAPublicKey, err)
+		}
+		a.jwtKeys[jwt.SigningMethodEdDSA.Alg()] = key
+	}
+	return nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Protected reports whether the method needs a principal
+func (a *Authenticator) Protected(method string) bool {
+	for _, pattern := range a.protected {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns the principal of the request, nil if it carries no
+// credentials. Credentials are taken from "Authorization: Bearer",
+// "Authorization: ApiKey" or X-API-Key. A bearer token with three
+// dot-separated parts is a JWT, anything else is an API key.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	credential, isKey := credentials(r)
+	if credential == "" {
+		return nil, nil
+	}
+	if !isKey && strings.Count(credential, ".") == 2 {
+		return a.verifyJWT(credential)
+	}
+	p, ok := a.keys[hashKey(credential)]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	return p, nil
+}
+
+func credentials(r *http.Request) (credential string, isKey bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok {
+		return "", false
+	}
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return strings.TrimSpace(value), false
+	case "apikey":
+		return strings.TrimSpace(value), true
+	}
+	return "", false
+}
+
+func (a *Authenticator) verifyJWT(tokenString string) (*Principal, error) {
+	if len(a.jwtKeys) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return a.jwtKeys[t.Method.Alg()], nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	id, _ := claims[a.claims.Principal].(string)
+	if id == "" {
+		return nil, fmt.Errorf("%w: token has no %q claim", ErrInvalidCredentials, a.claims.Principal)
+	}
+	// the parser has already required and checked "exp"
+	exp, err := claims.GetExpirationTime()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+	return &Principal{
+		ID:          id,
+		Method:      MethodJWT,
+		Roles:       stringList(claims[a.claims.Roles]),
+		Permissions: stringList(claims[a.claims.Permissions]),
+		Claims:      claims,
+		Expires:     exp.Add(a.leeway),
+	}, nil
+}
+
+// stringList accepts an array of strings or a space separated string,
+// the way OAuth puts scopes into "scope"
+func stringList(v any) []string {
+	list := []string{}
+	switch v := v.(type) {
+	case string:
+		list = append(list, strings.Fields(v)...)
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	return list
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+)
+
+// Certificate is the verified TLS client certificate of a request
+type Certificate struct {
+	Subject    string `json:"subject"`
+	CommonName string `json:"common-name"`
+	// Organization and OrganizationalUnit come from the subject
+	Organization       []string `json:"organization"`
+	OrganizationalUnit []string `json:"organizational-unit"`
+	Issuer             string   `json:"issuer"`
+	Serial             string   `json:"serial"`
+	// Fingerprint is the hex SHA-256 of the DER encoded certificate
+	Fingerprint string `json:"fingerprint"`
+
+	// SANs are the subject alternative names of every kind
+	DNSNames       []string `json:"dns-names"`
+	EmailAddresses []string `json:"email-addresses"`
+	IPAddresses    []string `json:"ip-addresses"`
+	URIs           []string `json:"uris"`
+}
+
+// CertificateOf returns the client certificate of the connection,
+// nil unless it was presented and verified against the client CAs
+func CertificateOf(state *tls.ConnectionState) *Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	sum := sha256.Sum256(cert.Raw)
+	c := &Certificate{
+		Subject:            cert.Subject.String(),
+		CommonName:         cert.Subject.CommonName,
+		Organization:       nonNil(cert.Subject.Organization),
+		OrganizationalUnit: nonNil(cert.Subject.OrganizationalUnit),
+		Issuer:             cert.Issuer.String(),
+		Serial:             cert.SerialNumber.String(),
+		Fingerprint:        hex.EncodeToString(sum[:]),
+		DNSNames:           nonNil(cert.DNSNames),
+		EmailAddresses:     nonNil(cert.EmailAddresses),
+		IPAddresses:        []string{},
+		URIs:               []string{},
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IPAddresses = append(c.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		c.URIs = append(c.URIs, uri.String())
+	}
+	return c
+}
+
+// Names returns the common name followed by every SAN
+func (c *Certificate) Names() []string {
+	var names []string
+	if c.CommonName != "" {
+		names = append(names, c.CommonName)
+	}
+	names = append(names, c.DNSNames...)
+	names = append(names, c.EmailAddresses...)
+	names = append(names, c.IPAddresses...)
+	return append(names, c.URIs...)
+}
+
+// Principal turns the certificate into a principal named by its first name,
+// the organizational units of the subject become the roles
+func (c *Certificate) Principal() *Principal {
+	names := c.Names()
+	if len(names) == 0 {
+		return nil
+	}
+	return &Principal{
+		ID:          names[0],
+		Method:      MethodCertificate,
+		Roles:       c.OrganizationalUnit,
+		Permissions: []string{},
+	}
+}
+
+type certificateKey struct{}
+
+// WithCertificate returns a context carrying the client certificate of the request
+func WithCertificate(ctx context.Context, c *Certificate) context.Context {
+	return context.WithValue(ctx, certificateKey{}, c)
+}
+
+// CertificateFrom returns the client certificate of the request, if it presented one
+func CertificateFrom(ctx context.Context) (*Certificate, bool) {
+	c, ok := ctx.Value(certificateKey{}).(*Certificate)
+	return c, ok && c != nil
+}
@@ -0,0 +1,84 @@
+// Package clientip finds the address of the client behind trusted reverse proxies
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies are the networks of the trusted reverse proxies
+type Proxies []netip.Prefix
+
+// ParseProxies parses CIDRs or single addresses
+func ParseProxies(list []string) (Proxies, error) {
+	var proxies Proxies
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusted(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Of returns the address of the client. X-Forwarded-For and X-Real-IP are
+// only believed when the connection comes from a trusted proxy, and
+// X-Forwarded-For is read from the right up to the first untrusted hop.
+// The zero Addr is returned when the peer is not an IP, like a Unix socket.
+func (p Proxies) Of(r *http.Request) netip.Addr {
+	peer := RemoteAddr(r)
+	if !peer.IsValid() || !p.trusted(peer) {
+		return peer
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !p.trusted(addr) {
+			return addr
+		}
+		peer = addr
+	}
+	if real, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil && r.Header.Get("X-Forwarded-For") == "" {
+		return real.Unmap()
+	}
+	return peer
+}
+
+// RemoteAddr returns the address of the peer of the connection
+func RemoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,52 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		real   string
+		want   string
+	}{
+		{"direct", "198.51.100.7:1234", "", "", "198.51.100.7"},
+		{"spoofed header", "198.51.100.7:1234", "203.0.113.9", "", "198.51.100.7"},
+		{"trusted proxy", "10.1.2.3:1234", "203.0.113.9", "", "203.0.113.9"},
+		{"chain of proxies", "10.1.2.3:1234", "203.0.113.9, 198.51.100.7, 192.0.2.1", "", "198.51.100.7"},
+		{"only proxies", "10.1.2.3:1234", "10.0.0.1", "", "10.0.0.1"},
+		{"garbage hop", "10.1.2.3:1234", "203.0.113.9, nonsense", "", "10.1.2.3"},
+		{"real ip", "192.0.2.1:1234", "", "203.0.113.9", "203.0.113.9"},
+		{"mapped", "[::ffff:198.51.100.7]:1234", "", "", "198.51.100.7"},
+		{"unix socket", "unix:/run/node.sock", "", "", "invalid IP"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.real != "" {
+			r.Header.Set("X-Real-IP", tt.real)
+		}
+		if got := proxies.Of(r).String(); got != tt.want {
+			t.Errorf("%s: Of = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	if _, err := ParseProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+	if _, err := ParseProxies([]string{"localhost"}); err == nil {
+		t.Error("host name accepted")
+	}
+}
@@ -0,0 +1,273 @@
+// Package egress decides where the scripts of the node may send HTTP requests.
+// Rules are checked in order, the first rule whose method pattern matches the
+// calling method and whose hosts or CIDRs match the destination applies:
+//
+//	default: deny
+//	block_private: true
+//	rules:
+//	  - methods: "Billing.*"
+//	    hosts: ["api.stripe.com"]
+//	    schemes: [https]
+//	    forward_initiator: true
+//	  - methods: "Unit.*"
+//	    cidrs: [10.20.0.0/16]
+//
+// A rule without hosts and CIDRs matches any destination. With block_private
+// the addresses a host resolves to are checked right before connecting, and
+// loopback, private, link-local and similar addresses are refused unless
+// the CIDRs of the applied rule contain them. Only destinations of a rule with
+// forward_initiator receive the X-Initiator-* and X-Session-UUID headers.
+package egress
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Rule is a rule of the egress policy
+type Rule struct {
+	// Methods is a path.Match pattern of the calling com methods, empty matches all
+	Methods string
+	// Hosts are path.Match patterns of host names like "*.example.com"
+	Hosts []string
+	// CIDRs are addresses or ranges, they also lift private range blocking
+	CIDRs []string
+	// Schemes are the allowed URL schemes, "http" and "https" if empty
+	Schemes []string
+	// ForwardInitiator sends the headers describing the client of the node
+	ForwardInitiator bool
+}
+
+type rule struct {
+	Rule
+	index    int
+	prefixes []netip.Prefix
+}
+
+// Init structure is only for initialization
+type Init struct {
+	Rules []Rule
+	// Default is "allow" or "deny" for destinations no rule matches
+	Default      string
+	BlockPrivate bool
+}
+
+// Policy checks outgoing requests and keeps an HTTP transport per rule,
+// so a connection is only reused by requests the same rule allowed
+type Policy struct {
+	rules        []rule
+	allow        bool
+	blockPrivate bool
+
+	mu         sync.Mutex
+	transports map[int]*http.Transport
+}
+
+// ErrDenied is returned for requests the policy does not allow
+var ErrDenied = errors.New("egress denied")
+
+// defaultRule stands for the default of the policy in Decision
+const defaultRule = -1
+
+// New checks the rules and returns the policy
+func New(o *Init) (*Policy, error) {
+	p := &Policy{
+		blockPrivate: o.BlockPrivate,
+		transports:   make(map[int]*http.Transport),
+	}
+	switch strings.ToLower(o.Default) {
+	case "", "allow":
+		p.allow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("egress default must be \"allow\" or \"deny\", not %q", o.Default)
+	}
+
+	for i, r := range o.Rules {
+		if _, err := path.Match(r.Methods, ""); err != nil {
+			return nil, fmt.Errorf("egress rule %d: invalid method pattern %q", i+1, r.Methods)
+		}
+		parsed := rule{Rule: r, index: i}
+		parsed.Hosts = slices.Clone(r.Hosts)
+		for j, host := range r.Hosts {
+			parsed.Hosts[j] = strings.ToLower(host)
+			if _, err := path.Match(parsed.Hosts[j], ""); err != nil {
+				return nil, fmt.Errorf("egress rule %d: invalid host pattern %q", i+1, host)
+			}
+		}
+		for _, cidr := range r.CIDRs {
+			prefix, err := parsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("egress rule %d: %w", i+1, err)
+			}
+			parsed.prefixes = append(parsed.prefixes, prefix)
+		}
+		p.rules = append(p.rules, parsed)
+	}
+	return p, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Decision is the outcome of Check
+type Decision struct {
+	// ForwardInitiator tells whether the destination receives the initiator headers
+	ForwardInitiator bool
+
+	rule *rule
+}
+
+// Check decides whether the com method may request the URL. The addresses
+// the host resolves to are checked later by the transport of the decision.
+func (p *Policy) Check(method string, u *url.URL) (*Decision, error) {
+	host := strings.ToLower(u.Hostname())
+	addr, _ := netip.ParseAddr(host)
+	scheme := strings.ToLower(u.Scheme)
+
+	for i := range p.rules {
+		r := &p.rules[i]
+		if ok, _ := path.Match(r.Methods, method); !ok && r.Methods != "" {
+			continue
+		}
+		if !r.matches(host, addr) {
+			continue
+		}
+		if !r.allowsScheme(scheme) {
+			return nil, fmt.Errorf("%w: scheme %q is not allowed for %s by rule %d", ErrDenied, scheme, host, i+1)
+		}
+		return &Decision{ForwardInitiator: r.ForwardInitiator, rule: r}, nil
+	}
+
+	if !p.allow {
+		return nil, fmt.Errorf("%w: no rule allows %s", ErrDenied, host)
+	}
+	if scheme != "http" && scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %q is not allowed", ErrDenied, scheme)
+	}
+	return &Decision{}, nil
+}
+
+func (r *rule) matches(host string, addr netip.Addr) bool {
+	if len(r.Hosts) == 0 && len(r.prefixes) == 0 {
+		return true
+	}
+	for _, pattern := range r.Hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	if addr.IsValid() {
+		return r.contains(addr.Unmap())
+	}
+	return false
+}
+
+func (r *rule) contains(addr netip.Addr) bool {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) allowsScheme(scheme string) bool {
+	if len(r.Schemes) == 0 {
+		return scheme == "http" || scheme == "https"
+	}
+	return slices.ContainsFunc(r.Schemes, func(s string) bool { return strings.EqualFold(s, scheme) })
+}
+
+// SameRule reports whether both decisions were made by the same rule,
+// a redirect must not leave the rule the request started with
+func (d *Decision) SameRule(other *Decision) bool {
+	return d.rule == other.rule
+}
+
+// Transport returns the transport for the requests of the decision.
+// Connections to the same host are reused between requests of one rule.
+func (p *Policy) Transport(d *Decision) *http.Transport {
+	key := defaultRule
+	if d.rule != nil {
+		key = d.rule.index
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.transports[key]
+	if !ok {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+		// a proxy would be the only address dialed and checked,
+		// the destination behind it would escape the policy
+		t.Proxy = nil
+		t.MaxIdleConnsPerHost = 16
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   p.control(d.rule),
+		}
+		t.DialContext = dialer.DialContext
+		p.transports[key] = t
+	}
+	return t
+}
+
+// CloseIdleConnections closes the idle connections of every transport
+func (p *Policy) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// control checks every address right before connecting to it, after DNS resolution
+func (p *Policy) control(r *rule) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		if !p.blockPrivate {
+			return nil
+		}
+		addrPort, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return fmt.Errorf("%w: unexpected address %q", ErrDenied, address)
+		}
+		addr := addrPort.Addr().Unmap()
+		if !Private(addr) || (r != nil && r.contains(addr)) {
+			return nil
+		}
+		return fmt.Errorf("%w: %s is a private address", ErrDenied, addr)
+	}
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+var thisNetwork = netip.MustParsePrefix("0.0.0.0/8")
+
+// Private reports whether the address is not on the public internet:
+// loopback, private, link-local (cloud metadata lives there), unspecified,
+// multicast or carrier-grade NAT
+func Private(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() ||
+		sharedAddressSpace.Contains(addr) || thisNetwork.Contains(addr)
+}
@@ -0,0 +1,154 @@
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p, err := New(&Init{
+		Default: "deny",
+		Rules: []Rule{
+			{Methods: "Billing.*", Hosts: []string{"api.stripe.com"}, Schemes: []string{"https"}, ForwardInitiator: true},
+			{Methods: "Unit.*", CIDRs: []string{"10.20.0.0/16", "192.168.1.10"}},
+			{Hosts: []string{"*.example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method  string
+		url     string
+		allowed bool
+		forward bool
+	}{
+		{"Billing.Charge", "https://api.stripe.com/v1/charges", true, true},
+		{"Billing.Charge", "https://API.Stripe.com/v1/charges", true, true},
+		{"Billing.Charge", "http://api.stripe.com/v1/charges", false, false},
+		{"Unit.Restart", "https://api.stripe.com/", false, false},
+		{"Unit.Restart", "http://10.20.3.4:8080/restart", true, false},
+		{"Unit.Restart", "http://192.168.1.10/", true, false},
+		{"Unit.Restart", "http://192.168.1.11/", false, false},
+		{"Unit.Restart", "http://[::ffff:10.20.0.1]/", true, false},
+		{"Unit.Restart", "ftp://10.20.3.4/", false, false},
+		{"Other", "https://docs.example.com/", true, false},
+		{"Other", "https://example.com/", false, false},
+		{"Other", "http://169.254.169.254/latest/meta-data/", false, false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		d, err := p.Check(tt.method, u)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s %s: err = %v, want allowed %v", tt.method, tt.url, err, tt.allowed)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrDenied) {
+			t.Errorf("%s %s: %v is not ErrDenied", tt.method, tt.url, err)
+		}
+		if err == nil && d.ForwardInitiator != tt.forward {
+			t.Errorf("%s %s: forward = %v, want %v", tt.method, tt.url, d.ForwardInitiator, tt.forward)
+		}
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, o := range []*Init{
+		{Default: "maybe"},
+		{Rules: []Rule{{Methods: "["}}},
+		{Rules: []Rule{{Hosts: []string{"["}}}},
+		{Rules: []Rule{{CIDRs: []string{"not-an-ip"}}}},
+	} {
+		if _, err := New(o); err == nil {
+			t.Errorf("%+v was accepted", o)
+		}
+	}
+}
+
+func TestTransport_BlockPrivate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	port := srv.URL[strings.LastIndex(srv.URL, ":"):]
+
+	p, err := New(&Init{
+		BlockPrivate: true,
+		Rules:        []Rule{{Methods: "Local.*", CIDRs: []string{"127.0.0.0/8"}, Hosts: []string{"localhost"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.CloseIdleConnections()
+
+	get := func(method, rawURL string) error {
+		u, _ := url.Parse(rawURL)
+		d, err := p.Check(method, u)
+		if err != nil {
+			return err
+		}
+		resp, err := (&http.Client{Transport: p.Transport(d)}).Get(rawURL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	// the host is only refused once it resolves to a loopback address
+	for _, rawURL := range []string{srv.URL, "http://localhost" + port} {
+		if err := get("Other", rawURL); !errors.Is(err, ErrDenied) {
+			t.Errorf("Other %s: err = %v, want ErrDenied", rawURL, err)
+		}
+	}
+	// the cidrs of a rule lift the blocking for its destinations
+	for _, rawURL := range []string{srv.URL, "http://localhost" + port} {
+		if err := get("Local.Ping", rawURL); err != nil {
+			t.Errorf("Local.Ping %s: %v", rawURL, err)
+		}
+	}
+}
+
+func TestTransport_NoProxy(t *testing.T) {
+	p, err := New(&Init{BlockPrivate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("http://example.com")
+	d, err := p.Check("Any", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// HTTP_PROXY and the like must not route around the checks of the dialer
+	if p.Transport(d).Proxy != nil {
+		t.Error("the transport uses a proxy")
+	}
+}
+
+func TestPrivate(t *testing.T) {
+	for addr, private := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.0.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"::ffff:127.0.0.1": true,
+		"8.8.8.8":          false,
+		"2001:4860::8888":  false,
+	} {
+		if got := Private(netip.MustParseAddr(addr)); got != private {
+			t.Errorf("Private(%s) = %v, want %v", addr, got, private)
+		}
+	}
+}
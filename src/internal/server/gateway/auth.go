@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// AuthMiddleware authenticates every request of the router and puts the
+// principal into its context. Requests without credentials pass unchanged,
+// invalid credentials are rejected with 401 whatever the method.
+// A verified client certificate is put into the context even when
+// authentication is disabled, it names the principal when the request
+// carries no other credentials.
+func (gs *GatewayServer) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := auth.CertificateOf(r.TLS)
+		if cert != nil {
+			r = r.WithContext(auth.WithCertificate(r.Context(), cert))
+		}
+		if gs.auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := gs.auth.Authenticate(r)
+		if err != nil {
+			gs.x.SLog.Info("authentication failed", slog.String("err", err.Error()), slog.Group("connection", slog.String("ip", r.RemoteAddr)))
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			rpc.WriteError(w, auth.ErrorResponse(err, nil))
+			return
+		}
+		if p == nil && cert != nil {
+			p = cert.Principal()
+		}
+		if p != nil {
+			gs.x.SLog.Debug("authenticated", slog.String("principal", p.ID), slog.String("method", p.Method))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
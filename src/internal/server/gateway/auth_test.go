@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/acl"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+const whoamiScript = `--[[meta
+{"permissions": ["whoami.read"]}
+]]
+local s = require("internal.session")
+s.response.send({principal = s.auth.principal, method = s.auth.method, roles = s.auth.roles})
+`
+
+func TestAuth(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{
+		"Echo.lua":   echoScript,
+		"Whoami.lua": whoamiScript,
+	})
+	keys := filepath.Join(t.TempDir(), "keys.yaml")
+	err := os.WriteFile(keys, []byte(`
+- principal: alice
+  key: alice-key
+  roles: [admin]
+  permissions: [whoami.read]
+- principal: bob
+  key: bob-key
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs.auth, err = auth.New(&auth.Init{APIKeysFile: keys, Protected: []string{"Echo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := gs.AuthMiddleware(http.HandlerFunc(gs.Handle))
+	send := func(key, method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/com", strings.NewReader(v1(`{"jsonrpc": "2.0", "id": 1, "method": "`+method+`", "params": {"data": 1}}`)))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		key, method string
+		status      int
+		code        int
+	}{
+		{"", "Echo", http.StatusUnauthorized, rpc.ErrUnauthorized},
+		{"wrong-key", "Echo", http.StatusUnauthorized, rpc.ErrUnauthorized},
+		{"bob-key", "Echo", http.StatusOK, 0},
+		{"", "Whoami", http.StatusUnauthorized, rpc.ErrUnauthorized},
+		{"bob-key", "Whoami", http.StatusForbidden, rpc.ErrPermissionDenied},
+		{"alice-key", "Whoami", http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		w := send(tt.key, tt.method)
+		if w.Code != tt.status || errorCode(t, w) != tt.code {
+			t.Errorf("%s as %q: %d %s, want %d and code %d", tt.method, tt.key, w.Code, w.Body, tt.status, tt.code)
+		}
+		if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s as %q: no WWW-Authenticate header", tt.method, tt.key)
+		}
+	}
+
+	// a rejected notification is not answered either
+	r := httptest.NewRequest("POST", "/com", strings.NewReader(v1(`{"jsonrpc": "2.0", "method": "Echo"}`)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("unauthenticated notification: %d %s, want no response", w.Code, w.Body)
+	}
+
+	w = send("alice-key", "Whoami")
+	want := `"result":{"method":"api-key","principal":"alice","roles":["admin"]}`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("session.auth = %s, want %s", w.Body, want)
+	}
+}
+
+func TestACL(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	policy := filepath.Join(t.TempDir(), "acl.yaml")
+	if err := os.WriteFile(policy, []byte("default: deny\nrules:\n  - methods: Echo\n    ips: [192.0.2.0/24]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	gs.acl, err = acl.New(&acl.Init{File: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := v1(`{"jsonrpc": "2.0", "id": 1, "method": "Echo", "params": {"data": 1}}`)
+	w := post(gs, body)
+	if w.Code != http.StatusOK || errorCode(t, w) != 0 {
+		t.Errorf("allowed address: %d %s", w.Code, w.Body)
+	}
+
+	r := httptest.NewRequest("POST", "/com", strings.NewReader(body))
+	r.RemoteAddr = "203.0.113.1:4000"
+	w = httptest.NewRecorder()
+	gs.Handle(w, r)
+	if w.Code != http.StatusForbidden || errorCode(t, w) != rpc.ErrAccessDenied {
+		t.Errorf("denied address: %d %s", w.Code, w.Body)
+	}
+	r = httptest.NewRequest("POST", "/com", strings.NewReader(v1(`{"jsonrpc": "2.0", "method": "Echo"}`)))
+	r.RemoteAddr = "203.0.113.1:4000"
+	w = httptest.NewRecorder()
+	gs.Handle(w, r)
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("denied notification: %d %s, want no response", w.Code, w.Body)
+	}
+
+	// system methods go through the policy as well
+	w = post(gs, `{"jsonrpc": "2.0", "id": 1, "method": "system.listMethods"}`)
+	if errorCode(t, w) != rpc.ErrAccessDenied {
+		t.Errorf("system method: %d %s", w.Code, w.Body)
+	}
+}
+
+// newTestCA returns a CA and a client certificate it signed
+func newTestCA(tb testing.TB, template *x509.Certificate) (*x509.CertPool, tls.Certificate) {
+	tb.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(2)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMTLS(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Whoami.lua": `local s = require("internal.session")
+local c = s.auth.certificate
+s.response.send({principal = s.auth.principal, method = s.auth.method, roles = s.auth.roles, cn = c.common_name, dns = c.dns_names})
+`})
+	var err error
+	gs.auth, err = auth.New(&auth.Init{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(t.TempDir(), "acl.yaml")
+	if err := os.WriteFile(policy, []byte("default: allow\nrules:\n  - methods: Whoami\n    certificates: [\"*.lab.internal\"]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gs.acl, err = acl.New(&acl.Init{File: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, clientCert := newTestCA(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "node-a", OrganizationalUnit: []string{"nodes"}},
+		DNSNames: []string{"node-a.lab.internal"},
+	})
+	srv := httptest.NewUnstartedServer(gs.AuthMiddleware(http.HandlerFunc(gs.Handle)))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	body := v1(`{"jsonrpc": "2.0", "id": 1, "method": "Whoami"}`)
+
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("without a certificate: %d, want 403", resp.StatusCode)
+	}
+
+	// a client of its own, the first one may resume the session opened without a certificate
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{clientCert}
+	defer transport.CloseIdleConnections()
+	client = &http.Client{Transport: transport}
+	resp, err = client.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Result map[string]any `json:"result"`
+		Error  any            `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"principal": "node-a",
+		"method":    auth.MethodCertificate,
+		"roles":     []any{"nodes"},
+		"cn":        "node-a",
+		"dns":       []any{"node-a.lab.internal"},
+	}
+	if !reflect.DeepEqual(out.Result, want) {
+		t.Errorf("with a certificate: %+v, want %+v", out, want)
+	}
+}
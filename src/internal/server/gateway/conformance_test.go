@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// conformanceMethods serve the examples of the JSON-RPC 2.0 specification
+// (https://www.jsonrpc.org/specification#examples). get_data is called
+// getData here, method names of the node cannot contain underscores.
+var conformanceMethods = map[string]string{
+	"subtract.lua": `local s = require("internal.session")
+local p = s.request.params.__fetched
+if p.minuend ~= nil then
+  s.response.send(p.minuend - p.subtrahend)
+end
+s.response.send(p[1] - p[2])
+`,
+	"sum.lua": `local s = require("internal.session")
+local total = 0
+for _, v in ipairs(s.request.params.__fetched) do total = total + v end
+s.response.send(total)
+`,
+	"update.lua": "",
+	"foobar.lua": "",
+	"getData.sh": "#!/bin/sh\necho '{\"result\": [\"hello\", 5]}'\n",
+}
+
+// v1 adds the context version the node needs to the requests of the specification
+func v1(body string) string {
+	body = strings.ReplaceAll(body, `"method": `, `"context-version": "v1", "method": `)
+	return strings.ReplaceAll(body, `"v1", "method": "getData"`, `"v2", "method": "getData"`)
+}
+
+// comparable keeps what the specification shows of a response:
+// the node's own "data" member and the error messages and data are dropped
+func comparable(tb testing.TB, body []byte) any {
+	tb.Helper()
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		tb.Fatalf("cannot decode %q: %v", body, err)
+	}
+	strip := func(v any) any {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return v
+		}
+		delete(m, "data")
+		if e, ok := m["error"].(map[string]any); ok {
+			m["error"] = map[string]any{"code": e["code"]}
+		}
+		return m
+	}
+	if list, ok := v.([]any); ok {
+		for i := range list {
+			list[i] = strip(list[i])
+		}
+		return list
+	}
+	return strip(v)
+}
+
+func TestConformance(t *testing.T) {
+	gs := newTestGateway(t, conformanceMethods)
+
+	tests := []struct {
+		name string
+		req  string
+		// resp is empty when the node must not answer
+		resp string
+	}{
+		{
+			name: "positional parameters",
+			req:  `{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": 1}`,
+			resp: `{"jsonrpc": "2.0", "result": 19, "id": 1}`,
+		},
+		{
+			name: "positional parameters reversed",
+			req:  `{"jsonrpc": "2.0", "method": "subtract", "params": [23, 42], "id": 2}`,
+			resp: `{"jsonrpc": "2.0", "result": -19, "id": 2}`,
+		},
+		{
+			name: "named parameters",
+			req:  `{"jsonrpc": "2.0", "method": "subtract", "params": {"subtrahend": 23, "minuend": 42}, "id": 3}`,
+			resp: `{"jsonrpc": "2.0", "result": 19, "id": 3}`,
+		},
+		{
+			name: "named parameters reordered",
+			req:  `{"jsonrpc": "2.0", "method": "subtract", "params": {"minuend": 42, "subtrahend": 23}, "id": 4}`,
+			resp: `{"jsonrpc": "2.0", "result": 19, "id": 4}`,
+		},
+		{
+			name: "notification",
+			req:  `{"jsonrpc": "2.0", "method": "update", "params": [1,2,3,4,5]}`,
+		},
+		{
+			// only a missing id makes a notification
+			name: "null id",
+			req:  `{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": null}`,
+			resp: `{"jsonrpc": "2.0", "result": 19, "id": null}`,
+		},
+		{
+			name: "null id in a batch",
+			req: `[
+				{"jsonrpc": "2.0", "method": "subtract", "params": [42, 23], "id": null},
+				{"jsonrpc": "2.0", "method": "update", "params": [1]}
+			]`,
+			resp: `[{"jsonrpc": "2.0", "result": 19, "id": null}]`,
+		},
+		{
+			name: "notification without params",
+			req:  `{"jsonrpc": "2.0", "method": "foobar"}`,
+		},
+		{
+			// the later "context-version" overrides the one v1 adds
+			name: "notification of an unknown context version",
+			req:  `{"jsonrpc": "2.0", "method": "update", "context-version": "v9"}`,
+		},
+		{
+			name: "system notification with invalid params",
+			req:  `{"jsonrpc": "2.0", "method": "system.describe", "params": {"method": 1}}`,
+		},
+		{
+			name: "notification of an unknown system method",
+			req:  `{"jsonrpc": "2.0", "method": "system.missing"}`,
+		},
+		{
+			name: "non-existent method",
+			req:  `{"jsonrpc": "2.0", "method": "foobar.missing", "id": "1"}`,
+			resp: `{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "1"}`,
+		},
+		{
+			name: "invalid JSON",
+			req:  `{"jsonrpc": "2.0", "method": "foobar, "params": "bar", "baz]`,
+			resp: `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`,
+		},
+		{
+			name: "invalid request object",
+			req:  `{"jsonrpc": "2.0", "method": 1, "params": "bar"}`,
+			resp: `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`,
+		},
+		{
+			name: "batch with invalid JSON",
+			req: `[
+				{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},
+				{"jsonrpc": "2.0", "method"
+			]`,
+			resp: `{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`,
+		},
+		{
+			name: "empty batch",
+			req:  `[]`,
+			resp: `{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`,
+		},
+		{
+			name: "invalid non-empty batch",
+			req:  `[1]`,
+			resp: `[{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}]`,
+		},
+		{
+			name: "invalid batch",
+			req:  `[1,2,3]`,
+			resp: `[
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}
+			]`,
+		},
+		{
+			name: "batch",
+			req: `[
+				{"jsonrpc": "2.0", "method": "sum", "params": [1,2,4], "id": "1"},
+				{"jsonrpc": "2.0", "method": "notify.hello", "params": [7]},
+				{"jsonrpc": "2.0", "method": "subtract", "params": [42,23], "id": "2"},
+				{"foo": "boo"},
+				{"jsonrpc": "2.0", "method": "foo.get", "params": {"name": "myself"}, "id": "5"},
+				{"jsonrpc": "2.0", "method": "getData", "id": "9"}
+			]`,
+			resp: `[
+				{"jsonrpc": "2.0", "result": 7, "id": "1"},
+				{"jsonrpc": "2.0", "result": 19, "id": "2"},
+				{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+				{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": "5"},
+				{"jsonrpc": "2.0", "result": ["hello", 5], "id": "9"}
+			]`,
+		},
+		{
+			name: "batch of notifications",
+			req: `[
+				{"jsonrpc": "2.0", "method": "notify.sum", "params": [1,2,4]},
+				{"jsonrpc": "2.0", "method": "notify.hello", "params": [7]}
+			]`,
+		},
+		{
+			name: "batch of notifications with an unknown context version",
+			req: `[
+				{"jsonrpc": "2.0", "method": "update", "context-version": "v9"},
+				{"jsonrpc": "2.0", "method": "notify.hello", "params": [7]}
+			]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			gs.Handle(w, httptest.NewRequest("POST", "/com", strings.NewReader(v1(tt.req))))
+
+			if tt.resp == "" {
+				if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+					t.Fatalf("got %d %q, want no response", w.Code, w.Body.String())
+				}
+				return
+			}
+			got := comparable(t, w.Body.Bytes())
+			want := comparable(t, []byte(tt.resp))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %s\nwant %s", w.Body.String(), tt.resp)
+			}
+		})
+	}
+}
+
+func TestBatch_Order(t *testing.T) {
+	for _, concurrency := range []int{0, 1, 3} {
+		gs := newTestGateway(t, conformanceMethods)
+		gs.x.Config.Conf.Node.BatchConcurrency = &concurrency
+
+		var reqs []string
+		var want []float64
+		for i := range 20 {
+			reqs = append(reqs, v1(`{"jsonrpc": "2.0", "method": "sum", "params": [`+strings.Repeat("1,", i)+`0], "id": 1}`))
+			want = append(want, float64(i))
+		}
+		var resp []struct {
+			Result float64 `json:"result"`
+		}
+		call(t, gs, "["+strings.Join(reqs, ",")+"]", &resp)
+		if len(resp) != len(want) {
+			t.Fatalf("concurrency %d: got %d responses", concurrency, len(resp))
+		}
+		for i := range want {
+			if resp[i].Result != want[i] {
+				t.Errorf("concurrency %d: response %d = %v, want %v", concurrency, i, resp[i].Result, want[i])
+			}
+		}
+	}
+}
@@ -3,9 +3,14 @@ package gateway
 import (
 	"context"
 	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/acl"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/ratelimit"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/session"
 )
@@ -24,6 +29,26 @@ type GatewayServer struct {
 	// The key is the version string, and the value is the server implementing GeneralServerApi
 	servers map[serversApiVer]ServerApiContract
 
+	// wsConns and sockConns are the open WebSocket and socket connections
+	wsMu      sync.Mutex
+	wsConns   map[*wsConn]struct{}
+	sockConns map[*sockConn]struct{}
+
+	// inFlight counts the requests being handled, see limits
+	inFlight atomic.Int64
+
+	// reload keeps the methods seen by the last reload of the com directory
+	reload reloadState
+
+	// auth is nil when authentication is disabled
+	auth *auth.Authenticator
+	// acl is nil when access control is disabled
+	acl *acl.ACL
+	// rate is nil when rate limiting is disabled
+	rate *ratelimit.Limiter
+	// origins are the cross-origin pages allowed to call the node, see AllowOrigin
+	origins []string
+
 	sm *session.SessionManager
 	cs *corestate.CoreState
 	x  *app.AppX
@@ -5,6 +5,9 @@ import (
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/acl"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/ratelimit"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/session"
 )
 
@@ -13,15 +16,31 @@ type GatewayServerInit struct {
 	SM *session.SessionManager
 	CS *corestate.CoreState
 	X  *app.AppX
+	// Auth authenticates the clients, nil leaves every method open
+	Auth *auth.Authenticator
+	// ACL is checked before every call, nil allows everything
+	ACL *acl.ACL
+	// RateLimit is checked for every request or batch whatever the transport,
+	// nil does not limit
+	RateLimit *ratelimit.Limiter
+	// AllowedOrigins are the origins of the browser pages allowed to call
+	// the node besides its own, "*" allows any
+	AllowedOrigins []string
 }
 
 // InitGeneral initializes a new GeneralServer with the provided configuration and registered servers.
 func InitGateway(o *GatewayServerInit, servers ...ServerApiContract) *GatewayServer {
 	general := &GatewayServer{
-		servers: make(map[serversApiVer]ServerApiContract),
-		sm:      o.SM,
-		cs:      o.CS,
-		x:       o.X,
+		servers:   make(map[serversApiVer]ServerApiContract),
+		wsConns:   make(map[*wsConn]struct{}),
+		sockConns: make(map[*sockConn]struct{}),
+		sm:        o.SM,
+		cs:        o.CS,
+		x:         o.X,
+		auth:      o.Auth,
+		acl:       o.ACL,
+		rate:      o.RateLimit,
+		origins:   o.AllowedOrigins,
 	}
 
 	// register the provided servers
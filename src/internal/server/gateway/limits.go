@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// limits are the resolved config.Limits, 0 disables a limit
+type limits struct {
+	maxBodySize    int64
+	maxBatchLength int
+	maxDepth       int
+	maxInFlight    int64
+}
+
+func (gs *GatewayServer) limits() limits {
+	cfg := gs.x.Config.Conf.Limits
+	if cfg == nil {
+		cfg = &config.Limits{}
+	}
+	return limits{
+		maxBodySize:    utils.SafeFetch(cfg.MaxBodySize, 1<<20),
+		maxBatchLength: utils.SafeFetch(cfg.MaxBatchLength, 100),
+		maxDepth:       utils.SafeFetch(cfg.MaxDepth, 64),
+		maxInFlight:    int64(utils.SafeFetch(cfg.MaxInFlight, 256)),
+	}
+}
+
+// acquire takes an in-flight slot of the node, false if all of them are taken
+func (gs *GatewayServer) acquire() bool {
+	limit := gs.limits().maxInFlight
+	if limit <= 0 {
+		return true
+	}
+	if gs.inFlight.Add(1) > limit {
+		gs.inFlight.Add(-1)
+		gs.x.SLog.Warn("request rejected", slog.String("issue", rpc.ErrNodeIsBusyS), slog.Int64("limit", limit))
+		return false
+	}
+	return true
+}
+
+func (gs *GatewayServer) release() {
+	if gs.limits().maxInFlight > 0 {
+		gs.inFlight.Add(-1)
+	}
+}
+
+// exceedsDepth reports whether objects and arrays of the JSON text
+// are nested deeper than max
+func exceedsDepth(data []byte, max int) bool {
+	if max <= 0 {
+		return false
+	}
+	depth := 0
+	inString, escaped := false, false
+	for _, c := range data {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			switch c {
+			case '\\':
+				escaped = true
+			case '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > max {
+				return true
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return false
+}
+
+// httpStatus is the status of a plain HTTP answer. Only errors about the
+// request as a whole change it, errors of a method are still 200.
+func httpStatus(resp any) int {
+	single, ok := resp.(*rpc.RPCResponse)
+	if !ok || single.Error == nil {
+		return http.StatusOK
+	}
+	e, _ := single.Error.(map[string]any)
+	switch e["code"] {
+	case rpc.ErrParseError, rpc.ErrInvalidRequest, rpc.ErrRequestTooDeep:
+		return http.StatusBadRequest
+	case rpc.ErrRequestTooLarge, rpc.ErrBatchTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case rpc.ErrNodeIsBusy:
+		return http.StatusServiceUnavailable
+	case rpc.ErrUnauthorized:
+		return http.StatusUnauthorized
+	case rpc.ErrPermissionDenied, rpc.ErrAccessDenied:
+		return http.StatusForbidden
+	case rpc.ErrRateLimited:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,201 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/ratelimit"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+	"github.com/gorilla/websocket"
+)
+
+func post(gs *GatewayServer, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	gs.Handle(w, httptest.NewRequest("POST", "/com", strings.NewReader(body)))
+	return w
+}
+
+func errorCode(tb testing.TB, w *httptest.ResponseRecorder) int {
+	tb.Helper()
+	var resp struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		tb.Fatalf("cannot decode %q: %v", w.Body.String(), err)
+	}
+	return resp.Error.Code
+}
+
+func TestLimits(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	maxBody, maxBatch, maxDepth := int64(256), 3, 4
+	gs.x.Config.Conf.Limits = &config.Limits{MaxBodySize: &maxBody, MaxBatchLength: &maxBatch, MaxDepth: &maxDepth}
+
+	request := func(params string) string {
+		return `{"jsonrpc": "2.0", "id": 1, "method": "Echo", "context-version": "v1", "params": ` + params + `}`
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   int
+	}{
+		{"within limits", request(`{"a": [[1]]}`), http.StatusOK, 0},
+		{"body too large", request(`{"a": "` + strings.Repeat("x", 256) + `"}`), http.StatusRequestEntityTooLarge, rpc.ErrRequestTooLarge},
+		{"nested too deeply", request(`{"a": [[[1]]]}`), http.StatusBadRequest, rpc.ErrRequestTooDeep},
+		{"brackets in strings", request(`{"a": "[[[[{{{{\"[["}`), http.StatusOK, 0},
+		{"batch too long", `[1, 2, 3, 4]`, http.StatusRequestEntityTooLarge, rpc.ErrBatchTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(gs, tt.body)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if code := errorCode(t, w); code != tt.code {
+				t.Errorf("error code = %d, want %d: %s", code, tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimits_InFlight(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Slow.sh": "#!/bin/sh\nsleep 1\necho '{\"result\": 1}'\n"})
+	maxInFlight := 1
+	gs.x.Config.Conf.Limits = &config.Limits{MaxInFlight: &maxInFlight}
+	slow := `{"jsonrpc": "2.0", "id": 1, "method": "Slow", "context-version": "v2"}`
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(gs, slow) }()
+	for deadline := time.Now().Add(time.Second); gs.inFlight.Load() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the first request never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	w := post(gs, slow)
+	if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != rpc.ErrNodeIsBusy {
+		t.Errorf("second request = %d %s, want the node to be busy", w.Code, w.Body.String())
+	}
+	if w := <-done; w.Code != http.StatusOK || errorCode(t, w) != 0 {
+		t.Errorf("first request = %d %s", w.Code, w.Body.String())
+	}
+	if n := gs.inFlight.Load(); n != 0 {
+		t.Errorf("%d requests still in flight", n)
+	}
+}
+
+func TestExceedsDepth(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want bool
+	}{
+		{`1`, 1, false},
+		{`[]`, 1, false},
+		{`[[]]`, 1, true},
+		{`[{}, {}, [1]]`, 2, false},
+		{`{"a": {"b": {}}}`, 2, true},
+		{`"[[[["`, 1, false},
+		{`["\"[[", "\\", [1]]`, 2, false},
+		{`[[[[[]]]]]`, 0, false},
+	}
+	for _, tt := range tests {
+		if got := exceedsDepth([]byte(tt.in), tt.max); got != tt.want {
+			t.Errorf("exceedsDepth(%s, %d) = %v, want %v", tt.in, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestLimits_RateLimit(t *testing.T) {
+	newLimited := func(tb testing.TB) *GatewayServer {
+		gs := newTestGateway(tb, map[string]string{"Echo.lua": echoScript})
+		limiter, err := ratelimit.New(&ratelimit.Init{Rules: []ratelimit.Rule{
+			{Methods: "Echo", Key: ratelimit.KeyIP, Rate: 0.01, Burst: 1},
+		}})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		gs.rate = limiter
+		return gs
+	}
+	echo := `{"jsonrpc": "2.0", "id": 1, "method": "Echo", "context-version": "v1", "params": {}}`
+	// codes returns the error codes of two answers, 0 for a result
+	codes := func(tb testing.TB, read func() []byte) map[int]int {
+		tb.Helper()
+		seen := make(map[int]int)
+		for range 2 {
+			var resp struct {
+				Error struct {
+					Code int `json:"code"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(read(), &resp); err != nil {
+				tb.Fatal(err)
+			}
+			seen[resp.Error.Code]++
+		}
+		return seen
+	}
+
+	t.Run("http", func(t *testing.T) {
+		gs := newLimited(t)
+		if w := post(gs, echo); w.Code != http.StatusOK {
+			t.Fatalf("first request = %d %s", w.Code, w.Body.String())
+		}
+		w := post(gs, echo)
+		if w.Code != http.StatusTooManyRequests || errorCode(t, w) != rpc.ErrRateLimited || w.Header().Get("Retry-After") != "100" {
+			t.Errorf("second request = %d %s, Retry-After %q", w.Code, w.Body.String(), w.Header().Get("Retry-After"))
+		}
+		if w := post(gs, "["+echo+"]"); w.Code != http.StatusTooManyRequests {
+			t.Errorf("batch = %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("ws", func(t *testing.T) {
+		conn := dialWS(t, newLimited(t))
+		for range 2 {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(echo)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		seen := codes(t, func() []byte {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		})
+		if seen[0] != 1 || seen[rpc.ErrRateLimited] != 1 {
+			t.Errorf("answers = %v, want one result and one rate limited", seen)
+		}
+	})
+
+	t.Run("socket", func(t *testing.T) {
+		conn := serveTestSocket(t, newLimited(t), "unix", filepath.Join(t.TempDir(), "node.sock"))
+		br := bufio.NewReader(conn)
+		fmt.Fprint(conn, echo+"\n"+echo+"\n")
+		seen := codes(t, func() []byte {
+			line, err := br.ReadBytes('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			return line
+		})
+		if seen[0] != 1 || seen[rpc.ErrRateLimited] != 1 {
+			t.Errorf("answers = %v, want one result and one rate limited", seen)
+		}
+	})
+}
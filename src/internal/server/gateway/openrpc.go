@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/openrpc"
+)
+
+// OpenRPC describes every method of the node. An empty version describes
+// all context versions, serverURL may be empty as well.
+func (gs *GatewayServer) OpenRPC(version, serverURL string) (*openrpc.Document, error) {
+	methods, err := gs.ListMethods()
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		var filtered []metadata.Method
+		for _, m := range methods {
+			if m.ContextVersion == version || m.ContextVersion == "" {
+				filtered = append(filtered, m)
+			}
+		}
+		methods = filtered
+	}
+
+	title := "GoSally node"
+	if name := gs.x.Config.Conf.Node.Name; name != nil && *name != "" && *name != "noname" {
+		title = *name
+	}
+	return openrpc.Build(&openrpc.Init{
+		Title:     title,
+		Version:   config.NodeVersion,
+		ServerURL: serverURL,
+	}, methods), nil
+}
+
+// HandleOpenRPC serves the OpenRPC document,
+// ?context-version=v1 limits it to a single context version
+func (gs *GatewayServer) HandleOpenRPC(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	doc, err := gs.OpenRPC(r.URL.Query().Get("context-version"), scheme+"://"+r.Host+config.ComDirRoute)
+	if err != nil {
+		gs.x.SLog.Error("cannot build the OpenRPC document", slog.String("error", err.Error()))
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		gs.x.SLog.Debug("cannot write the OpenRPC document", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/dirwatch"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+)
+
+// ReloaderContract is implemented by servers that keep state built from the
+// com directory. changed holds the files of added, removed and changed methods.
+type ReloaderContract interface {
+	Reload(changed []string) error
+}
+
+// ReloadStatus is the result of system.reloadStatus. LastError says why the
+// last reload failed, it holds paths and compile errors and is only shown
+// to principals with SystemStatusPermission.
+type ReloadStatus struct {
+	Reloads    int64      `json:"reloads"`
+	LastReload *time.Time `json:"last-reload,omitempty"`
+	Failed     bool       `json:"failed"`
+	LastError  string     `json:"last-error,omitempty"`
+	WatchMode  string     `json:"watch-mode,omitempty"`
+	Methods    int        `json:"methods"`
+}
+
+type reloadState struct {
+	mu      sync.Mutex
+	methods map[string]methodStamp
+	status  ReloadStatus
+}
+
+// methodStamp tells whether a method file or its sidecar have changed
+type methodStamp struct {
+	path    string
+	file    fileStamp
+	sidecar fileStamp
+}
+
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+}
+
+func methodKey(m metadata.Method) string {
+	return m.ContextVersion + ":" + m.Name
+}
+
+// WatchComDir reloads the com directory every time it changes, until ctx is done
+func (gs *GatewayServer) WatchComDir(ctx context.Context) error {
+	methods, err := gs.stampMethods()
+	if err != nil {
+		return err
+	}
+	gs.reload.mu.Lock()
+	gs.reload.methods = methods
+	gs.reload.status.Methods = len(methods)
+	gs.reload.mu.Unlock()
+
+	interval := utils.SafeFetch(gs.x.Config.Conf.Node.ReloadInterval, 2*time.Second)
+	mode, err := dirwatch.Watch(ctx, *gs.x.Config.Conf.Node.ComDir, interval, gs.Reload)
+	if err != nil {
+		return err
+	}
+	gs.reload.mu.Lock()
+	gs.reload.status.WatchMode = string(mode)
+	gs.reload.mu.Unlock()
+	gs.x.SLog.Info("watching the com directory", slog.String("mode", string(mode)))
+	return nil
+}
+
+// ReloadNotice are the params of the system.reloaded notification. Every
+// connected client gets it, so it tells only whether the reload failed.
+type ReloadNotice struct {
+	Reloads int64 `json:"reloads"`
+	Failed  bool  `json:"failed"`
+}
+
+// Reload compares the methods with the previous reload, logs the difference
+// and lets every server drop what it keeps from the com directory.
+// WebSocket and socket clients get a system.reloaded notification.
+func (gs *GatewayServer) Reload() {
+	status := gs.reloadComDir()
+	gs.Broadcast("system.reloaded", ReloadNotice{Reloads: status.Reloads, Failed: status.Failed})
+}
+
+func (gs *GatewayServer) reloadComDir() ReloadStatus {
+	gs.reload.mu.Lock()
+	defer gs.reload.mu.Unlock()
+
+	var errs []error
+	var changed []string
+	methods, err := gs.stampMethods()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		changed = gs.logChanges(gs.reload.methods, methods)
+		gs.reload.methods = methods
+		gs.reload.status.Methods = len(methods)
+	}
+
+	for _, server := range gs.servers {
+		if reloader, ok := server.(ReloaderContract); ok {
+			if err := reloader.Reload(changed); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	now := time.Now()
+	gs.reload.status.Reloads++
+	gs.reload.status.LastReload = &now
+	gs.reload.status.Failed = false
+	gs.reload.status.LastError = ""
+	if err := errors.Join(errs...); err != nil {
+		gs.reload.status.Failed = true
+		gs.reload.status.LastError = err.Error()
+		gs.x.SLog.Error("com directory reload failed", slog.String("error", err.Error()))
+		return gs.reload.status
+	}
+	gs.x.SLog.Info("com directory reloaded", slog.Int("methods", len(methods)), slog.Int("changed", len(changed)))
+	return gs.reload.status
+}
+
+// ReloadStatus returns the reload counters
+func (gs *GatewayServer) ReloadStatus() ReloadStatus {
+	gs.reload.mu.Lock()
+	defer gs.reload.mu.Unlock()
+	return gs.reload.status
+}
+
+func (gs *GatewayServer) stampMethods() (map[string]methodStamp, error) {
+	methods, err := gs.ListMethods()
+	if err != nil {
+		return nil, err
+	}
+	stamps := make(map[string]methodStamp)
+	for _, m := range methods {
+		if m.Path == "" {
+			continue
+		}
+		stamps[methodKey(m)] = methodStamp{
+			path:    m.Path,
+			file:    stampOf(m.Path),
+			sidecar: stampOf(metadata.SidecarPath(m.Path)),
+		}
+	}
+	return stamps, nil
+}
+
+// logChanges logs added, removed and changed methods and returns their files
+func (gs *GatewayServer) logChanges(before, after map[string]methodStamp) []string {
+	var changed []string
+	for key, s := range after {
+		old, ok := before[key]
+		switch {
+		case !ok:
+			gs.x.SLog.Info("method added", slog.String("method", key), slog.String("path", s.path))
+		case old != s:
+			gs.x.SLog.Info("method changed", slog.String("method", key), slog.String("path", s.path))
+		default:
+			continue
+		}
+		changed = append(changed, s.path)
+		if ok && old.path != s.path {
+			changed = append(changed, old.path)
+		}
+	}
+	for key, s := range before {
+		if _, ok := after[key]; !ok {
+			gs.x.SLog.Info("method removed", slog.String("method", key), slog.String("path", s.path))
+			changed = append(changed, s.path)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/dirwatch"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+)
+
+// waitReloads waits until the com directory has been reloaded n times
+func waitReloads(tb testing.TB, gs *GatewayServer, n int64) ReloadStatus {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := gs.ReloadStatus(); status.Reloads >= n {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	tb.Fatalf("the com directory was not reloaded %d times", n)
+	return ReloadStatus{}
+}
+
+func TestWatchComDir(t *testing.T) {
+	old := dirwatch.Debounce
+	dirwatch.Debounce = 20 * time.Millisecond
+	defer func() { dirwatch.Debounce = old }()
+
+	gs := newTestGateway(t, map[string]string{
+		"Echo.lua": "",
+	})
+	comDir := *gs.x.Config.Conf.Node.ComDir
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := gs.WatchComDir(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := gs.ReloadStatus(); status.Methods != 1 || status.WatchMode == "" {
+		t.Fatalf("status = %+v", status)
+	}
+
+	if err := os.MkdirAll(filepath.Join(comDir, "Unit"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(comDir, "Unit", "Get.lua"), []byte("local x = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status := waitReloads(t, gs, 1)
+	for status.Methods != 2 {
+		status = waitReloads(t, gs, status.Reloads+1)
+	}
+	if status.LastError != "" {
+		t.Errorf("LastError = %q", status.LastError)
+	}
+
+	conn := dialWS(t, gs)
+
+	// a syntax error is reported by the next reload
+	if err := os.WriteFile(filepath.Join(comDir, "Echo.lua"), []byte("local = \n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status = waitReloads(t, gs, status.Reloads+1)
+	if status.LastError == "" || !status.Failed {
+		t.Error("the broken script is not reported")
+	}
+
+	// clients are only told that the reload failed
+	for {
+		var note struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := conn.ReadJSON(&note); err != nil {
+			t.Fatal(err)
+		}
+		var notice map[string]any
+		if err := json.Unmarshal(note.Params, &notice); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := notice["last-error"]; ok || len(notice) != 2 {
+			t.Fatalf("%s = %s, want the counter and the failure only", note.Method, note.Params)
+		}
+		if notice["failed"] == true {
+			break
+		}
+	}
+
+	// the error itself needs the permission once authentication is enabled
+	var err error
+	gs.auth, err = auth.New(&auth.Init{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadStatus := func(p *auth.Principal) ReloadStatus {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/com", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "system.reloadStatus"}`))
+		if p != nil {
+			r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		}
+		w := httptest.NewRecorder()
+		gs.Handle(w, r)
+		var resp struct {
+			Result ReloadStatus `json:"result"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Result
+	}
+	if status := reloadStatus(&auth.Principal{ID: "bob"}); !status.Failed || status.LastError != "" {
+		t.Errorf("status without the permission = %+v", status)
+	}
+	if status := reloadStatus(&auth.Principal{ID: "alice", Permissions: []string{SystemStatusPermission}}); status.LastError == "" {
+		t.Errorf("status with the permission = %+v", status)
+	}
+}
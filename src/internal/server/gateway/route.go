@@ -1,14 +1,19 @@
 package gateway
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 	"github.com/google/uuid"
 )
@@ -32,7 +37,18 @@ func (gs *GatewayServer) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 	defer gs.sm.Delete(sessionUUID)
 
+	lim := gs.limits()
+	if lim.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, lim.maxBodySize)
+	}
 	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrRequestTooLargeS), slog.Int64("limit", tooLarge.Limit))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		rpc.WriteError(w, rpc.NewError(rpc.ErrRequestTooLarge, rpc.ErrRequestTooLargeS, map[string]any{"limit": tooLarge.Limit}, nil))
+		return
+	}
 	if err != nil {
 		gs.x.SLog.Debug("failed to read body", slog.String("err", err.Error()))
 		w.WriteHeader(http.StatusBadRequest)
@@ -41,52 +57,175 @@ func (gs *GatewayServer) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// determine if the JSON-RPC request is a batch
-	var batch []rpc.RPCRequest
-	json.Unmarshal(body, &batch)
-	var single rpc.RPCRequest
-	if batch == nil {
-		if err := json.Unmarshal(body, &single); err != nil {
-			gs.x.SLog.Debug("failed to parse json", slog.String("err", err.Error()))
-			w.WriteHeader(http.StatusBadRequest)
-			rpc.WriteError(w, rpc.NewError(rpc.ErrParseError, rpc.ErrParseErrorS, nil, nil))
-			gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrParseErrorS))
+	// with Accept: text/event-stream methods can stream notifications
+	// before the response, the status is 200 whatever happens next
+	if acceptsEventStream(r) {
+		if sse := newSSEStream(w); sse != nil {
+			sse.start()
+			resp := gs.dispatch(rpc.WithNotifier(ctx, sse), sessionUUID, r, body)
+			if err := sse.finish(resp); err != nil {
+				gs.x.SLog.Debug("failed to write event stream", slog.String("err", err.Error()))
+			}
 			return
 		}
-		resp := gs.Route(ctx, sessionUUID, r, &single)
-		if resp == nil {
-			w.Write([]byte(""))
-			return
+	}
+
+	resp := gs.dispatch(ctx, sessionUUID, r, body)
+	switch resp := resp.(type) {
+	case nil:
+		// notifications only, nothing to answer
+		w.WriteHeader(http.StatusNoContent)
+	case *rpc.RPCResponse:
+		if status := httpStatus(resp); status != http.StatusOK {
+			switch status {
+			case http.StatusServiceUnavailable:
+				w.Header().Set("Retry-After", "1")
+			case http.StatusTooManyRequests:
+				w.Header().Set("Retry-After", retryAfter(resp))
+			case http.StatusUnauthorized:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			w.WriteHeader(status)
 		}
 		rpc.WriteResponse(w, resp)
-		return
+	case []rpc.RPCResponse:
+		json.NewEncoder(w).Encode(resp)
 	}
+}
 
-	// handle batch
-	responses := make(chan rpc.RPCResponse, len(batch))
+// dispatch parses a single request or a batch and routes it. The result is
+// *rpc.RPCResponse, []rpc.RPCResponse or nil if there is nothing to answer.
+// A single error response is returned when the body as a whole is not
+// a request or breaks the limits, see httpStatus.
+func (gs *GatewayServer) dispatch(ctx context.Context, sid string, r *http.Request, body []byte) any {
+	lim := gs.limits()
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrParseErrorS))
+		return rpc.NewError(rpc.ErrParseError, rpc.ErrParseErrorS, nil, nil)
+	}
+	if exceedsDepth(body, lim.maxDepth) {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrRequestTooDeepS), slog.Int("limit", lim.maxDepth))
+		return rpc.NewError(rpc.ErrRequestTooDeep, rpc.ErrRequestTooDeepS, map[string]any{"limit": lim.maxDepth}, nil)
+	}
+
+	if body[0] != '[' {
+		req, err := rpc.ParseRequest(body)
+		if err != nil {
+			gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidRequestS), slog.String("err", err.Error()))
+			return rpc.NewError(rpc.ErrInvalidRequest, rpc.ErrInvalidRequestS, err.Error(), req.ID)
+		}
+		if resp := gs.rateLimit(ctx, sid, r, []*rpc.RPCRequest{req}); resp != nil {
+			if req.ID == nil {
+				return nil
+			}
+			resp.ID = req.ID
+			return resp
+		}
+		if resp := gs.Route(ctx, sid, r, req); resp != nil {
+			return resp
+		}
+		return nil
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidRequestS), slog.String("err", "empty batch"))
+		return rpc.NewError(rpc.ErrInvalidRequest, rpc.ErrInvalidRequestS, "empty batch", nil)
+	}
+	if lim.maxBatchLength > 0 && len(batch) > lim.maxBatchLength {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrBatchTooLargeS), slog.Int("length", len(batch)), slog.Int("limit", lim.maxBatchLength))
+		return rpc.NewError(rpc.ErrBatchTooLarge, rpc.ErrBatchTooLargeS, map[string]any{"limit": lim.maxBatchLength}, nil)
+	}
+	return gs.dispatchBatch(ctx, sid, r, batch)
+}
+
+// dispatchBatch routes the requests of a batch, at most BatchConcurrency of
+// them at the same time, and answers in the order of the batch.
+// Returns nil if every request was a notification.
+func (gs *GatewayServer) dispatchBatch(ctx context.Context, sid string, r *http.Request, batch []json.RawMessage) any {
+	concurrency := utils.SafeFetch(gs.x.Config.Conf.Node.BatchConcurrency, 4)
+	if concurrency <= 0 || concurrency > len(batch) {
+		concurrency = len(batch)
+	}
+
+	responses := make([]*rpc.RPCResponse, len(batch))
+	requests := make([]*rpc.RPCRequest, len(batch))
+	var valid []*rpc.RPCRequest
+	for i, raw := range batch {
+		req, err := rpc.ParseRequest(raw)
+		if err != nil {
+			gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidRequestS), slog.String("err", err.Error()))
+			responses[i] = rpc.NewError(rpc.ErrInvalidRequest, rpc.ErrInvalidRequestS, err.Error(), req.ID)
+			continue
+		}
+		requests[i] = req
+		valid = append(valid, req)
+	}
+	// the batch is limited as a whole, like a single request
+	if resp := gs.rateLimit(ctx, sid, r, valid); resp != nil {
+		return resp
+	}
+
+	sem := make(chan struct{}, concurrency)
 	var wg sync.WaitGroup
-	for _, m := range batch {
+	for i, req := range requests {
+		if req == nil {
+			continue
+		}
+		sem <- struct{}{}
 		wg.Add(1)
-		go func(req rpc.RPCRequest) {
-			defer wg.Done()
-			res := gs.Route(ctx, sessionUUID, r, &req)
-			if res != nil {
-				responses <- *res
-			}
-		}(m)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			responses[i] = gs.Route(ctx, sid, r, req)
+		}()
 	}
 	wg.Wait()
-	close(responses)
 
 	var result []rpc.RPCResponse
-	for res := range responses {
-		result = append(result, res)
+	for _, res := range responses {
+		if res != nil {
+			result = append(result, *res)
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return result
+}
+
+// rateLimit takes the tokens of the requests from the limiter,
+// the response tells the client how long to wait when they are short
+func (gs *GatewayServer) rateLimit(ctx context.Context, sid string, r *http.Request, requests []*rpc.RPCRequest) *rpc.RPCResponse {
+	if gs.rate == nil || len(requests) == 0 {
+		return nil
+	}
+	methods := make([]string, len(requests))
+	for i, req := range requests {
+		methods[i] = req.Method
 	}
-	if len(result) > 0 {
-		json.NewEncoder(w).Encode(result)
-	} else {
-		w.Write([]byte("[]"))
+	resp := gs.rate.Check(ctx, r, methods)
+	if resp != nil {
+		gs.x.SLog.Info("request rate limited",
+			slog.String("session-uuid", sid),
+			slog.Any("methods", methods),
+			slog.Group("connection", slog.String("ip", r.RemoteAddr)),
+		)
+	}
+	return resp
+}
+
+// retryAfter returns the seconds of an ErrRateLimited response as a header value
+func retryAfter(resp *rpc.RPCResponse) string {
+	e, _ := resp.Error.(map[string]any)
+	data, _ := e["data"].(map[string]any)
+	if seconds, ok := data["retry-after"].(int); ok {
+		return strconv.Itoa(seconds)
 	}
+	return "1"
 }
 
 func (gs *GatewayServer) Route(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest) (resp *rpc.RPCResponse) {
@@ -99,16 +238,63 @@ func (gs *GatewayServer) Route(ctx context.Context, sid string, r *http.Request,
 		return rpc.NewError(rpc.ErrInvalidRequest, rpc.ErrInvalidRequestS, nil, req.ID)
 	}
 
+	if gs.auth != nil && gs.auth.Protected(req.Method) {
+		if _, ok := auth.PrincipalFrom(ctx); !ok {
+			gs.x.SLog.Info("unauthenticated request rejected", slog.String("requested-method", req.Method))
+			if req.ID == nil {
+				return nil
+			}
+			return auth.ErrorResponse(auth.ErrUnauthenticated, req.ID)
+		}
+	}
+
+	if gs.acl != nil {
+		if d := gs.acl.Check(ctx, r, req.Method); !d.Allowed {
+			gs.x.SLog.Warn("request denied by policy",
+				slog.String("session-uuid", sid),
+				slog.String("requested-method", req.Method),
+				slog.String("principal", d.Principal),
+				slog.String("ip", d.IP.String()),
+				slog.String("rule", d.Rule),
+			)
+			if req.ID == nil {
+				return nil
+			}
+			return rpc.NewError(rpc.ErrAccessDenied, rpc.ErrAccessDeniedS, map[string]any{"method": req.Method}, req.ID)
+		}
+	}
+
+	if !gs.acquire() {
+		if req.ID == nil {
+			return nil
+		}
+		return rpc.NewError(rpc.ErrNodeIsBusy, rpc.ErrNodeIsBusyS, nil, req.ID)
+	}
+
+	if strings.HasPrefix(req.Method, SystemPrefix) {
+		defer gs.release()
+		return gs.handleSystem(ctx, req)
+	}
+
 	server, ok := gs.servers[serversApiVer(req.ContextVersion)]
 	if !ok {
+		gs.release()
 		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrContextVersionS), slog.String("requested-version", req.ContextVersion))
+		if req.ID == nil {
+			return nil
+		}
 		return rpc.NewError(rpc.ErrContextVersion, rpc.ErrContextVersionS, nil, req.ID)
 	}
 
 	// checks if request is notification
+	// the notification outlives the http request, so it must not be canceled with it
 	if req.ID == nil {
-		go server.Handle(ctx, sid, r, req)
+		go func() {
+			defer gs.release()
+			server.Handle(context.WithoutCancel(ctx), sid, r, req)
+		}()
 		return nil
 	}
+	defer gs.release()
 	return server.Handle(ctx, sid, r, req)
 }
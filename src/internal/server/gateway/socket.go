@@ -0,0 +1,278 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+	"github.com/google/uuid"
+)
+
+// Socket connection settings
+var (
+	// SocketMaxInFlight is the number of messages of one connection handled at the same time,
+	// reading stops while all of them are busy
+	SocketMaxInFlight = 16
+	// SocketWriteTimeout limits writing a single message
+	SocketWriteTimeout = 10 * time.Second
+)
+
+// Framing of the messages of a socket connection
+const (
+	// FramingNewline is one JSON text per line
+	FramingNewline = "newline"
+	// FramingContentLength is a "Content-Length: N" header, an empty line and N bytes of JSON,
+	// the way the Language Server Protocol frames its messages
+	FramingContentLength = "content-length"
+)
+
+var errMessageTooLarge = errors.New("message is too large")
+
+// sockConn is a raw TCP or Unix socket connection. The framing is detected
+// from the first message, the node answers with the same framing.
+// Like a WebSocket, the connection is a single session and requests are
+// answered in the order they finish.
+type sockConn struct {
+	gs   *GatewayServer
+	conn net.Conn
+	br   *bufio.Reader
+	sid  string
+	log  *slog.Logger
+
+	writeMu sync.Mutex
+	framing string
+	closed  bool
+}
+
+// ServeSocket serves JSON-RPC on every connection accepted by l until ctx is done
+// or the listener fails. The listener is closed when ServeSocket returns.
+func (gs *GatewayServer) ServeSocket(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go gs.serveSocketConn(ctx, conn)
+	}
+}
+
+func (gs *GatewayServer) serveSocketConn(ctx context.Context, conn net.Conn) {
+	sessionUUID := uuid.New().String()
+	remote := conn.RemoteAddr().String()
+	if remote == "" || remote == "@" {
+		remote = conn.LocalAddr().Network() + ":" + conn.LocalAddr().String()
+	}
+	llog := gs.x.SLog.With(slog.String("session-uuid", sessionUUID))
+
+	if !gs.sm.Add(sessionUUID) {
+		conn.Close()
+		return
+	}
+	defer gs.sm.Delete(sessionUUID)
+
+	c := &sockConn{gs: gs, conn: conn, br: bufio.NewReader(conn), sid: sessionUUID, log: llog}
+	gs.wsMu.Lock()
+	gs.sockConns[c] = struct{}{}
+	gs.wsMu.Unlock()
+	defer func() {
+		gs.wsMu.Lock()
+		delete(gs.sockConns, c)
+		gs.wsMu.Unlock()
+		c.close()
+		llog.Debug("socket closed")
+	}()
+	llog.Debug("socket opened", slog.Group("connection", slog.String("ip", remote)))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = rpc.WithNotifier(ctx, c)
+
+	// the servers take what they need about the client from an HTTP request,
+	// a socket connection has no headers, only the address
+	r := (&http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: config.ComDirRoute},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"X-Session-Uuid": {sessionUUID}},
+		RemoteAddr: remote,
+	}).WithContext(ctx)
+
+	c.serve(ctx, r)
+}
+
+func (c *sockConn) serve(ctx context.Context, r *http.Request) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// requests in flight are canceled as soon as the client is gone
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, SocketMaxInFlight)
+	// a message is a request body, limits.max_body_size applies to it as well
+	maxBody := math.MaxInt
+	if limit := c.gs.limits().maxBodySize; limit > 0 && limit < math.MaxInt {
+		maxBody = int(limit)
+	}
+	for {
+		msg, framing, err := readFrame(c.br, maxBody)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.log.Debug("socket read failed", slog.String("err", err.Error()))
+			}
+			if errors.Is(err, errMessageTooLarge) {
+				c.write(rpc.NewError(rpc.ErrRequestTooLarge, rpc.ErrRequestTooLargeS, map[string]any{"limit": maxBody}, nil))
+			}
+			return
+		}
+		c.writeMu.Lock()
+		if c.framing == "" {
+			c.framing = framing
+		}
+		c.writeMu.Unlock()
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			resp := c.gs.dispatch(ctx, c.sid, r, msg)
+			if resp == nil {
+				return
+			}
+			if err := c.write(resp); err != nil {
+				c.log.Debug("socket write failed", slog.String("err", err.Error()))
+			}
+		}()
+	}
+}
+
+// readFrame reads the next message, either a line or a Content-Length framed body.
+// Empty lines between messages are skipped.
+func readFrame(br *bufio.Reader, limit int) ([]byte, string, error) {
+	for {
+		line, err := readLine(br, limit)
+		if err != nil {
+			return nil, "", err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '{' || line[0] == '[' {
+			return line, FramingNewline, nil
+		}
+
+		name, value, ok := strings.Cut(string(line), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			// not a header, leave it to the parser to report
+			return line, FramingNewline, nil
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || length < 0 {
+			return nil, "", fmt.Errorf("invalid Content-Length %q", strings.TrimSpace(value))
+		}
+		if length > limit {
+			return nil, "", errMessageTooLarge
+		}
+		// skip the other headers up to the empty line
+		for {
+			header, err := readLine(br, limit)
+			if err != nil {
+				return nil, "", err
+			}
+			if len(bytes.TrimSpace(header)) == 0 {
+				break
+			}
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return nil, "", err
+		}
+		return body, FramingContentLength, nil
+	}
+}
+
+// readLine reads up to and without '\n', failing on lines longer than limit
+func readLine(br *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if len(line)+len(chunk)-1 > limit {
+			return nil, errMessageTooLarge
+		}
+		line = append(line, chunk...)
+		switch {
+		case err == nil:
+			return line[:len(line)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(line) > 0:
+			// the last message may go without a newline
+			return line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+var errSocketClosed = errors.New("socket is closed")
+
+func (c *sockConn) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errSocketClosed
+	}
+	if c.framing == FramingContentLength {
+		data = append(fmt.Appendf(nil, "Content-Length: %d\r\n\r\n", len(data)), data...)
+	} else {
+		data = append(data, '\n')
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(SocketWriteTimeout))
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// Notify implements rpc.Notifier
+func (c *sockConn) Notify(n *rpc.RPCNotification) error {
+	return c.write(n)
+}
+
+func (c *sockConn) close() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.conn.Close()
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveTestSocket(tb testing.TB, gs *GatewayServer, network, address string) net.Conn {
+	tb.Helper()
+	l, err := net.Listen(network, address)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gs.ServeSocket(ctx, l)
+	}()
+	tb.Cleanup(func() {
+		cancel()
+		<-done
+		gs.CloseStreams()
+	})
+
+	conn, err := net.Dial(network, l.Addr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestServeSocket_Newline(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	conn := serveTestSocket(t, gs, "unix", filepath.Join(t.TempDir(), "node.sock"))
+	br := bufio.NewReader(conn)
+
+	fmt.Fprint(conn, `{"jsonrpc": "2.0", "id": 1, "method": "Echo", "context-version": "v1", "params": {"n": 1}}`+"\n")
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		ID     int            `json:"id"`
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 1 || resp.Result["n"] != 1.0 {
+		t.Errorf("response = %q", line)
+	}
+
+	fmt.Fprint(conn, "\nnot json\n")
+	line, err = br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(line, `"code":-32700`) {
+		t.Errorf("response = %q, want a parse error", line)
+	}
+}
+
+func TestServeSocket_ContentLength(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Tail.lua": streamScript})
+	conn := serveTestSocket(t, gs, "tcp", "127.0.0.1:0")
+	br := bufio.NewReader(conn)
+
+	fmt.Fprintf(conn, "Content-Length: %d\r\nContent-Type: application/json\r\n\r\n%s", len(streamRequest), streamRequest)
+
+	// three stream notifications, then the response
+	for i := 0; i < 4; i++ {
+		header, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var length int
+		if _, err := fmt.Sscanf(header, "Content-Length: %d\r\n", &length); err != nil {
+			t.Fatalf("header = %q: %v", header, err)
+		}
+		if blank, _ := br.ReadString('\n'); blank != "\r\n" {
+			t.Fatalf("separator = %q", blank)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 && msg["method"] != "session.stream" {
+			t.Errorf("message %d = %s, want a stream notification", i, body)
+		}
+		if i == 3 && msg["id"] != 7.0 {
+			t.Errorf("message %d = %s, want the response", i, body)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		in      string
+		msg     string
+		framing string
+		err     bool
+	}{
+		{in: `{"a":1}` + "\n", msg: `{"a":1}`, framing: FramingNewline},
+		{in: "\r\n\n" + `[1]` + "\r\n", msg: `[1]`, framing: FramingNewline},
+		{in: `{"a":1}`, msg: `{"a":1}`, framing: FramingNewline},
+		{in: "content-length: 7\r\n\r\n{\"a\":1}", msg: `{"a":1}`, framing: FramingContentLength},
+		{in: "Content-Length: 2\n\n{}", msg: `{}`, framing: FramingContentLength},
+		{in: "Content-Length: x\r\n\r\n", err: true},
+		{in: "Content-Length: 100\r\n\r\n{}", err: true},
+		{in: strings.Repeat("1", 65) + "\n", err: true},
+		{in: "Content-Length: 65\r\n\r\n", err: true},
+	}
+	for _, tt := range tests {
+		msg, framing, err := readFrame(bufio.NewReaderSize(strings.NewReader(tt.in), 16), 64)
+		if tt.err {
+			if err == nil {
+				t.Errorf("readFrame(%q) = %q, want an error", tt.in, msg)
+			}
+			continue
+		}
+		if err != nil || string(msg) != tt.msg || framing != tt.framing {
+			t.Errorf("readFrame(%q) = %q, %q, %v", tt.in, msg, framing, err)
+		}
+	}
+}
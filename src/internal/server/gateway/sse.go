@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// sseStream answers a POST with Server-Sent Events instead of a single JSON
+// body, so notifications can reach the client before the response.
+// Notifications are "notification" events, the response is the last,
+// "response" event.
+type sseStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	closed  bool
+}
+
+var errSSEClosed = errors.New("event stream is closed")
+
+// acceptsEventStream reports whether the client asked for Server-Sent Events
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// newSSEStream returns nil if the writer cannot flush
+func newSSEStream(w http.ResponseWriter) *sseStream {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+	return &sseStream{w: w, flusher: flusher}
+}
+
+func (s *sseStream) event(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSSEClosed
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// start sends the headers, so the client sees the stream open right away
+func (s *sseStream) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+// Notify implements rpc.Notifier
+func (s *sseStream) Notify(n *rpc.RPCNotification) error {
+	return s.event("notification", n)
+}
+
+// finish sends the response and refuses any later notification,
+// the handler is about to return and the writer becomes invalid
+func (s *sseStream) finish(resp any) error {
+	var err error
+	if resp != nil {
+		err = s.event("response", resp)
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return err
+}
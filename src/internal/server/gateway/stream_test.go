@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+const streamScript = `local s = require("internal.session")
+for i = 1, 3 do
+  s.response.stream({n = i})
+end
+s.response.send({streaming = s.response.streaming})
+`
+
+const streamRequest = `{"jsonrpc": "2.0", "id": 7, "method": "Tail", "context-version": "v1"}`
+
+func TestStream_WS(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Tail.lua": streamScript})
+	conn := dialWS(t, gs)
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(streamRequest)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		var note struct {
+			Method string `json:"method"`
+			Params struct {
+				ID     int            `json:"id"`
+				Method string         `json:"method"`
+				Seq    int            `json:"seq"`
+				Item   map[string]any `json:"item"`
+			} `json:"params"`
+		}
+		if err := conn.ReadJSON(&note); err != nil {
+			t.Fatal(err)
+		}
+		p := note.Params
+		if note.Method != "session.stream" || p.ID != 7 || p.Method != "Tail" || p.Seq != i || p.Item["n"] != float64(i) {
+			t.Errorf("notification %d = %+v", i, note)
+		}
+	}
+
+	var resp struct {
+		ID     int            `json:"id"`
+		Result map[string]any `json:"result"`
+	}
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.ID != 7 || resp.Result["streaming"] != true {
+		t.Errorf("response = %+v", resp)
+	}
+}
+
+func TestStream_SSE(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Tail.lua": streamScript})
+
+	req := httptest.NewRequest("POST", "/com", strings.NewReader(streamRequest))
+	req.Header.Set("Accept", "text/event-stream")
+	w := httptest.NewRecorder()
+	gs.Handle(w, req)
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	if len(events) != 4 {
+		t.Fatalf("got %d events:\n%s", len(events), w.Body.String())
+	}
+	for _, e := range events[:3] {
+		if !strings.HasPrefix(e, "event: notification\ndata: ") {
+			t.Errorf("event = %q", e)
+		}
+	}
+	if !strings.HasPrefix(events[3], "event: response\ndata: ") || !strings.Contains(events[3], `"streaming":true`) {
+		t.Errorf("last event = %q", events[3])
+	}
+}
+
+func TestStream_PlainPOST(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Tail.lua": streamScript})
+
+	var resp struct {
+		Result map[string]any `json:"result"`
+	}
+	call(t, gs, streamRequest, &resp)
+	if resp.Result["streaming"] != false {
+		t.Errorf("result = %+v, a plain POST cannot stream", resp.Result)
+	}
+}
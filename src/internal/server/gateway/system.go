@@ -0,0 +1,183 @@
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// SystemPrefix is the namespace of the methods built into the gateway.
+// Requests in it never reach the versioned servers, whatever their context version.
+var SystemPrefix = "system."
+
+// SystemStatusPermission lets a principal see the errors of the last reload
+// in system.reloadStatus, everyone may see them while authentication is disabled
+var SystemStatusPermission = "system.status"
+
+// MethodListerContract is implemented by servers that can list the methods they serve
+type MethodListerContract interface {
+	ListMethods() ([]metadata.Method, error)
+}
+
+type systemMethod struct {
+	meta   *metadata.Metadata
+	handle func(gs *GatewayServer, ctx context.Context, req *rpc.RPCRequest, params map[string]any) *rpc.RPCResponse
+}
+
+// systemMethods are filled in init, the handlers refer to the map themselves
+var systemMethods map[string]*systemMethod
+
+func init() {
+	versionParam := metadata.Schema{
+		"type":        "string",
+		"description": "context version of the methods, all versions if omitted",
+	}
+	systemMethods = map[string]*systemMethod{
+		"system.listMethods": {
+			meta: &metadata.Metadata{
+				Description: "Lists the methods of the node with their metadata",
+				Params: metadata.Schema{
+					"type": "object",
+					"properties": map[string]any{
+						"context-version": versionParam,
+					},
+				},
+				Result: metadata.Schema{"type": "array"},
+			},
+			handle: (*GatewayServer).systemListMethods,
+		},
+		"system.describe": {
+			meta: &metadata.Metadata{
+				Description: "Describes a single method",
+				Params: metadata.Schema{
+					"type":     "object",
+					"required": []any{"method"},
+					"properties": map[string]any{
+						"method":          metadata.Schema{"type": "string"},
+						"context-version": versionParam,
+					},
+				},
+				Result: metadata.Schema{"type": "object"},
+			},
+			handle: (*GatewayServer).systemDescribe,
+		},
+		"system.reloadStatus": {
+			meta: &metadata.Metadata{
+				Description: "Reports how many times the com directory was reloaded and whether the last reload failed. The error itself is only reported to principals with the " + SystemStatusPermission + " permission.",
+				Params:      metadata.Schema{"type": "object", "maxProperties": 0},
+				Result: metadata.Schema{
+					"type": "object",
+					"properties": map[string]any{
+						"reloads":     metadata.Schema{"type": "integer"},
+						"last-reload": metadata.Schema{"type": "string", "format": "date-time"},
+						"failed":      metadata.Schema{"type": "boolean"},
+						"last-error":  metadata.Schema{"type": "string"},
+						"watch-mode":  metadata.Schema{"enum": []any{"fsnotify", "polling"}},
+						"methods":     metadata.Schema{"type": "integer"},
+					},
+				},
+			},
+			handle: (*GatewayServer).systemReloadStatus,
+		},
+	}
+}
+
+// ListMethods returns the system methods and the methods of every server
+// that can list them, sorted by name and context version
+func (gs *GatewayServer) ListMethods() ([]metadata.Method, error) {
+	var methods []metadata.Method
+	for name, m := range systemMethods {
+		methods = append(methods, metadata.Method{Name: name, Metadata: m.meta})
+	}
+	for _, server := range gs.servers {
+		lister, ok := server.(MethodListerContract)
+		if !ok {
+			continue
+		}
+		list, err := lister.ListMethods()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, list...)
+	}
+	slices.SortFunc(methods, func(a, b metadata.Method) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.ContextVersion, b.ContextVersion))
+	})
+	return methods, nil
+}
+
+func (gs *GatewayServer) handleSystem(ctx context.Context, req *rpc.RPCRequest) *rpc.RPCResponse {
+	// system methods have no side effects, a notification has nothing to do
+	// and is not answered, not even with an error
+	if req.ID == nil {
+		return nil
+	}
+	method, ok := systemMethods[req.Method]
+	if !ok {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrMethodNotFoundS), slog.String("requested-method", req.Method))
+		return rpc.NewError(rpc.ErrMethodNotFound, rpc.ErrMethodNotFoundS, nil, req.ID)
+	}
+	if errs := method.meta.ValidateParams(req.Params); errs != nil {
+		gs.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidParamsS), slog.String("requested-method", req.Method))
+		return rpc.NewError(rpc.ErrInvalidParams, rpc.ErrInvalidParamsS, map[string]any{"errors": errs}, req.ID)
+	}
+	params, _ := req.Params.(map[string]any)
+	return method.handle(gs, ctx, req, params)
+}
+
+func (gs *GatewayServer) systemListMethods(_ context.Context, req *rpc.RPCRequest, params map[string]any) *rpc.RPCResponse {
+	methods, err := gs.ListMethods()
+	if err != nil {
+		gs.x.SLog.Error("cannot list methods", slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	if version, ok := params["context-version"].(string); ok {
+		methods = slices.DeleteFunc(methods, func(m metadata.Method) bool {
+			return m.ContextVersion != version && !strings.HasPrefix(m.Name, SystemPrefix)
+		})
+	}
+	public := make([]metadata.Method, 0, len(methods))
+	for _, m := range methods {
+		public = append(public, m.Public())
+	}
+	return rpc.NewResponse(public, req.ID)
+}
+
+func (gs *GatewayServer) systemDescribe(_ context.Context, req *rpc.RPCRequest, params map[string]any) *rpc.RPCResponse {
+	name := params["method"].(string)
+	version, filtered := params["context-version"].(string)
+
+	methods, err := gs.ListMethods()
+	if err != nil {
+		gs.x.SLog.Error("cannot list methods", slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	for _, m := range methods {
+		if m.Name != name {
+			continue
+		}
+		if filtered && m.ContextVersion != version && !strings.HasPrefix(m.Name, SystemPrefix) {
+			continue
+		}
+		return rpc.NewResponse(m.Public(), req.ID)
+	}
+	return rpc.NewError(rpc.ErrInvalidParams, rpc.ErrInvalidParamsS, map[string]any{
+		"errors": []metadata.ValidationError{{Path: "/method", Message: "no such method"}},
+	}, req.ID)
+}
+
+func (gs *GatewayServer) systemReloadStatus(ctx context.Context, req *rpc.RPCRequest, _ map[string]any) *rpc.RPCResponse {
+	status := gs.ReloadStatus()
+	if gs.auth != nil {
+		if p, ok := auth.PrincipalFrom(ctx); !ok || !p.Has(SystemStatusPermission) {
+			status.LastError = ""
+		}
+	}
+	return rpc.NewResponse(status, req.ID)
+}
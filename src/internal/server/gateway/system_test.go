@@ -0,0 +1,175 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/session"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv1"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/sv2"
+)
+
+// newTestGateway returns a gateway with sv1 and sv2 serving a com directory
+// made of the given files, executable if their content starts with "#!"
+func newTestGateway(tb testing.TB, files map[string]string) *GatewayServer {
+	tb.Helper()
+	comDir := tb.TempDir()
+	for name, content := range files {
+		path := filepath.Join(comDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		mode := os.FileMode(0o644)
+		if strings.HasPrefix(content, "#!") {
+			mode = 0o755
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	x := &app.AppX{
+		Log:  log.New(io.Discard, "", 0),
+		SLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Config: &config.Compositor{Conf: &config.Conf{
+			Node: &config.Node{ComDir: &comDir},
+			Lua:  &config.Lua{},
+			SV2:  &config.SV2{},
+		}},
+	}
+	allowed := regexp.MustCompile(`^[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*$`)
+	serverv1 := sv1.InitV1Server(&sv1.HandlerV1InitStruct{X: x, CS: &corestate.CoreState{}, AllowedCmd: allowed, Ver: "v1"})
+	serverv2 := sv2.InitServer(&sv2.HandlerInitStruct{X: x, CS: &corestate.CoreState{}, AllowedCmd: allowed, Ver: "v2"})
+	tb.Cleanup(func() {
+		serverv1.Shutdown()
+		serverv2.Shutdown()
+	})
+	return InitGateway(&GatewayServerInit{
+		SM: session.New(time.Minute),
+		CS: &corestate.CoreState{},
+		X:  x,
+	}, serverv1, serverv2)
+}
+
+// call posts the body to the gateway and decodes the response into out
+func call(tb testing.TB, gs *GatewayServer, body string, out any) {
+	tb.Helper()
+	w := httptest.NewRecorder()
+	gs.Handle(w, httptest.NewRequest("POST", "/com", strings.NewReader(body)))
+	if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+		tb.Fatalf("cannot decode %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestSystem_ListMethods(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{
+		"Echo.lua":             "--[[meta\n{\"description\": \"echo\"}\n]]\n",
+		"_prepare.lua":         "",
+		"Unit/Get.lua":         "",
+		"Unit/_common.lua":     "",
+		"Tools/Disk.sh":        "#!/bin/sh\n",
+		"Tools/Disk.meta.json": `{"description": "disk usage"}`,
+		"Tools/README.md":      "not a module",
+	})
+
+	var resp struct {
+		Result []metadata.Method `json:"result"`
+	}
+	call(t, gs, `{"jsonrpc": "2.0", "id": 1, "method": "system.listMethods"}`, &resp)
+
+	var got []string
+	for _, m := range resp.Result {
+		got = append(got, m.ContextVersion+":"+m.Name)
+	}
+	want := []string{"v1:Echo", "v2:Tools.Disk", "v1:Unit.Get", ":system.describe", ":system.listMethods", ":system.reloadStatus"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("methods = %v, want %v", got, want)
+	}
+	if resp.Result[0].Metadata.Description != "echo" || resp.Result[1].Metadata.Description != "disk usage" {
+		t.Errorf("metadata is missing: %+v %+v", resp.Result[0].Metadata, resp.Result[1].Metadata)
+	}
+}
+
+func TestSystem_ListMethods_Resilient(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{
+		"Echo.lua":              "--[[meta\n{\"description\": \"echo\", \"timeout\": \"1s\", \"memory_limit\": 1024, \"instruction_limit\": 10}\n]]\n",
+		"Broken.lua":            "--[[meta\n{not json\n]]\n",
+		"Bad/Sidecar.lua":       "",
+		"Bad/Sidecar.meta.json": `{"timeout": 5}`,
+		"Tools/Disk.sh":         "#!/bin/sh\n",
+		"Tools/Disk.meta.json":  `{"description": "disk usage", "worker": {"processes": 2}}`,
+	})
+
+	w := httptest.NewRecorder()
+	gs.Handle(w, httptest.NewRequest("POST", "/com", strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "system.listMethods"}`)))
+	// the limits and the worker of a method are internal
+	for _, field := range []string{"timeout", "memory_limit", "instruction_limit", "worker"} {
+		if strings.Contains(w.Body.String(), field) {
+			t.Errorf("%s is listed: %s", field, w.Body.String())
+		}
+	}
+
+	var resp struct {
+		Result []metadata.Method `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// a method with invalid metadata is left out, the others are listed
+	var got []string
+	for _, m := range resp.Result {
+		if !strings.HasPrefix(m.Name, SystemPrefix) {
+			got = append(got, m.Name)
+		}
+	}
+	if strings.Join(got, " ") != "Echo Tools.Disk" {
+		t.Errorf("methods = %v, want Echo and Tools.Disk", got)
+	}
+	if resp.Result[0].Metadata.Description != "echo" || resp.Result[1].Metadata.Description != "disk usage" {
+		t.Errorf("metadata is missing: %+v %+v", resp.Result[0].Metadata, resp.Result[1].Metadata)
+	}
+
+	var described struct {
+		Result json.RawMessage `json:"result"`
+	}
+	call(t, gs, `{"jsonrpc": "2.0", "id": 1, "method": "system.describe", "params": {"method": "Echo"}}`, &described)
+	if !strings.Contains(string(described.Result), "echo") || strings.Contains(string(described.Result), "timeout") {
+		t.Errorf("describe = %s, want the description only", described.Result)
+	}
+}
+
+func TestSystem_Describe(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{
+		"Echo.lua": "--[[meta\n{\"description\": \"echo\"}\n]]\n",
+	})
+
+	var resp struct {
+		Result metadata.Method `json:"result"`
+		Error  struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	call(t, gs, `{"jsonrpc": "2.0", "id": 1, "method": "system.describe", "params": {"method": "Echo"}}`, &resp)
+	if resp.Result.Name != "Echo" || resp.Result.Metadata.Description != "echo" {
+		t.Errorf("result = %+v", resp.Result)
+	}
+
+	resp.Result = metadata.Method{}
+	call(t, gs, `{"jsonrpc": "2.0", "id": 1, "method": "system.describe", "params": {"method": "Echo", "context-version": "v2"}}`, &resp)
+	if resp.Error.Code != -32602 {
+		t.Errorf("error code = %d, want invalid params for a method of another version", resp.Error.Code)
+	}
+}
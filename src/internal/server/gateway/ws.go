@@ -0,0 +1,279 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection settings
+var (
+	// WSMaxInFlight is the number of messages of one connection handled at the same time,
+	// reading stops while all of them are busy
+	WSMaxInFlight = 16
+	// WSPingInterval is how often the node pings the client,
+	// a client silent for two intervals is disconnected
+	WSPingInterval = 30 * time.Second
+	// WSWriteTimeout limits writing a single message
+	WSWriteTimeout = 10 * time.Second
+)
+
+// AllowOrigin reports whether a browser page of the origin may call the node:
+// open a WebSocket or read the answers of cross-origin HTTP requests.
+// Requests without an Origin header do not come from a browser page and
+// same-origin pages are always allowed, other origins only if they are listed
+// in http_server.allowed_origins, "*" allowing any.
+func (gs *GatewayServer) AllowOrigin(r *http.Request, origin string) bool {
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range gs.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (gs *GatewayServer) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			return gs.AllowOrigin(r, r.Header.Get("Origin"))
+		},
+	}
+}
+
+// wsConn is a client connection. Every message is a JSON-RPC request or batch,
+// answered in the order the requests finish, not the order they came in.
+type wsConn struct {
+	gs   *GatewayServer
+	conn *websocket.Conn
+	sid  string
+	log  *slog.Logger
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// HandleWS upgrades the request to a WebSocket that carries JSON-RPC
+// in both directions: requests from the client, responses and
+// notifications from the node. The session stays busy while it is open,
+// and at most until the credentials it was opened with expire.
+func (gs *GatewayServer) HandleWS(w http.ResponseWriter, r *http.Request) {
+	sessionUUID := r.Header.Get("X-Session-UUID")
+	if sessionUUID == "" {
+		sessionUUID = r.URL.Query().Get("session-uuid")
+	}
+	if sessionUUID == "" {
+		sessionUUID = uuid.New().String()
+	}
+	llog := gs.x.SLog.With(slog.String("session-uuid", sessionUUID))
+
+	if !gs.sm.Add(sessionUUID) {
+		llog.Debug("session is busy")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		rpc.WriteError(w, rpc.NewError(rpc.ErrSessionIsBusy, rpc.ErrSessionIsBusyS, nil, nil))
+		return
+	}
+	defer gs.sm.Delete(sessionUUID)
+
+	conn, err := gs.upgrader().Upgrade(w, r, http.Header{"X-Session-UUID": {sessionUUID}})
+	if err != nil {
+		// the upgrader has already answered the client
+		llog.Debug("websocket upgrade failed", slog.String("err", err.Error()))
+		return
+	}
+	llog.Debug("websocket opened", slog.Group("connection", slog.String("ip", r.RemoteAddr)))
+
+	c := &wsConn{gs: gs, conn: conn, sid: sessionUUID, log: llog}
+	gs.wsMu.Lock()
+	gs.wsConns[c] = struct{}{}
+	gs.wsMu.Unlock()
+	defer func() {
+		gs.wsMu.Lock()
+		delete(gs.wsConns, c)
+		gs.wsMu.Unlock()
+		c.close(websocket.CloseNormalClosure, "")
+		llog.Debug("websocket closed")
+	}()
+
+	if p, ok := auth.PrincipalFrom(r.Context()); ok && !p.Expires.IsZero() {
+		expiry := time.AfterFunc(time.Until(p.Expires), func() {
+			llog.Debug("credentials expired, closing the websocket", slog.String("principal", p.ID))
+			c.close(websocket.ClosePolicyViolation, "credentials expired")
+		})
+		defer expiry.Stop()
+	}
+
+	// requests outlive the upgrade handler's context only as long as the connection
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	ctx = rpc.WithNotifier(ctx, c)
+
+	c.serve(ctx, r)
+}
+
+func (c *wsConn) serve(ctx context.Context, r *http.Request) {
+	// a message is a request body, limits.max_body_size applies to it as well
+	if maxBody := c.gs.limits().maxBodySize; maxBody > 0 {
+		c.conn.SetReadLimit(maxBody)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(2 * WSPingInterval))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(2 * WSPingInterval))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.ping(done)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// requests in flight are canceled as soon as the client is gone
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sem := make(chan struct{}, WSMaxInFlight)
+	for {
+		kind, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				c.log.Debug("websocket read failed", slog.String("err", err.Error()))
+			}
+			return
+		}
+		if kind != websocket.TextMessage && kind != websocket.BinaryMessage {
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			resp := c.gs.dispatch(ctx, c.sid, r, msg)
+			if resp == nil {
+				return
+			}
+			if err := c.write(resp); err != nil {
+				c.log.Debug("websocket write failed", slog.String("err", err.Error()))
+			}
+		}()
+	}
+}
+
+func (c *wsConn) ping(done chan struct{}) {
+	ticker := time.NewTicker(WSPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.writeMu.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(WSWriteTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errWSClosed = errors.New("websocket is closed")
+
+func (c *wsConn) write(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(WSWriteTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Notify implements rpc.Notifier
+func (c *wsConn) Notify(n *rpc.RPCNotification) error {
+	return c.write(n)
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.conn.Close()
+}
+
+// Broadcast sends the notification to every open WebSocket and socket connection
+func (gs *GatewayServer) Broadcast(method string, params any) {
+	n := rpc.NewNotification(method, params)
+	gs.wsMu.Lock()
+	conns := make([]*wsConn, 0, len(gs.wsConns))
+	for c := range gs.wsConns {
+		conns = append(conns, c)
+	}
+	socks := make([]*sockConn, 0, len(gs.sockConns))
+	for c := range gs.sockConns {
+		socks = append(socks, c)
+	}
+	gs.wsMu.Unlock()
+
+	for _, c := range conns {
+		if err := c.Notify(n); err != nil {
+			c.log.Debug("websocket notification failed", slog.String("err", err.Error()))
+		}
+	}
+	for _, c := range socks {
+		if err := c.Notify(n); err != nil {
+			c.log.Debug("socket notification failed", slog.String("err", err.Error()))
+		}
+	}
+}
+
+// CloseStreams closes every open WebSocket and socket connection,
+// http.Server.Shutdown does not touch hijacked connections
+func (gs *GatewayServer) CloseStreams() {
+	gs.wsMu.Lock()
+	conns := make([]*wsConn, 0, len(gs.wsConns))
+	for c := range gs.wsConns {
+		conns = append(conns, c)
+	}
+	socks := make([]*sockConn, 0, len(gs.sockConns))
+	for c := range gs.sockConns {
+		socks = append(socks, c)
+	}
+	gs.wsMu.Unlock()
+
+	for _, c := range conns {
+		c.close(websocket.CloseGoingAway, "node is shutting down")
+	}
+	for _, c := range socks {
+		c.close()
+	}
+}
@@ -0,0 +1,178 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/gorilla/websocket"
+)
+
+const echoScript = `local s = require("internal.session")
+s.response.send(s.request.params.get())
+`
+
+func dialWS(tb testing.TB, gs *GatewayServer) *websocket.Conn {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(gs.HandleWS))
+	tb.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func TestHandleWS(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	conn := dialWS(t, gs)
+
+	send := func(msg string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receive := func(v any) {
+		t.Helper()
+		if err := conn.ReadJSON(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(`{"jsonrpc": "2.0", "id": 1, "method": "Echo", "context-version": "v1", "params": {"n": 1}}`)
+	var single struct {
+		ID     int            `json:"id"`
+		Result map[string]any `json:"result"`
+	}
+	receive(&single)
+	if single.ID != 1 || single.Result["n"] != 1.0 {
+		t.Errorf("response = %+v", single)
+	}
+
+	send(`[
+		{"jsonrpc": "2.0", "id": 2, "method": "Echo", "context-version": "v1", "params": {}},
+		{"jsonrpc": "2.0", "method": "Echo", "context-version": "v1", "params": {}},
+		{"jsonrpc": "2.0", "id": 3, "method": "system.reloadStatus"}
+	]`)
+	var batch []map[string]any
+	receive(&batch)
+	if len(batch) != 2 {
+		t.Errorf("batch = %v, want two responses", batch)
+	}
+
+	gs.Broadcast("system.reloaded", map[string]any{"reloads": 1})
+	var note struct {
+		ID     *int   `json:"id"`
+		Method string `json:"method"`
+	}
+	receive(&note)
+	if note.Method != "system.reloaded" || note.ID != nil {
+		t.Errorf("notification = %+v", note)
+	}
+
+	gs.CloseStreams()
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("err = %v, want the node to close the connection", err)
+	}
+}
+
+func TestHandleWS_ParseError(t *testing.T) {
+	gs := newTestGateway(t, nil)
+	conn := dialWS(t, gs)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc": `)); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := conn.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Code != -32700 {
+		t.Errorf("code = %d, want a parse error", resp.Error.Code)
+	}
+	// the connection survives a bad message
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc": "2.0", "id": 1, "method": "system.reloadStatus"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&json.RawMessage{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleWS_Expiry(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := &auth.Principal{ID: "alice", Method: auth.MethodJWT, Expires: time.Now().Add(300 * time.Millisecond)}
+		gs.HandleWS(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
+	}))
+	defer srv.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the connection serves until the credentials expire
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echo", "context-version": "v1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ReadJSON(&json.RawMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("read after expiry = %v, want a policy violation close", err)
+	}
+}
+
+func TestHandleWS_Origin(t *testing.T) {
+	gs := newTestGateway(t, map[string]string{"Echo.lua": echoScript})
+	srv := httptest.NewServer(http.HandlerFunc(gs.HandleWS))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, srv.URL, true},
+		{"other origin", nil, "https://evil.example", false},
+		{"listed origin", []string{"https://panel.example"}, "https://PANEL.example", true},
+		{"unlisted origin", []string{"https://panel.example"}, "https://evil.example", false},
+		{"any origin", []string{"*"}, "https://evil.example", true},
+	}
+	for _, tt := range tests {
+		gs.origins = tt.allowed
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.want {
+			t.Errorf("%s: dial = %v, want allowed %v", tt.name, err, tt.want)
+		}
+		if !tt.want && resp != nil && resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Package metadata reads the sidecar files that describe com methods.
+// The sidecar lives next to the method file and has the same name with the
+// ".meta.json" extension: com/Tools/Disk.py is described by com/Tools/Disk.meta.json.
+//
+// Lua scripts may carry the same JSON in a block comment at the top of the file
+// instead, leading line comments and blank lines are allowed before it:
+//
+//	-- com/Echo.lua
+//	--[[meta
+//	{
+//	  "description": "Sends the params back",
+//	  "params": {"type": "object", "required": ["data"]}
+//	}
+//	]]
+//
+// When both exist, fields of the sidecar take precedence.
+package metadata
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SidecarExt is the extension of method metadata files
+var SidecarExt = ".meta.json"
+
+// Metadata is the content of a sidecar file. Every field is optional.
+type Metadata struct {
+	// Description is a human readable summary of the method
+	Description string `json:"description,omitempty"`
+	// Params is the JSON schema of the request params, they are validated
+	// against it before the method runs
+	Params Schema `json:"params,omitempty"`
+	// Result is the JSON schema of the result, for documentation only
+	Result Schema `json:"result,omitempty"`
+	// Permissions lists what the caller must be allowed to do to call the method
+	Permissions []string `json:"permissions,omitempty"`
+
+	// Worker turns an sv2 module into a long-lived process
+	Worker *Worker `json:"worker,omitempty"`
+
+	// Timeout overrides lua.timeout for the method
+	Timeout *Duration `json:"timeout,omitempty"`
+	// InstructionLimit overrides lua.instruction_limit for the method
+	InstructionLimit *int64 `json:"instruction_limit,omitempty"`
+	// MemoryLimit overrides lua.memory_limit for the method
+	MemoryLimit *int64 `json:"memory_limit,omitempty"`
+}
+
+// Worker describes a persistent sv2 module speaking line-delimited JSON-RPC
+type Worker struct {
+	// Processes is the number of module processes kept running
+	Processes int `json:"processes"`
+}
+
+// Duration is a time.Duration written as a string like "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// SidecarPath returns the path of the sidecar file for the method file
+func SidecarPath(methodPath string) string {
+	return strings.TrimSuffix(methodPath, filepath.Ext(methodPath)) + SidecarExt
+}
+
+// Load reads the metadata of the method file: the header of a Lua script
+// and then the sidecar. Missing metadata is not an error,
+// an empty Metadata is returned instead.
+func Load(methodPath string) (*Metadata, error) {
+	var meta Metadata
+	if filepath.Ext(methodPath) == ".lua" {
+		header, err := readLuaHeader(methodPath)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			if err := json.Unmarshal(header, &meta); err != nil {
+				return nil, fmt.Errorf("invalid metadata header in %s: %w", methodPath, err)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(SidecarPath(methodPath))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("invalid metadata %s: %w", SidecarPath(methodPath), err)
+		}
+	}
+
+	if meta.Worker != nil && meta.Worker.Processes < 1 {
+		meta.Worker.Processes = 1
+	}
+	return &meta, nil
+}
+
+// ValidateParams checks request params against the Params schema.
+// Absent params are checked as an empty object, or an empty array
+// if the schema asks for one.
+func (m *Metadata) ValidateParams(params any) []ValidationError {
+	if m.Params == nil {
+		return nil
+	}
+	if params == nil {
+		params = map[string]any{}
+		if m.Params["type"] == "array" {
+			params = []any{}
+		}
+	}
+	return m.Params.Validate(params)
+}
+
+// Public returns the metadata clients may see: the description, the schemas
+// and the permissions. The worker and the limits of the method are left out.
+func (m *Metadata) Public() *Metadata {
+	if m == nil {
+		return nil
+	}
+	return &Metadata{
+		Description: m.Description,
+		Params:      m.Params,
+		Result:      m.Result,
+		Permissions: m.Permissions,
+	}
+}
+
+// maxHeaderSize limits how much of a script is read looking for the header
+const maxHeaderSize = 64 << 10
+
+// readLuaHeader returns the content of the --[[meta ... ]] block
+// at the top of the script, nil if there is none
+func readLuaHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxHeaderSize))
+	if err != nil {
+		return nil, err
+	}
+	src := string(data)
+	if strings.HasPrefix(src, "#!") {
+		_, src, _ = strings.Cut(src, "\n")
+	}
+
+	// skip blank lines and line comments
+	for {
+		src = strings.TrimLeft(src, " \t\r\n")
+		if !strings.HasPrefix(src, "--") || strings.HasPrefix(src, "--[") {
+			break
+		}
+		var found bool
+		if _, src, found = strings.Cut(src, "\n"); !found {
+			return nil, nil
+		}
+	}
+
+	// --[[meta or --[==[meta, closed by ]] or ]==] respectively
+	rest, ok := strings.CutPrefix(src, "--[")
+	if !ok {
+		return nil, nil
+	}
+	level := len(rest) - len(strings.TrimLeft(rest, "="))
+	rest, ok = strings.CutPrefix(rest[level:], "[meta")
+	if !ok || rest == "" || !strings.ContainsRune(" \t\r\n", rune(rest[0])) {
+		return nil, nil
+	}
+	end := strings.Index(rest, "]"+strings.Repeat("=", level)+"]")
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated metadata header in %s", path)
+	}
+	return []byte(rest[:end]), nil
+}
+
+// Method is a method found in the com directory together with its metadata
+type Method struct {
+	Name           string    `json:"name"`
+	ContextVersion string    `json:"context-version,omitempty"`
+	Metadata       *Metadata `json:"metadata,omitempty"`
+
+	// Path is the method file, it is never shown to clients
+	Path string `json:"-"`
+}
+
+// Public returns the method with its public metadata only
+func (m Method) Public() Method {
+	m.Metadata = m.Metadata.Public()
+	return m
+}
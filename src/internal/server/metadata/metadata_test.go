@@ -0,0 +1,130 @@
+package metadata
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad_LuaHeader(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "Echo.lua")
+	src := `-- com/Echo.lua
+
+--[==[meta
+{
+  "description": "Sends the params back",
+  "params": {"type": "object", "required": ["data"]},
+  "permissions": ["echo"]
+}
+]==]
+local s = require("internal.session")
+`
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := Load(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Description != "Sends the params back" {
+		t.Errorf("Description = %q", meta.Description)
+	}
+	if !reflect.DeepEqual(meta.Permissions, []string{"echo"}) {
+		t.Errorf("Permissions = %v", meta.Permissions)
+	}
+
+	// the sidecar wins over the header
+	sidecar := `{"description": "Echo"}`
+	if err := os.WriteFile(SidecarPath(script), []byte(sidecar), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err = Load(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Description != "Echo" {
+		t.Errorf("Description = %q, want the sidecar one", meta.Description)
+	}
+	if meta.Params == nil {
+		t.Error("Params from the header are lost")
+	}
+}
+
+func TestLoad_NoHeader(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "Plain.lua")
+	src := "-- just a comment\nlocal x = 1\n--[[meta\n{\"description\": \"too late\"}\n]]\n"
+	if err := os.WriteFile(script, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := Load(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Description != "" {
+		t.Errorf("Description = %q, the header must be at the top", meta.Description)
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	var schema Schema
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["name", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"name":  {"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+			"count": {"type": "integer", "minimum": 1},
+			"mode":  {"enum": ["fast", "slow"]},
+			"tags":  {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		}
+	}`), &schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		params string
+		want   []string
+	}{
+		{"valid", `{"name": "abc", "count": 3, "tags": ["a"]}`, nil},
+		{"missing", `{"name": "abc"}`, []string{"/count"}},
+		{"wrong type", `{"name": "abc", "count": 1.5}`, []string{"/count"}},
+		{"constraints", `{"name": "A", "count": 0}`, []string{"/count", "/name", "/name"}},
+		{"enum", `{"name": "abc", "count": 1, "mode": "medium"}`, []string{"/mode"}},
+		{"items", `{"name": "abc", "count": 1, "tags": ["a", 2, "c"]}`, []string{"/tags", "/tags/1"}},
+		{"additional", `{"name": "abc", "count": 1, "extra": true}`, []string{"/extra"}},
+		{"not an object", `[1, 2]`, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params any
+			if err := json.Unmarshal([]byte(tt.params), &params); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range schema.Validate(params) {
+				got = append(got, e.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("error paths = %q, want %q (%v)", got, tt.want, schema.Validate(params))
+			}
+		})
+	}
+}
+
+func TestMetadata_ValidateParams_Absent(t *testing.T) {
+	meta := &Metadata{Params: Schema{"type": "object", "required": []any{"data"}}}
+	if errs := meta.ValidateParams(nil); len(errs) != 1 || errs[0].Path != "/data" {
+		t.Errorf("errors = %v, want /data to be required", errs)
+	}
+	meta = &Metadata{Params: Schema{"type": "array"}}
+	if errs := meta.ValidateParams(nil); errs != nil {
+		t.Errorf("errors = %v, absent params are an empty array here", errs)
+	}
+}
@@ -0,0 +1,352 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Schema is a JSON schema kept as decoded JSON, so it is written back
+// unchanged wherever the method is described. Validate enforces the
+// keywords that matter for request params:
+//
+//	type, enum, const,
+//	properties, required, additionalProperties, minProperties, maxProperties,
+//	items, prefixItems, minItems, maxItems, uniqueItems,
+//	minLength, maxLength, pattern,
+//	minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf,
+//	allOf, anyOf, oneOf, not
+//
+// Everything else ($ref, format, description, ...) is documentation only.
+type Schema map[string]any
+
+// ValidationError is one mismatch between a value and its schema.
+// Path is a JSON pointer to the value, "" being the value itself.
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks the value against the schema and returns every mismatch found.
+// The value is expected to be decoded by encoding/json.
+func (s Schema) Validate(value any) []ValidationError {
+	var v validator
+	v.validate(s, "", value)
+	return v.errs
+}
+
+type validator struct {
+	errs []ValidationError
+}
+
+func (v *validator) fail(path, format string, args ...any) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s map[string]any, path string, value any) {
+	value = normalize(value)
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonType(value))
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsValue(enum, value) {
+		v.fail(path, "must be one of %s", compact(enum))
+	}
+	if c, ok := s["const"]; ok && !equal(c, value) {
+		v.fail(path, "must be %s", compact(c))
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		v.object(s, path, val)
+	case []any:
+		v.array(s, path, val)
+	case string:
+		v.string(s, path, val)
+	case float64:
+		v.number(s, path, val)
+	}
+
+	v.combinators(s, path, value)
+}
+
+func (v *validator) object(s map[string]any, path string, obj map[string]any) {
+	props, _ := asSchema(s["properties"])
+	if required, ok := s["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				v.fail(pointer(path, name), "is required")
+			}
+		}
+	}
+	if n, ok := number(s["minProperties"]); ok && float64(len(obj)) < n {
+		v.fail(path, "must have at least %v properties", n)
+	}
+	if n, ok := number(s["maxProperties"]); ok && float64(len(obj)) > n {
+		v.fail(path, "must have at most %v properties", n)
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := pointer(path, k)
+		if sub, ok := asSchema(props[k]); ok {
+			v.validate(sub, p, obj[k])
+			continue
+		}
+		if _, ok := props[k]; ok {
+			continue
+		}
+		v.additional(s["additionalProperties"], p, obj[k])
+	}
+}
+
+func (v *validator) array(s map[string]any, path string, arr []any) {
+	if n, ok := number(s["minItems"]); ok && float64(len(arr)) < n {
+		v.fail(path, "must have at least %v items", n)
+	}
+	if n, ok := number(s["maxItems"]); ok && float64(len(arr)) > n {
+		v.fail(path, "must have at most %v items", n)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if equal(arr[i], arr[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+
+	// prefixItems describes positional params, items the rest of them.
+	// The older tuple form, items given as an array, is accepted as well.
+	prefix, _ := s["prefixItems"].([]any)
+	rest := s["items"]
+	if tuple, ok := rest.([]any); ok {
+		prefix, rest = tuple, s["additionalItems"]
+	}
+	for i, item := range arr {
+		p := pointer(path, strconv.Itoa(i))
+		if i < len(prefix) {
+			if sub, ok := asSchema(prefix[i]); ok {
+				v.validate(sub, p, item)
+			}
+			continue
+		}
+		v.additional(rest, p, item)
+	}
+}
+
+// additional checks a value not covered by properties or prefixItems
+// against additionalProperties, items or additionalItems
+func (v *validator) additional(s any, path string, value any) {
+	if sub, ok := asSchema(s); ok {
+		v.validate(sub, path, value)
+	} else if allowed, ok := s.(bool); ok && !allowed {
+		v.fail(path, "is not allowed")
+	}
+}
+
+func (v *validator) string(s map[string]any, path string, str string) {
+	length := float64(utf8.RuneCountInString(str))
+	if n, ok := number(s["minLength"]); ok && length < n {
+		v.fail(path, "must be at least %v characters long", n)
+	}
+	if n, ok := number(s["maxLength"]); ok && length > n {
+		v.fail(path, "must be at most %v characters long", n)
+	}
+	if pattern, ok := s["pattern"].(string); ok {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			v.fail(path, "schema pattern %q is invalid: %v", pattern, err)
+		} else if !re.MatchString(str) {
+			v.fail(path, "must match %q", pattern)
+		}
+	}
+}
+
+func (v *validator) number(s map[string]any, path string, n float64) {
+	if m, ok := number(s["minimum"]); ok && n < m {
+		v.fail(path, "must be >= %v", m)
+	}
+	if m, ok := number(s["maximum"]); ok && n > m {
+		v.fail(path, "must be <= %v", m)
+	}
+	if m, ok := number(s["exclusiveMinimum"]); ok && n <= m {
+		v.fail(path, "must be > %v", m)
+	}
+	if m, ok := number(s["exclusiveMaximum"]); ok && n >= m {
+		v.fail(path, "must be < %v", m)
+	}
+	if m, ok := number(s["multipleOf"]); ok && m > 0 {
+		if q := n / m; q != math.Trunc(q) {
+			v.fail(path, "must be a multiple of %v", m)
+		}
+	}
+}
+
+func (v *validator) combinators(s map[string]any, path string, value any) {
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			if sub, ok := asSchema(sub); ok {
+				v.validate(sub, path, value)
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok && countMatches(anyOf, value) == 0 {
+		v.fail(path, "must match at least one schema of anyOf")
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		if n := countMatches(oneOf, value); n != 1 {
+			v.fail(path, "must match exactly one schema of oneOf, matches %d", n)
+		}
+	}
+	if not, ok := asSchema(s["not"]); ok && Schema(not).Validate(value) == nil {
+		v.fail(path, "must not match the schema of not")
+	}
+}
+
+func countMatches(schemas []any, value any) int {
+	var n int
+	for _, sub := range schemas {
+		if sub, ok := asSchema(sub); ok && Schema(sub).Validate(value) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// asSchema accepts both decoded JSON objects and Schema values built in Go
+func asSchema(v any) (map[string]any, bool) {
+	switch s := v.(type) {
+	case map[string]any:
+		return s, true
+	case Schema:
+		return s, true
+	}
+	return nil, false
+}
+
+// normalize brings numbers decoded with UseNumber to float64
+func normalize(value any) any {
+	if n, ok := value.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return value
+}
+
+func number(v any) (float64, bool) {
+	f, ok := normalize(v).(float64)
+	return f, ok
+}
+
+func jsonType(value any) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func matchesType(t any, value any) bool {
+	actual := jsonType(value)
+	match := func(name any) bool {
+		return name == actual || (name == "number" && actual == "integer")
+	}
+	if list, ok := t.([]any); ok {
+		for _, name := range list {
+			if match(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(t)
+}
+
+func typeNames(t any) string {
+	if list, ok := t.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, name := range list {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func containsValue(list []any, value any) bool {
+	for _, item := range list {
+		if equal(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b any) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func compact(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// pointer appends a token to a JSON pointer, escaping it as RFC 6901 says
+func pointer(path, token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	token = strings.ReplaceAll(token, "/", "~1")
+	return path + "/" + token
+}
+
+var patterns sync.Map // string -> *regexp.Regexp
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,203 @@
+// Package openrpc builds OpenRPC documents (https://spec.open-rpc.org)
+// out of the methods found in the com directory.
+//
+// OpenRPC knows nothing about context versions, so every method carries
+// the version it must be called with in the "x-context-version" extension
+// and in a tag of the same name. A name served by several context versions
+// is described once per version as "Name@version", and "x-method" gives
+// the name to call.
+package openrpc
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+)
+
+// SpecVersion is the OpenRPC version of the generated documents
+var SpecVersion = "1.3.2"
+
+type Document struct {
+	OpenRPC string   `json:"openrpc"`
+	Info    Info     `json:"info"`
+	Servers []Server `json:"servers,omitempty"`
+	Methods []Method `json:"methods"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type Method struct {
+	Name           string              `json:"name"`
+	Description    string              `json:"description,omitempty"`
+	Tags           []Tag               `json:"tags,omitempty"`
+	ParamStructure string              `json:"paramStructure,omitempty"`
+	Params         []ContentDescriptor `json:"params"`
+	Result         *ContentDescriptor  `json:"result,omitempty"`
+
+	ContextVersion string `json:"x-context-version,omitempty"`
+	// RPCMethod is the name to call when Name carries the context version
+	RPCMethod string `json:"x-method,omitempty"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type ContentDescriptor struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Required    bool            `json:"required,omitempty"`
+	Schema      metadata.Schema `json:"schema"`
+}
+
+// Init structure is only for initialization
+type Init struct {
+	Title   string
+	Version string
+	// ServerURL is where the methods are called, omitted from the document if empty
+	ServerURL string
+}
+
+// Build returns the document describing the methods. OpenRPC method names
+// must be unique: when a name is served by several context versions,
+// every one of them gets the version appended to its name.
+func Build(o *Init, methods []metadata.Method) *Document {
+	doc := &Document{
+		OpenRPC: SpecVersion,
+		Info: Info{
+			Title:       o.Title,
+			Version:     o.Version,
+			Description: `Requests must carry the "context-version" member given by "x-context-version" of the method, and call "x-method" instead of the name when it is set.`,
+		},
+		Methods: []Method{},
+	}
+	if o.ServerURL != "" {
+		doc.Servers = []Server{{Name: "node", URL: o.ServerURL}}
+	}
+
+	versions := make(map[string]int)
+	for _, m := range methods {
+		versions[m.Name]++
+	}
+	for _, m := range methods {
+		out := method(m)
+		if versions[m.Name] > 1 {
+			out.Name = m.Name + "@" + m.ContextVersion
+			out.RPCMethod = m.Name
+		}
+		doc.Methods = append(doc.Methods, out)
+	}
+	return doc
+}
+
+func method(m metadata.Method) Method {
+	meta := m.Metadata
+	if meta == nil {
+		meta = &metadata.Metadata{}
+	}
+	out := Method{
+		Name:           m.Name,
+		Description:    meta.Description,
+		ContextVersion: m.ContextVersion,
+		Params:         []ContentDescriptor{},
+		Result:         &ContentDescriptor{Name: "result", Schema: meta.Result},
+	}
+	if m.ContextVersion != "" {
+		out.Tags = []Tag{{Name: m.ContextVersion}}
+	}
+	if out.Result.Schema == nil {
+		out.Result.Schema = metadata.Schema{}
+	}
+
+	switch meta.Params["type"] {
+	case "object":
+		out.ParamStructure = "by-name"
+		out.Params = byName(meta.Params)
+	case "array":
+		out.ParamStructure = "by-position"
+		out.Params = byPosition(meta.Params)
+	}
+	return out
+}
+
+// byName turns the properties of an object schema into params
+func byName(s metadata.Schema) []ContentDescriptor {
+	props := schemaMap(s["properties"])
+	required := make(map[string]bool)
+	if list, ok := s["required"].([]any); ok {
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				required[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	params := []ContentDescriptor{}
+	for _, name := range names {
+		schema := schemaMap(props[name])
+		params = append(params, ContentDescriptor{
+			Name:        name,
+			Description: description(schema),
+			Required:    required[name],
+			Schema:      schema,
+		})
+	}
+	return params
+}
+
+// byPosition turns prefixItems, or the older tuple form of items, into params.
+// Positional params are named by their "title", "paramN" if there is none.
+func byPosition(s metadata.Schema) []ContentDescriptor {
+	items, _ := s["prefixItems"].([]any)
+	if tuple, ok := s["items"].([]any); ok {
+		items = tuple
+	}
+	minItems, _ := s["minItems"].(float64)
+
+	params := []ContentDescriptor{}
+	for i, item := range items {
+		schema := schemaMap(item)
+		name, _ := schema["title"].(string)
+		if name == "" {
+			name = fmt.Sprintf("param%d", i+1)
+		}
+		params = append(params, ContentDescriptor{
+			Name:        name,
+			Description: description(schema),
+			Required:    float64(i) < minItems,
+			Schema:      schema,
+		})
+	}
+	return params
+}
+
+func schemaMap(v any) metadata.Schema {
+	switch s := v.(type) {
+	case map[string]any:
+		return s
+	case metadata.Schema:
+		return s
+	}
+	return metadata.Schema{}
+}
+
+func description(s metadata.Schema) string {
+	d, _ := s["description"].(string)
+	return d
+}
@@ -0,0 +1,76 @@
+package openrpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+)
+
+func schema(tb testing.TB, src string) metadata.Schema {
+	tb.Helper()
+	var s metadata.Schema
+	if err := json.Unmarshal([]byte(src), &s); err != nil {
+		tb.Fatal(err)
+	}
+	return s
+}
+
+func TestBuild(t *testing.T) {
+	methods := []metadata.Method{
+		{Name: "Echo", ContextVersion: "v1", Metadata: &metadata.Metadata{
+			Description: "Sends the params back",
+			Params: schema(t, `{"type": "object", "required": ["data"], "properties": {
+				"data": {"type": "string", "description": "what to send"},
+				"count": {"type": "integer"}
+			}}`),
+			Result: schema(t, `{"type": "object"}`),
+		}},
+		{Name: "Echo", ContextVersion: "v2"},
+		{Name: "Add", ContextVersion: "v2", Metadata: &metadata.Metadata{
+			Params: schema(t, `{"type": "array", "minItems": 1, "prefixItems": [
+				{"type": "number", "title": "a"},
+				{"type": "number"}
+			]}`),
+		}},
+	}
+	doc := Build(&Init{Title: "test", Version: "v1.0.0", ServerURL: "http://localhost/com"}, methods)
+
+	if doc.OpenRPC != SpecVersion || len(doc.Servers) != 1 {
+		t.Errorf("header = %q %v", doc.OpenRPC, doc.Servers)
+	}
+	if len(doc.Methods) != 3 {
+		t.Fatalf("got %d methods, want every version of Echo and Add", len(doc.Methods))
+	}
+
+	echo, echo2 := doc.Methods[0], doc.Methods[1]
+	if echo.Name != "Echo@v1" || echo.RPCMethod != "Echo" || echo2.Name != "Echo@v2" || echo2.RPCMethod != "Echo" || echo2.ContextVersion != "v2" {
+		t.Errorf("names of Echo = %q (%q), %q (%q)", echo.Name, echo.RPCMethod, echo2.Name, echo2.RPCMethod)
+	}
+	if echo.ContextVersion != "v1" || echo.ParamStructure != "by-name" || echo.Description != "Sends the params back" {
+		t.Errorf("Echo = %+v", echo)
+	}
+	if len(echo.Params) != 2 || echo.Params[0].Name != "count" || echo.Params[1].Name != "data" {
+		t.Fatalf("Echo params = %+v", echo.Params)
+	}
+	if echo.Params[0].Required || !echo.Params[1].Required || echo.Params[1].Description != "what to send" {
+		t.Errorf("Echo params = %+v", echo.Params)
+	}
+	if echo.Result.Schema["type"] != "object" {
+		t.Errorf("Echo result = %+v", echo.Result)
+	}
+
+	add := doc.Methods[2]
+	if add.Name != "Add" || add.RPCMethod != "" {
+		t.Errorf("a name served by one version was changed to %q (%q)", add.Name, add.RPCMethod)
+	}
+	if add.ParamStructure != "by-position" || len(add.Params) != 2 {
+		t.Fatalf("Add = %+v", add)
+	}
+	if add.Params[0].Name != "a" || !add.Params[0].Required || add.Params[1].Name != "param2" || add.Params[1].Required {
+		t.Errorf("Add params = %+v", add.Params)
+	}
+	if add.Result == nil || add.Result.Schema == nil {
+		t.Errorf("Add must have a result even without a schema")
+	}
+}
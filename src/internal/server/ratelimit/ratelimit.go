@@ -0,0 +1,205 @@
+// Package ratelimit limits how often clients may call methods of the node.
+// Every rule keeps a token bucket per client: a call takes a token,
+// tokens come back at Rate per second up to Burst.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// Keys a rule can count calls by
+const (
+	// KeyIP counts by the address of the client, see clientip
+	KeyIP = "ip"
+	// KeySession counts by X-Session-UUID of an authenticated principal,
+	// the header alone is chosen by the client and falls back to the address
+	KeySession = "session"
+	// KeyPrincipal counts by the authenticated principal, or the address
+	// of the client when the request carries no verified credentials
+	KeyPrincipal = "principal"
+)
+
+// Rule limits the methods matching Methods, a path.Match pattern like "Unit.*"
+type Rule struct {
+	Methods string
+	Key     string
+	// Rate is the number of calls per second
+	Rate float64
+	// Burst is the number of calls a client may make at once
+	Burst int
+}
+
+// Limiter checks calls against the rules, the first matching rule applies.
+// Methods that match no rule are not limited.
+type Limiter struct {
+	rules   []Rule
+	proxies clientip.Proxies
+	now     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	rule   int
+	client string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Init structure is only for initialization
+type Init struct {
+	Rules          []Rule
+	TrustedProxies clientip.Proxies
+}
+
+// New checks the rules and returns the limiter
+func New(o *Init) (*Limiter, error) {
+	for i, rule := range o.Rules {
+		if _, err := path.Match(rule.Methods, ""); err != nil {
+			return nil, fmt.Errorf("rate limit rule %d: invalid pattern %q", i+1, rule.Methods)
+		}
+		switch rule.Key {
+		case KeyIP, KeySession, KeyPrincipal:
+		default:
+			return nil, fmt.Errorf("rate limit rule %d: unknown key %q", i+1, rule.Key)
+		}
+		if rule.Rate <= 0 || rule.Burst <= 0 {
+			return nil, fmt.Errorf("rate limit rule %d: rate and burst must be positive", i+1)
+		}
+	}
+	return &Limiter{
+		rules:   o.Rules,
+		proxies: o.TrustedProxies,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*bucket),
+	}, nil
+}
+
+// Check takes the tokens of the methods of one request or batch, the calls
+// of a batch are taken all together or not at all. It returns nil, or an
+// ErrRateLimited response carrying the seconds to wait in retry-after.
+func (l *Limiter) Check(ctx context.Context, r *http.Request, methods []string) *rpc.RPCResponse {
+	if len(l.rules) == 0 {
+		return nil
+	}
+	wait := l.Take(ctx, r, methods)
+	if wait <= 0 {
+		return nil
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	return rpc.NewError(rpc.ErrRateLimited, rpc.ErrRateLimitedS, map[string]any{"retry-after": seconds}, nil)
+}
+
+// Take takes a token for every method of the request.
+// If any bucket is short, nothing is taken and the time to wait is returned.
+func (l *Limiter) Take(ctx context.Context, r *http.Request, methods []string) time.Duration {
+	need := make(map[bucketKey]float64)
+	for _, method := range methods {
+		i, ok := l.match(method)
+		if !ok {
+			continue
+		}
+		need[bucketKey{rule: i, client: l.client(ctx, r, l.rules[i].Key)}]++
+	}
+	if len(need) == 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	for key, n := range need {
+		rule := l.rules[key.rule]
+		b := l.refill(key, now)
+		if b.tokens >= n {
+			continue
+		}
+		if n > float64(rule.Burst) {
+			// the batch alone is larger than the bucket, it will never fit
+			return time.Duration(float64(rule.Burst) / rule.Rate * float64(time.Second))
+		}
+		wait = max(wait, time.Duration((n-b.tokens)/rule.Rate*float64(time.Second)))
+	}
+	if wait > 0 {
+		return wait
+	}
+	for key, n := range need {
+		l.buckets[key].tokens -= n
+	}
+	return 0
+}
+
+func (l *Limiter) match(method string) (int, bool) {
+	for i, rule := range l.rules {
+		if ok, _ := path.Match(rule.Methods, method); ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// client returns the key of the client. Only a verified principal tells
+// clients apart, anything else a client sends could be new on every call,
+// so those requests fall back to the address.
+func (l *Limiter) client(ctx context.Context, r *http.Request, key string) string {
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		switch key {
+		case KeySession:
+			if sid := r.Header.Get("X-Session-UUID"); sid != "" {
+				return "session:" + p.ID + "/" + sid
+			}
+			return "principal:" + p.ID
+		case KeyPrincipal:
+			return "principal:" + p.ID
+		}
+	}
+	if addr := l.proxies.Of(r); addr.IsValid() {
+		return "ip:" + addr.String()
+	}
+	return "addr:" + r.RemoteAddr
+}
+
+func (l *Limiter) refill(key bucketKey, now time.Time) *bucket {
+	rule := l.rules[key.rule]
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		l.buckets[key] = b
+		return b
+	}
+	b.tokens = min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+	b.last = now
+	return b
+}
+
+// sweep drops the buckets that are full again, once a minute
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		rule := l.rules[key.rule]
+		if b.tokens+now.Sub(b.last).Seconds()*rule.Rate >= float64(rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
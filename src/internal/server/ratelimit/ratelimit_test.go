@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/clientip"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(tb testing.TB, rules ...Rule) (*Limiter, *clock) {
+	tb.Helper()
+	proxies, err := clientip.ParseProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	l, err := New(&Init{Rules: rules, TrustedProxies: proxies})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c := &clock{t: time.Unix(1700000000, 0)}
+	l.now = c.now
+	return l, c
+}
+
+func request(body string, header ...string) *http.Request {
+	r := httptest.NewRequest("POST", "/com", strings.NewReader(body))
+	r.RemoteAddr = "192.0.2.1:4000"
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return r
+}
+
+func TestCheck(t *testing.T) {
+	l, c := newTestLimiter(t, Rule{Methods: "Unit.*", Key: KeyIP, Rate: 1, Burst: 2})
+	check := func(r *http.Request, methods ...string) *rpc.RPCResponse {
+		return l.Check(r.Context(), r, methods)
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := check(request(""), "Unit.Create"); resp != nil {
+			t.Fatalf("call %d = %+v", i+1, resp.Error)
+		}
+	}
+	resp := check(request(""), "Unit.Create")
+	if resp == nil {
+		t.Fatal("third call was not limited")
+	}
+	e := resp.Error.(map[string]any)
+	if e["code"] != rpc.ErrRateLimited || e["data"].(map[string]any)["retry-after"] != 1 {
+		t.Errorf("error = %+v", e)
+	}
+
+	// other methods and other clients are not affected
+	if resp := check(request(""), "Echo"); resp != nil {
+		t.Errorf("unlimited method = %+v", resp.Error)
+	}
+	other := request("")
+	other.RemoteAddr = "192.0.2.2:4000"
+	if resp := check(other, "Unit.Create"); resp != nil {
+		t.Errorf("other client = %+v", resp.Error)
+	}
+
+	c.advance(time.Second)
+	if resp := check(request(""), "Unit.Create"); resp != nil {
+		t.Errorf("after a second = %+v", resp.Error)
+	}
+
+	// a batch is taken as a whole
+	c.advance(2 * time.Second)
+	if resp := check(request(""), "Unit.Create", "Unit.Delete", "Unit.Get"); resp == nil {
+		t.Errorf("batch over the burst was not limited")
+	}
+	if resp := check(request(""), "Unit.Create", "Unit.Get"); resp != nil {
+		t.Errorf("batch within the burst = %+v, tokens were taken by the rejected batch", resp.Error)
+	}
+}
+
+func TestClientKeys(t *testing.T) {
+	l, _ := newTestLimiter(t)
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{ID: "alice"})
+	tests := []struct {
+		name string
+		ctx  context.Context
+		r    *http.Request
+		key  string
+		want string
+	}{
+		{"ip", context.Background(), request(""), KeyIP, "ip:192.0.2.1"},
+		{"untrusted proxy", context.Background(), request("", "X-Forwarded-For", "198.51.100.7"), KeyIP, "ip:192.0.2.1"},
+		{"session", alice, request("", "X-Session-UUID", "abc"), KeySession, "session:alice/abc"},
+		{"session without a principal", context.Background(), request("", "X-Session-UUID", "abc"), KeySession, "ip:192.0.2.1"},
+		{"no session", alice, request(""), KeySession, "principal:alice"},
+		{"principal", alice, request(""), KeyPrincipal, "principal:alice"},
+		{"no credentials", context.Background(), request(""), KeyPrincipal, "ip:192.0.2.1"},
+		{"unverified credentials", context.Background(), request("", "Authorization", "Bearer a"), KeyPrincipal, "ip:192.0.2.1"},
+		{"unverified API key", context.Background(), request("", "X-API-Key", "b"), KeyPrincipal, "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		if got := l.client(tt.ctx, tt.r, tt.key); got != tt.want {
+			t.Errorf("%s: client = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNew_InvalidRules(t *testing.T) {
+	for _, rule := range []Rule{
+		{Methods: "[", Key: KeyIP, Rate: 1, Burst: 1},
+		{Methods: "*", Key: "cookie", Rate: 1, Burst: 1},
+		{Methods: "*", Key: KeyIP, Rate: 0, Burst: 1},
+		{Methods: "*", Key: KeyIP, Rate: 1, Burst: 0},
+	} {
+		if _, err := New(&Init{Rules: []Rule{rule}}); err == nil {
+			t.Errorf("New(%+v) succeeded", rule)
+		}
+	}
+}
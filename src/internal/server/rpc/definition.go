@@ -1,7 +1,15 @@
 package rpc
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
 
+// RPCRequest is a request or a notification. ID is nil for a notification,
+// a null id sent by the client is kept as "null".
 type RPCRequest struct {
 	JSONRPC        string           `json:"jsonrpc"`
 	ID             *json.RawMessage `json:"id,omitempty"`
@@ -28,3 +36,60 @@ type RPCData struct {
 const (
 	JSONRPCVersion = "2.0"
 )
+
+// ParseRequest decodes a single request object and checks that it is one.
+// The returned request carries the id whenever it could be read,
+// so the Invalid Request error can be sent with it.
+func ParseRequest(data []byte) (*RPCRequest, error) {
+	req := &RPCRequest{}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return req, errors.New("request is not an object")
+	}
+
+	// only a request without an id is a notification, "id": null is answered
+	if raw, ok := fields["id"]; ok {
+		if string(raw) != "null" && raw[0] != '"' && raw[0] != '-' && (raw[0] < '0' || raw[0] > '9') {
+			return req, errors.New("id must be a string, a number or null")
+		}
+		id := raw
+		req.ID = &id
+	}
+	if err := json.Unmarshal(fields["jsonrpc"], &req.JSONRPC); err != nil || req.JSONRPC != JSONRPCVersion {
+		return req, fmt.Errorf("jsonrpc must be %q", JSONRPCVersion)
+	}
+	if err := json.Unmarshal(fields["method"], &req.Method); err != nil || req.Method == "" {
+		return req, errors.New("method must be a non-empty string")
+	}
+	if raw, ok := fields["params"]; ok && string(raw) != "null" {
+		if raw[0] != '{' && raw[0] != '[' {
+			return req, errors.New("params must be an object or an array")
+		}
+		params, err := DecodeJSON(raw)
+		if err != nil {
+			return req, err
+		}
+		req.Params = params
+	}
+	if raw, ok := fields["context-version"]; ok {
+		if err := json.Unmarshal(raw, &req.ContextVersion); err != nil {
+			return req, errors.New("context-version must be a string")
+		}
+	}
+	return req, nil
+}
+
+// DecodeJSON decodes a document keeping its numbers as json.Number,
+// so integers above 2^53 are not rounded to a float64
+func DecodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after the top-level value")
+	}
+	return v, nil
+}
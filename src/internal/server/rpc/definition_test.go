@@ -0,0 +1,29 @@
+package rpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	v, err := DecodeJSON([]byte(` {"id": 12345678901234567890} `))
+	if err != nil || v.(map[string]any)["id"] != json.Number("12345678901234567890") {
+		t.Errorf("%#v, %v", v, err)
+	}
+	for _, doc := range []string{`{} {}`, `{}}`, `[1,`, ``} {
+		if _, err := DecodeJSON([]byte(doc)); err == nil {
+			t.Errorf("%q was decoded", doc)
+		}
+	}
+}
+
+func TestParseRequest_Params(t *testing.T) {
+	req, err := ParseRequest([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Echo", "params": {"n": 9007199254740993, "list": [1.5]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := req.Params.(map[string]any)
+	if params["n"] != json.Number("9007199254740993") || params["list"].([]any)[0] != json.Number("1.5") {
+		t.Errorf("params = %#v", params)
+	}
+}
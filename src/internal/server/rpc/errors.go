@@ -27,4 +27,37 @@ const (
 
 	ErrSessionIsBusy  = -32030
 	ErrSessionIsBusyS = "The session is busy"
+
+	ErrTimeout  = -32040
+	ErrTimeoutS = "Method execution timed out"
+
+	ErrInstructionLimit  = -32041
+	ErrInstructionLimitS = "Method exceeded its instruction limit"
+
+	ErrMemoryLimit  = -32042
+	ErrMemoryLimitS = "Method exceeded its memory limit"
+
+	ErrUnauthorized  = -32050
+	ErrUnauthorizedS = "Unauthorized"
+
+	ErrPermissionDenied  = -32051
+	ErrPermissionDeniedS = "Permission denied"
+
+	ErrAccessDenied  = -32052
+	ErrAccessDeniedS = "Access denied by policy"
+
+	ErrRequestTooLarge  = -32060
+	ErrRequestTooLargeS = "Request is too large"
+
+	ErrBatchTooLarge  = -32061
+	ErrBatchTooLargeS = "Batch contains too many requests"
+
+	ErrRequestTooDeep  = -32062
+	ErrRequestTooDeepS = "Request is nested too deeply"
+
+	ErrNodeIsBusy  = -32063
+	ErrNodeIsBusyS = "The node is busy"
+
+	ErrRateLimited  = -32064
+	ErrRateLimitedS = "Rate limit exceeded"
 )
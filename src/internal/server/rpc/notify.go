@@ -0,0 +1,54 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// RPCNotification is a request without id, sent by the node to the client
+type RPCNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+func NewNotification(method string, params any) *RPCNotification {
+	return &RPCNotification{
+		JSONRPC: JSONRPCVersion,
+		Method:  method,
+		Params:  params,
+	}
+}
+
+// StreamMethod is the method of the notifications a method sends while it runs
+var StreamMethod = "session.stream"
+
+// StreamParams are the params of a StreamMethod notification.
+// ID and Method name the request that sent the item,
+// Seq counts the items of that request starting from 1.
+type StreamParams struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Seq    int              `json:"seq"`
+	Item   any              `json:"item"`
+}
+
+// Notifier sends notifications to the client of a request
+// over a transport that stays open, like a WebSocket
+type Notifier interface {
+	Notify(n *RPCNotification) error
+}
+
+type notifierKey struct{}
+
+// WithNotifier returns a context carrying the notifier of the client connection
+func WithNotifier(ctx context.Context, n Notifier) context.Context {
+	return context.WithValue(ctx, notifierKey{}, n)
+}
+
+// NotifierFrom returns the notifier of the client connection.
+// It is not there when the transport cannot push, e.g. a plain HTTP POST.
+func NotifierFrom(ctx context.Context) (Notifier, bool) {
+	n, ok := ctx.Value(notifierKey{}).(Notifier)
+	return n, ok
+}
@@ -0,0 +1,411 @@
+package sv1
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/crypto/argon2"
+)
+
+// Binary results are strings of raw bytes unless a script names an encoding,
+// internal.encoding turns them into text. Digests default to hex like sha256.hash.
+
+// internal.crypt.hmac
+var hmacFuncs = map[string]lua.LGFunction{
+	// sha256(key, data, encoding) returns the MAC in hex by default
+	"sha256": hmacSign(sha256.New),
+	"sha512": hmacSign(sha512.New),
+	// verify(algorithm, key, data, mac, encoding) compares in constant time
+	"verify": func(L *lua.LState) int {
+		h, ok := hmacHashes[L.CheckString(1)]
+		if !ok {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString("unknown algorithm, use sha256 or sha512"))
+			return 2
+		}
+		key, data := L.CheckString(2), L.CheckString(3)
+		expected, err := decodeBytes(L.CheckString(4), L.OptString(5, encodingHex))
+		if err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LBool(hmac.Equal(hmacSum(h, key, data), expected)))
+		return 1
+	},
+}
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func hmacSum(h func() hash.Hash, key, data string) []byte {
+	mac := hmac.New(h, []byte(key))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hmacSign(h func() hash.Hash) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key, data := L.CheckString(1), L.CheckString(2)
+		out, err := encodeBytes(hmacSum(h, key, data), L.OptString(3, encodingHex))
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LString(out))
+		return 1
+	}
+}
+
+// maxRandomBytes caps one call of internal.crypt.random
+const maxRandomBytes = 1 << 16
+
+func randomBytes(n int) ([]byte, error) {
+	if n < 1 || n > maxRandomBytes {
+		return nil, fmt.Errorf("the number of bytes must be between 1 and %d", maxRandomBytes)
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// randomEncoded returns a function giving n random bytes in the encoding
+func randomEncoded(defaultN int, encoding string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		b, err := randomBytes(L.OptInt(1, defaultN))
+		if err != nil {
+			return pushError(L, err)
+		}
+		out, _ := encodeBytes(b, encoding)
+		L.Push(lua.LString(out))
+		return 1
+	}
+}
+
+// internal.crypt.random, every value comes from crypto/rand
+var randomFuncs = map[string]lua.LGFunction{
+	// bytes(n, encoding) returns n raw bytes by default
+	"bytes": func(L *lua.LState) int {
+		b, err := randomBytes(L.CheckInt(1))
+		if err != nil {
+			return pushError(L, err)
+		}
+		out, err := encodeBytes(b, L.OptString(2, encodingRaw))
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LString(out))
+		return 1
+	},
+	// hex(n) returns n bytes, 16 by default, as hex
+	"hex": randomEncoded(16, encodingHex),
+	// token(n) returns n bytes, 32 by default, as unpadded base64url,
+	// fit for reset links and API keys
+	"token": randomEncoded(32, encodingBase64URL),
+	// int(min, max) returns an integer between min and max inclusive
+	"int": func(L *lua.LState) int {
+		lo, hi := L.CheckInt64(1), L.CheckInt64(2)
+		if lo > hi || lo < -maxSafeInteger || hi > maxSafeInteger {
+			return pushError(L, errors.New("min must not exceed max, and both must be within ±2^53"))
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(hi-lo+1))
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LNumber(n.Int64() + lo))
+		return 1
+	},
+}
+
+// aesOptions reads {aad = "...", encoding = "base64"}
+func aesOptions(L *lua.LState, n int) (aad []byte, encoding string) {
+	opts := L.OptTable(n, L.NewTable())
+	if s, ok := opts.RawGetString("aad").(lua.LString); ok {
+		aad = []byte(s)
+	}
+	encoding = encodingRaw
+	if s, ok := opts.RawGetString("encoding").(lua.LString); ok {
+		encoding = string(s)
+	}
+	return aad, encoding
+}
+
+func newGCM(key string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		return nil, errors.New("the key must be 16, 24 or 32 bytes")
+	}
+	return cipher.NewGCM(block)
+}
+
+// internal.crypt.aes, AES-GCM with a random nonce put ahead of the ciphertext
+var aesFuncs = map[string]lua.LGFunction{
+	// encrypt(key, plaintext, opts) returns the nonce and the sealed plaintext
+	"encrypt": func(L *lua.LState) int {
+		key, plaintext := L.CheckString(1), L.CheckString(2)
+		aad, encoding := aesOptions(L, 3)
+		gcm, err := newGCM(key)
+		if err != nil {
+			return pushError(L, err)
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return pushError(L, err)
+		}
+		out, err := encodeBytes(gcm.Seal(nonce, nonce, []byte(plaintext), aad), encoding)
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LString(out))
+		return 1
+	},
+	// decrypt(key, ciphertext, opts) takes the options encrypt was given
+	"decrypt": func(L *lua.LState) int {
+		key := L.CheckString(1)
+		aad, encoding := aesOptions(L, 3)
+		data, err := decodeBytes(L.CheckString(2), encoding)
+		if err != nil {
+			return pushError(L, err)
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return pushError(L, err)
+		}
+		if len(data) < gcm.NonceSize()+gcm.Overhead() {
+			return pushError(L, errors.New("the ciphertext is too short"))
+		}
+		plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+		if err != nil {
+			return pushError(L, errors.New("the ciphertext or its additional data was altered, or the key is wrong"))
+		}
+		L.Push(lua.LString(plaintext))
+		return 1
+	},
+}
+
+// ed25519Private accepts the 32 byte seed or the 64 byte private key
+func ed25519Private(b []byte) (ed25519.PrivateKey, error) {
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("the private key must be %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// internal.crypt.ed25519, keys and signatures are raw unless an encoding is given
+var ed25519Funcs = map[string]lua.LGFunction{
+	// generate(encoding) returns the public key and the private seed
+	"generate": func(L *lua.LState) int {
+		encoding := L.OptString(1, encodingRaw)
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return pushError(L, err)
+		}
+		pub, err := encodeBytes(public, encoding)
+		if err != nil {
+			return pushError(L, err)
+		}
+		seed, _ := encodeBytes(private.Seed(), encoding)
+		L.Push(lua.LString(pub))
+		L.Push(lua.LString(seed))
+		return 2
+	},
+	// sign(private, message, encoding) returns the signature
+	"sign": func(L *lua.LState) int {
+		message, encoding := L.CheckString(2), L.OptString(3, encodingRaw)
+		raw, err := decodeBytes(L.CheckString(1), encoding)
+		if err != nil {
+			return pushError(L, err)
+		}
+		private, err := ed25519Private(raw)
+		if err != nil {
+			return pushError(L, err)
+		}
+		signature, _ := encodeBytes(ed25519.Sign(private, []byte(message)), encoding)
+		L.Push(lua.LString(signature))
+		return 1
+	},
+	// verify(public, message, signature, encoding) reports whether the signature is valid
+	"verify": func(L *lua.LState) int {
+		message, encoding := L.CheckString(2), L.OptString(4, encodingRaw)
+		public, err := decodeBytes(L.CheckString(1), encoding)
+		if err == nil && len(public) != ed25519.PublicKeySize {
+			err = fmt.Errorf("the public key must be %d bytes", ed25519.PublicKeySize)
+		}
+		var signature []byte
+		if err == nil {
+			signature, err = decodeBytes(L.CheckString(3), encoding)
+		}
+		if err != nil {
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LBool(ed25519.Verify(public, []byte(message), signature)))
+		return 1
+	},
+}
+
+// Defaults of lua.argon2
+var (
+	// Argon2MaxMemory is the most memory in KiB one argon2 call may use
+	Argon2MaxMemory = 64 * 1024
+	// Argon2MaxConcurrent is the number of argon2 calls running at the same time
+	Argon2MaxConcurrent = 2
+)
+
+// argon2Params are the costs of argon2id, memory is in KiB
+type argon2Params struct {
+	time, memory, threads, keyLen, saltLen int
+}
+
+// argon2Default follows the recommendation of OWASP, 19 MiB and two passes
+var argon2Default = argon2Params{time: 2, memory: 19 * 1024, threads: 1, keyLen: 32, saltLen: 16}
+
+// check keeps a script, or a stored hash, from asking for absurd costs
+func (p argon2Params) check(maxMemory int) error {
+	switch {
+	case p.time < 1 || p.time > 16:
+		return errors.New("time must be between 1 and 16")
+	case p.threads < 1 || p.threads > 255:
+		return errors.New("threads must be between 1 and 255")
+	case p.memory < 8*p.threads || p.memory > maxMemory:
+		return fmt.Errorf("memory must be between 8 KiB per thread and %d KiB", maxMemory)
+	case p.keyLen < 16 || p.keyLen > 64:
+		return errors.New("key_len must be between 16 and 64")
+	case p.saltLen < 8 || p.saltLen > 64:
+		return errors.New("salt_len must be between 8 and 64")
+	}
+	return nil
+}
+
+// parseArgon2 reads $argon2id$v=19$m=65536,t=3,p=4$salt$key
+func parseArgon2(encoded string, maxMemory int) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, errors.New("malformed argon2 parameters")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, errors.New("malformed argon2 salt")
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, errors.New("malformed argon2 hash")
+	}
+	p.saltLen, p.keyLen = len(salt), len(key)
+	return p, salt, key, p.check(maxMemory)
+}
+
+// argon2Limiter bounds the memory of one argon2 call and the number of
+// calls running at once, argon2 allocates outside the Lua states
+type argon2Limiter struct {
+	maxMemory int
+	slots     chan struct{}
+}
+
+func newArgon2Limiter(conf *config.LuaArgon2) *argon2Limiter {
+	maxMemory, maxConcurrent := Argon2MaxMemory, Argon2MaxConcurrent
+	if conf != nil {
+		maxMemory = utils.SafeFetch(conf.MaxMemory, Argon2MaxMemory)
+		maxConcurrent = utils.SafeFetch(conf.MaxConcurrent, Argon2MaxConcurrent)
+	}
+	return &argon2Limiter{maxMemory: maxMemory, slots: make(chan struct{}, max(maxConcurrent, 1))}
+}
+
+// key derives the key once a slot is free and the memory fits in the script's limit.
+// Waiting for the slot ends with the script, the computation itself cannot be stopped.
+func (a *argon2Limiter) key(L *lua.LState, p argon2Params, password string, salt []byte) ([]byte, error) {
+	if err := luaengine.Allocate(L, int64(p.memory)<<10); err != nil {
+		return nil, err
+	}
+	ctx := luaengine.BaseContext(L.Context())
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case a.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+	defer func() { <-a.slots }()
+	return argon2.IDKey([]byte(password), salt, uint32(p.time), uint32(p.memory), uint8(p.threads), uint32(p.keyLen)), nil
+}
+
+// funcs returns internal.crypt.argon2, argon2id in the PHC string format
+func (a *argon2Limiter) funcs() map[string]lua.LGFunction {
+	return map[string]lua.LGFunction{
+		// hash(password, opts) with opts {time, memory (KiB), threads, key_len, salt_len}
+		"hash": func(L *lua.LState) int {
+			password := L.CheckString(1)
+			opts := L.OptTable(2, L.NewTable())
+			p := argon2Default
+			p.memory = min(p.memory, a.maxMemory)
+			for name, field := range map[string]*int{
+				"time": &p.time, "memory": &p.memory, "threads": &p.threads, "key_len": &p.keyLen, "salt_len": &p.saltLen,
+			} {
+				if n, ok := opts.RawGetString(name).(lua.LNumber); ok {
+					*field = int(n)
+				}
+			}
+			if err := p.check(a.maxMemory); err != nil {
+				return pushError(L, err)
+			}
+			salt, err := randomBytes(p.saltLen)
+			if err != nil {
+				return pushError(L, err)
+			}
+			key, err := a.key(L, p, password, salt)
+			if err != nil {
+				return pushError(L, err)
+			}
+			L.Push(lua.LString(fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+				argon2.Version, p.memory, p.time, p.threads,
+				base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))))
+			return 1
+		},
+		// verify(password, hash) reports whether the password matches,
+		// a malformed hash or one above the limits gives false and the reason
+		"verify": func(L *lua.LState) int {
+			password, encoded := L.CheckString(1), L.CheckString(2)
+			p, salt, key, err := parseArgon2(encoded, a.maxMemory)
+			if err == nil {
+				var derived []byte
+				if derived, err = a.key(L, p, password, salt); err == nil {
+					L.Push(lua.LBool(subtle.ConstantTimeCompare(derived, key) == 1))
+					return 1
+				}
+			}
+			L.Push(lua.LFalse)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		},
+	}
+}
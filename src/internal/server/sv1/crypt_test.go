@@ -0,0 +1,152 @@
+package sv1
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// cryptScript evaluates the expression with the crypt and encoding modules
+// loaded and sends its two results
+func cryptScript(expr string) string {
+	return `local s = require("internal.session")
+local hmac = require("internal.crypt.hmac")
+local random = require("internal.crypt.random")
+local aes = require("internal.crypt.aes")
+local ed25519 = require("internal.crypt.ed25519")
+local argon2 = require("internal.crypt.argon2")
+local sha256 = require("internal.crypt.sha256")
+local base64 = require("internal.encoding.base64")
+local hex = require("internal.encoding.hex")
+local key = string.rep("k", 32)
+local result, err = ` + expr + `
+s.response.send({result = result, err = err})
+`
+}
+
+func TestCrypt(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want any
+	}{
+		// RFC 4231, test case 2
+		{"hmac sha256", `hmac.sha256("Jefe", "what do ya want for nothing?")`, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"hmac sha512 length", `#hmac.sha512("k", "data")`, "128"},
+		{"hmac in base64", `hmac.sha256("Jefe", "what do ya want for nothing?", "base64")`, "W9zBRr9gdU5qBCQmCJV1x1oAPwidJzmDnexYuWTsOEM="},
+		{"hmac verify", `hmac.verify("sha256", "k", "data", hmac.sha256("k", "data"))`, true},
+		{"hmac verify a wrong mac", `hmac.verify("sha512", "k", "data", hmac.sha512("other", "data"))`, false},
+		{"random bytes", `#random.bytes(24)`, "24"},
+		{"random bytes in hex", `#random.bytes(4, "hex")`, "8"},
+		{"random hex", `#random.hex()`, "32"},
+		{"random token", `#random.token() == 43 and not random.token():find("[+/=]")`, true},
+		{"random tokens differ", `random.token() ~= random.token()`, true},
+		{"random int", `(function() for i = 1, 100 do local n = random.int(-2, 2); if n < -2 or n > 2 or n % 1 ~= 0 then return false end end; return true end)()`, true},
+		{"random int of one value", `random.int(7, 7)`, "7"},
+		{"base64", `base64.encode("\255\254ab")`, "//5hYg=="},
+		{"base64 url without padding", `base64.encode("\255\254ab", {url = true, padding = false})`, "__5hYg"},
+		{"base64 round trip", `base64.decode(base64.encode("\0bytes\1")) == "\0bytes\1"`, true},
+		{"hex", `hex.encode("\1\171")`, "01ab"},
+		{"hex round trip", `hex.decode("01AB") == "\1\171"`, true},
+		{"aes round trip", `aes.decrypt(key, aes.encrypt(key, "secret"))`, "secret"},
+		{"aes round trip in base64", `aes.decrypt(key, aes.encrypt(key, "secret", {encoding = "base64"}), {encoding = "base64"})`, "secret"},
+		{"aes with additional data", `aes.decrypt(key, aes.encrypt(key, "secret", {aad = "unit-1"}), {aad = "unit-1"})`, "secret"},
+		{"aes nonces differ", `aes.encrypt(key, "secret") ~= aes.encrypt(key, "secret")`, true},
+		{"ed25519 round trip", `(function() local pub, priv = ed25519.generate("hex"); return ed25519.verify(pub, "msg", ed25519.sign(priv, "msg", "hex"), "hex") end)()`, true},
+		{"ed25519 another message", `(function() local pub, priv = ed25519.generate(); return ed25519.verify(pub, "other", ed25519.sign(priv, "msg")) end)()`, false},
+		// RFC 8032, test 1
+		{"ed25519 known signature", `ed25519.sign("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60", "", "hex"):sub(1, 16)`, "e5564300c360ac72"},
+		{"argon2 round trip", `argon2.verify("pass", argon2.hash("pass", {time = 1, memory = 64, threads = 1}))`, true},
+		{"argon2 wrong password", `argon2.verify("wrong", argon2.hash("pass", {time = 1, memory = 64, threads = 1}))`, false},
+		{"argon2 format", `argon2.hash("pass", {time = 1, memory = 64, threads = 2}):match("^%$argon2id%$v=19%$m=64,t=1,p=2%$") ~= nil`, true},
+		{"argon2 default costs", `argon2.hash("pass"):match("^%$argon2id%$v=19%$m=19456,t=2,p=1%$") ~= nil`, true},
+		{"sha256 of the bytes", `sha256.hash("abc")`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"sha256 of a number", `sha256.hash(42) == sha256.hash("42") and sha256.hash(1.5) == sha256.hash("1.5")`, true},
+		{"sha256 of a big integer", `sha256.hash(123456789012) == sha256.hash("123456789012") and sha256.hash(2^53) == sha256.hash("9007199254740992")`, true},
+		{"sha256 of an exponent", `sha256.hash(1e20) == sha256.hash("1e+20") and sha256.hash(1e-5) == sha256.hash("1e-05")`, true},
+		{"sha256 of a number as tostring", `sha256.hash(-0.25) == sha256.hash(tostring(-0.25))`, true},
+		{"sha256 in base64url", `sha256.hash("abc", "base64url")`, "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0"},
+	}
+	for _, tt := range tests {
+		resp := runScript(t, cryptScript(tt.expr), nil)
+		result, ok := resp.Result.(map[string]any)
+		if resp.Error != nil || !ok || result["err"] != nil || !sameResult(result["result"], tt.want) {
+			t.Errorf("%s: %+v %+v, want %v", tt.name, resp.Result, resp.Error, tt.want)
+		}
+	}
+}
+
+// sameResult compares numbers, which come back as json.Number, by their text
+func sameResult(got, want any) bool {
+	if s, ok := want.(string); ok {
+		if n, ok := got.(interface{ String() string }); ok {
+			return n.String() == s
+		}
+	}
+	return got == want
+}
+
+func TestCrypt_Errors(t *testing.T) {
+	for _, expr := range []string{
+		`hmac.sha256("k", "data", "base32")`,
+		`hmac.verify("md5", "k", "data", "00")`,
+		`hmac.verify("sha256", "k", "data", "not hex")`,
+		`random.bytes(0)`,
+		`random.bytes(65537)`,
+		`random.int(2, 1)`,
+		`random.int(0, 2^60)`,
+		`base64.decode("***")`,
+		`base64.decode("YQ", {padding = true})`,
+		`hex.decode("0g")`,
+		`aes.encrypt("short", "secret")`,
+		`aes.decrypt(key, "short")`,
+		`(function() local c = aes.encrypt(key, "secret"); return aes.decrypt(key, c:sub(1, -2) .. "x") end)()`,
+		`aes.decrypt(key, aes.encrypt(key, "secret", {aad = "unit-1"}), {aad = "unit-2"})`,
+		`aes.decrypt(string.rep("x", 32), aes.encrypt(key, "secret"))`,
+		`ed25519.sign("short", "msg")`,
+		`ed25519.verify("short", "msg", "sig")`,
+		`argon2.hash("pass", {time = 0})`,
+		`argon2.hash("pass", {memory = 2^30})`,
+		`argon2.hash("pass", {threads = 300})`,
+		`argon2.verify("pass", "$2a$10$bcrypt")`,
+		`argon2.verify("pass", "$argon2id$v=19$m=64,t=1,p=1$***$***")`,
+		`argon2.verify("pass", "$argon2id$v=19$m=4194304,t=1,p=1$c2FsdHNhbHQ$a2V5a2V5a2V5a2V5a2V5a2V5")`,
+		`sha256.hash({})`,
+	} {
+		resp := runScript(t, cryptScript(expr), nil)
+		result, _ := resp.Result.(map[string]any)
+		if msg, _ := result["err"].(string); msg == "" || (result["result"] != nil && result["result"] != false) {
+			t.Errorf("%s: %+v %+v, want an error", expr, resp.Result, resp.Error)
+		}
+	}
+}
+
+func TestArgon2Limiter_Slots(t *testing.T) {
+	one := 1
+	a := newArgon2Limiter(&config.LuaArgon2{MaxMemory: &Argon2MaxMemory, MaxConcurrent: &one})
+	L := lua.NewState()
+	defer L.Close()
+	p := argon2Params{time: 1, memory: 64, threads: 1, keyLen: 32, saltLen: 16}
+
+	// every slot is busy, the call gives up with its script
+	a.slots <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	L.SetContext(ctx)
+	if _, err := a.key(L, p, "pass", []byte("saltsalt")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("key with no free slot = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	<-a.slots
+	L.SetContext(context.Background())
+	if key, err := a.key(L, p, "pass", []byte("saltsalt")); err != nil || len(key) != 32 {
+		t.Errorf("key with a free slot = %x, %v", key, err)
+	}
+	if len(a.slots) != 0 {
+		t.Errorf("the slot was not released")
+	}
+}
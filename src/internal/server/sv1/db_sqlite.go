@@ -2,6 +2,7 @@ package sv1
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"sync"
@@ -125,6 +126,16 @@ func (conn *DBConnection) processWrites() {
 	}
 }
 
+// sqlArg converts a query argument, integers are bound as int64
+func sqlArg(v lua.LValue) any {
+	arg := ConvertLuaTypesToGolang(v)
+	if n, ok := arg.(json.Number); ok {
+		i, _ := n.Int64()
+		return i
+	}
+	return arg
+}
+
 func dbExec(L *lua.LState) int {
 	ud := L.CheckUserData(1)
 	conn, ok := ud.Value.(*DBConnection)
@@ -140,7 +151,7 @@ func dbExec(L *lua.LState) int {
 	if L.GetTop() >= 3 {
 		params := L.CheckTable(3)
 		params.ForEach(func(k lua.LValue, v lua.LValue) {
-			args = append(args, ConvertLuaTypesToGolang(v))
+			args = append(args, sqlArg(v))
 		})
 	}
 
@@ -229,7 +240,7 @@ func dbQueryRow(L *lua.LState) int {
 	if L.GetTop() >= 3 {
 		params := L.CheckTable(3)
 		params.ForEach(func(k lua.LValue, v lua.LValue) {
-			args = append(args, ConvertLuaTypesToGolang(v))
+			args = append(args, sqlArg(v))
 		})
 	}
 
@@ -325,7 +336,7 @@ func dbQuery(L *lua.LState) int {
 	if L.GetTop() >= 3 {
 		params := L.CheckTable(3)
 		params.ForEach(func(k lua.LValue, v lua.LValue) {
-			args = append(args, ConvertLuaTypesToGolang(v))
+			args = append(args, sqlArg(v))
 		})
 	}
 
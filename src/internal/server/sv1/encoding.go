@@ -0,0 +1,115 @@
+package sv1
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Encodings of binary results a script may ask for
+const (
+	encodingRaw       = "raw"
+	encodingHex       = "hex"
+	encodingBase64    = "base64"
+	encodingBase64URL = "base64url"
+)
+
+// encodeBytes returns b in the encoding, base64url is unpadded
+func encodeBytes(b []byte, encoding string) (string, error) {
+	switch encoding {
+	case encodingRaw:
+		return string(b), nil
+	case encodingHex:
+		return hex.EncodeToString(b), nil
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case encodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+	return "", fmt.Errorf("unknown encoding %q, use raw, hex, base64 or base64url", encoding)
+}
+
+// decodeBytes is the reverse of encodeBytes
+func decodeBytes(s, encoding string) ([]byte, error) {
+	switch encoding {
+	case encodingRaw:
+		return []byte(s), nil
+	case encodingHex:
+		return hex.DecodeString(s)
+	case encodingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case encodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return nil, fmt.Errorf("unknown encoding %q, use raw, hex, base64 or base64url", encoding)
+}
+
+// newModLoader returns the loader of a module made of the functions
+func newModLoader(llog *slog.Logger, name, seed string, funcs map[string]lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		llog.Debug("import module " + name)
+		mod := L.SetFuncs(L.NewTable(), funcs)
+		L.SetField(mod, "__seed", lua.LString(seed))
+		L.Push(mod)
+		return 1
+	}
+}
+
+// pushError returns nil and the message of the error to the script
+func pushError(L *lua.LState, err error) int {
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+	return 2
+}
+
+// base64Encoding picks the alphabet and padding from {url = true, padding = false}
+func base64Encoding(L *lua.LState, n int) *base64.Encoding {
+	opts := L.OptTable(n, L.NewTable())
+	enc := base64.StdEncoding
+	if lua.LVAsBool(opts.RawGetString("url")) {
+		enc = base64.URLEncoding
+	}
+	if padding, ok := opts.RawGetString("padding").(lua.LBool); ok && !bool(padding) {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	return enc
+}
+
+// internal.encoding.base64
+var base64Funcs = map[string]lua.LGFunction{
+	// encode(data, opts) returns data in base64, opts are {url = true, padding = false}
+	"encode": func(L *lua.LState) int {
+		data := L.CheckString(1)
+		L.Push(lua.LString(base64Encoding(L, 2).EncodeToString([]byte(data))))
+		return 1
+	},
+	// decode(text, opts) takes the options encode was given
+	"decode": func(L *lua.LState) int {
+		text := L.CheckString(1)
+		data, err := base64Encoding(L, 2).DecodeString(text)
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LString(data))
+		return 1
+	},
+}
+
+// internal.encoding.hex
+var hexFuncs = map[string]lua.LGFunction{
+	"encode": func(L *lua.LState) int {
+		L.Push(lua.LString(hex.EncodeToString([]byte(L.CheckString(1)))))
+		return 1
+	},
+	"decode": func(L *lua.LState) int {
+		data, err := hex.DecodeString(L.CheckString(1))
+		if err != nil {
+			return pushError(L, err)
+		}
+		L.Push(lua.LString(data))
+		return 1
+	},
+}
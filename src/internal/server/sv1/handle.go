@@ -8,7 +8,7 @@ import (
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 )
 
-func (h *HandlerV1) Handle(_ context.Context, sid string, r *http.Request, req *rpc.RPCRequest) *rpc.RPCResponse {
+func (h *HandlerV1) Handle(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest) *rpc.RPCResponse {
 	if req.Method == "" {
 		h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrMethodNotFoundS), slog.String("requested-method", req.Method))
 		return rpc.NewError(rpc.ErrMethodIsMissing, rpc.ErrMethodIsMissingS, nil, req.ID)
@@ -26,7 +26,7 @@ func (h *HandlerV1) Handle(_ context.Context, sid string, r *http.Request, req *
 	}
 	switch req.Params.(type) {
 	case map[string]any, []any, nil:
-		return h.handleLUA(sid, r, req, method)
+		return h.handleLUA(ctx, sid, r, req, method)
 	default:
 		// JSON-RPC 2.0 Specification:
 		// https://www.jsonrpc.org/specification#parameter_structures
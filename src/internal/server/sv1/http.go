@@ -0,0 +1,333 @@
+package sv1
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/egress"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Defaults of net.http.request, lua.http in the config overrides them
+var (
+	HTTPTimeout      = 30 * time.Second
+	HTTPMaxBody      = int64(10 << 20)
+	HTTPMaxRedirects = 10
+)
+
+// httpOptions are the fields of the table given to net.http.request
+type httpOptions struct {
+	method          string
+	url             string
+	headers         http.Header
+	body            []byte
+	timeout         time.Duration
+	followRedirects bool
+	maxBody         int64
+	log             bool
+}
+
+var errResponseTooLarge = errors.New("response body is larger than max_body")
+
+// parseHTTPOptions reads the request table:
+//
+//	{method = "PUT", url = "...", headers = {...}, body = "..." or {...},
+//	 json = value, timeout = 5 or "1m", follow_redirects = false, max_body = 1024, log = true}
+//
+// A table body and json are sent encoded as JSON.
+func (h *HandlerV1) parseHTTPOptions(L *lua.LState, tbl *lua.LTable) (*httpOptions, error) {
+	conf := h.x.Config.Conf.Lua.HTTP
+	o := &httpOptions{
+		method:          http.MethodGet,
+		headers:         http.Header{},
+		timeout:         HTTPTimeout,
+		followRedirects: true,
+		maxBody:         HTTPMaxBody,
+	}
+	if conf != nil {
+		o.timeout = utils.SafeFetch(conf.Timeout, HTTPTimeout)
+		o.maxBody = utils.SafeFetch(conf.MaxBody, HTTPMaxBody)
+	}
+
+	if method, ok := tbl.RawGetString("method").(lua.LString); ok {
+		o.method = strings.ToUpper(string(method))
+	}
+	url, ok := tbl.RawGetString("url").(lua.LString)
+	if !ok || url == "" {
+		return nil, errors.New("url is required")
+	}
+	o.url = string(url)
+
+	switch headers := tbl.RawGetString("headers").(type) {
+	case *lua.LTable:
+		var err error
+		headers.ForEach(func(k, v lua.LValue) {
+			switch v := v.(type) {
+			case *lua.LTable:
+				// several values of one header
+				v.ForEach(func(_, item lua.LValue) {
+					o.headers.Add(k.String(), item.String())
+				})
+			case lua.LString, lua.LNumber, lua.LBool:
+				o.headers.Set(k.String(), v.String())
+			default:
+				err = fmt.Errorf("header %s must be a string or a list of strings", k.String())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	case *lua.LNilType:
+	default:
+		return nil, errors.New("headers must be a table")
+	}
+
+	if value := tbl.RawGetString("json"); value != lua.LNil {
+		if err := o.setJSONBody(L, value); err != nil {
+			return nil, err
+		}
+	}
+	switch body := tbl.RawGetString("body").(type) {
+	case lua.LString:
+		o.body = []byte(body)
+	case *lua.LTable:
+		if err := o.setJSONBody(L, body); err != nil {
+			return nil, err
+		}
+	case *lua.LNilType:
+	default:
+		return nil, errors.New("body must be a string or a table")
+	}
+
+	// the script may only lower the limits of the config
+	switch timeout := tbl.RawGetString("timeout").(type) {
+	case lua.LNumber:
+		o.timeout = lower(o.timeout, time.Duration(float64(timeout)*float64(time.Second)))
+	case lua.LString:
+		d, err := time.ParseDuration(string(timeout))
+		if err != nil {
+			return nil, fmt.Errorf("timeout: %w", err)
+		}
+		o.timeout = lower(o.timeout, d)
+	}
+	if follow, ok := tbl.RawGetString("follow_redirects").(lua.LBool); ok {
+		o.followRedirects = bool(follow)
+	}
+	if maxBody, ok := tbl.RawGetString("max_body").(lua.LNumber); ok {
+		o.maxBody = lower(o.maxBody, int64(maxBody))
+	}
+	o.log = lua.LVAsBool(tbl.RawGetString("log"))
+	return o, nil
+}
+
+// lower returns the script's value when it is within the configured limit,
+// limit <= 0 being unlimited, and the limit otherwise
+func lower[T int64 | time.Duration](limit, value T) T {
+	if value > 0 && (limit <= 0 || value < limit) {
+		return value
+	}
+	return limit
+}
+
+func (o *httpOptions) setJSONBody(L *lua.LState, value lua.LValue) error {
+	converted, size, err := luaToGo(value)
+	if err != nil {
+		return fmt.Errorf("cannot encode the body: %w", err)
+	}
+	if err := luaengine.Allocate(L, size.json("")); err != nil {
+		return fmt.Errorf("cannot encode the body: %w", err)
+	}
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return fmt.Errorf("cannot encode the body: %w", err)
+	}
+	o.body = data
+	if o.headers.Get("Content-Type") == "" {
+		o.headers.Set("Content-Type", "application/json")
+	}
+	return nil
+}
+
+// doHTTP sends the request on behalf of the script of the com method once the
+// egress policy allows it. The request is canceled together with the script,
+// the timeout of the options only shortens it.
+func (h *HandlerV1) doHTTP(ctx context.Context, o *httpOptions, method string, initiator func(http.Header)) (*http.Response, []byte, error) {
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	var body io.Reader
+	if o.body != nil {
+		body = bytes.NewReader(o.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, o.method, o.url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = o.headers
+
+	decision, err := h.egress.Check(method, req.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if decision.ForwardInitiator {
+		initiator(req.Header)
+	}
+
+	maxRedirects := HTTPMaxRedirects
+	if conf := h.x.Config.Conf.Lua.HTTP; conf != nil {
+		maxRedirects = utils.SafeFetch(conf.MaxRedirects, HTTPMaxRedirects)
+	}
+	client := &http.Client{
+		Transport: h.egress.Transport(decision),
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if !o.followRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			// the connections of the transport are checked against the first rule only
+			redirect, err := h.egress.Check(method, next.URL)
+			if err != nil {
+				return err
+			}
+			if !redirect.SameRule(decision) {
+				return fmt.Errorf("%w: redirect to %s leaves the egress rule of the request", egress.ErrDenied, next.URL.Host)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	reader := io.Reader(resp.Body)
+	if o.maxBody > 0 {
+		reader = io.LimitReader(resp.Body, o.maxBody+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.maxBody > 0 && int64(len(data)) > o.maxBody {
+		return nil, nil, fmt.Errorf("%w (%d bytes)", errResponseTooLarge, o.maxBody)
+	}
+	return resp, data, nil
+}
+
+// httpResult turns the response into the table returned to the script.
+// A JSON response is decoded into the json field as well, like json.decode does.
+func httpResult(L *lua.LState, resp *http.Response, body []byte) *lua.LTable {
+	result := L.NewTable()
+	L.SetField(result, "status", lua.LNumber(resp.StatusCode))
+	L.SetField(result, "status_text", lua.LString(resp.Status))
+	L.SetField(result, "body", lua.LString(body))
+	L.SetField(result, "content_length", lua.LNumber(resp.ContentLength))
+	L.SetField(result, "url", lua.LString(resp.Request.URL.String()))
+
+	headers := L.NewTable()
+	for k, v := range resp.Header {
+		L.SetField(headers, k, ConvertGolangTypesToLua(L, v))
+	}
+	L.SetField(result, "headers", headers)
+
+	if isJSON(resp.Header.Get("Content-Type")) {
+		if decoded, err := jsonDecode(L, body); err == nil {
+			L.SetField(result, "json", decoded)
+		}
+	}
+	return result
+}
+
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// loadHTTPMod returns the net.http table of a script
+func (h *HandlerV1) loadHTTPMod(L *lua.LState, llog *slog.Logger, script, method string, initiator func(http.Header)) *lua.LTable {
+	mod := L.NewTable()
+
+	do := func(L *lua.LState, o *httpOptions) int {
+		resp, body, err := h.doHTTP(luaengine.BaseContext(L.Context()), o, method, initiator)
+		if err != nil {
+			if errors.Is(err, egress.ErrDenied) {
+				llog.Warn("HTTP request denied by egress policy", slog.String("script", script), slog.String("method", o.method), slog.String("url", o.url), slog.String("error", err.Error()))
+			} else if o.log {
+				llog.Info("HTTP request failed", slog.String("script", script), slog.String("method", o.method), slog.String("url", o.url), slog.String("error", err.Error()))
+			}
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		if o.log {
+			llog.Info("HTTP request",
+				slog.String("script", script),
+				slog.String("method", o.method),
+				slog.String("url", o.url),
+				slog.Int("status", resp.StatusCode),
+				slog.String("status_text", resp.Status),
+			)
+		}
+		L.Push(httpResult(L, resp, body))
+		return 1
+	}
+
+	L.SetField(mod, "request", L.NewFunction(func(L *lua.LState) int {
+		o, err := h.parseHTTPOptions(L, L.CheckTable(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		return do(L, o)
+	}))
+
+	// get_request(log, url) and post_request(log, url, content_type, body)
+	// are kept for the scripts written before request
+	L.SetField(mod, "get_request", L.NewFunction(func(L *lua.LState) int {
+		tbl := L.NewTable()
+		tbl.RawSetString("log", lua.LBool(L.ToBool(1)))
+		tbl.RawSetString("url", lua.LString(L.ToString(2)))
+		o, err := h.parseHTTPOptions(L, tbl)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		return do(L, o)
+	}))
+	L.SetField(mod, "post_request", L.NewFunction(func(L *lua.LState) int {
+		tbl := L.NewTable()
+		tbl.RawSetString("log", lua.LBool(L.ToBool(1)))
+		tbl.RawSetString("method", lua.LString(http.MethodPost))
+		tbl.RawSetString("url", lua.LString(L.ToString(2)))
+		tbl.RawSetString("body", lua.LString(L.ToString(4)))
+		headers := L.NewTable()
+		headers.RawSetString("Content-Type", lua.LString(L.ToString(3)))
+		tbl.RawSetString("headers", headers)
+		o, err := h.parseHTTPOptions(L, tbl)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		return do(L, o)
+	}))
+	return mod
+}
@@ -0,0 +1,278 @@
+package sv1
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/egress"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runScript runs the script as the method Test with the params
+// and returns its result, or its error
+func runScript(tb testing.TB, script string, params map[string]any) *rpc.RPCResponse {
+	tb.Helper()
+	return runScriptWith(tb, nil, script, params)
+}
+
+// runScriptWith runs the script like runScript under the egress policy
+func runScriptWith(tb testing.TB, policy *egress.Policy, script string, params map[string]any) *rpc.RPCResponse {
+	tb.Helper()
+	comDir := tb.TempDir()
+	if err := os.WriteFile(filepath.Join(comDir, "Test.lua"), []byte(script), 0o644); err != nil {
+		tb.Fatal(err)
+	}
+	h := newTestHandlerIn(tb, comDir)
+	if policy != nil {
+		h.egress = policy
+	}
+	tb.Cleanup(h.Shutdown)
+
+	id := json.RawMessage("1")
+	return h.Handle(context.Background(), "test", httptest.NewRequest("POST", "/com", nil), &rpc.RPCRequest{
+		JSONRPC: rpc.JSONRPCVersion,
+		ID:      &id,
+		Method:  "Test",
+		Params:  params,
+	})
+}
+
+// httpScript sends the request table built from the params
+// and returns the response, or the error as {error = ...}
+const httpScript = `local s = require("internal.session")
+local net = require("internal.net")
+local req = s.request.params.get("req")
+if req.url then
+  req.url = s.request.params.get("url") .. req.url
+end
+local resp, err = net.http.request(req)
+if not resp then
+  s.response.send({error = err})
+  return
+end
+s.response.send({status = resp.status, body = resp.body, json = resp.json, url = resp.url})
+`
+
+func echoServer(tb testing.TB) *httptest.Server {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/echo", http.StatusFound)
+			return
+		case "/slow":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(map[string]any{
+			"method":       r.Method,
+			"path":         r.URL.Path,
+			"content_type": r.Header.Get("Content-Type"),
+			"x_token":      r.Header.Values("X-Token"),
+			"session":      r.Header.Get("X-Session-UUID"),
+			"body":         string(body),
+		})
+	}))
+	tb.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPRequest(t *testing.T) {
+	srv := echoServer(t)
+
+	tests := []struct {
+		name  string
+		req   map[string]any
+		check func(result map[string]any) bool
+	}{
+		{"get by default", map[string]any{"url": "/echo"}, func(r map[string]any) bool {
+			return r["status"] == json.Number("200") && r["json"].(map[string]any)["method"] == "GET"
+		}},
+		{"delete", map[string]any{"method": "delete", "url": "/echo"}, func(r map[string]any) bool {
+			return r["json"].(map[string]any)["method"] == "DELETE"
+		}},
+		{"head", map[string]any{"method": "HEAD", "url": "/echo"}, func(r map[string]any) bool {
+			return r["status"] == json.Number("200") && r["body"] == ""
+		}},
+		{"put a table as JSON", map[string]any{
+			"method":  "PUT",
+			"url":     "/echo",
+			"headers": map[string]any{"X-Token": []any{"a", "b"}},
+			"body":    map[string]any{"name": "unit"},
+		}, func(r map[string]any) bool {
+			echo := r["json"].(map[string]any)
+			return echo["method"] == "PUT" && echo["content_type"] == "application/json" &&
+				echo["body"] == `{"name":"unit"}` && len(echo["x_token"].([]any)) == 2
+		}},
+		{"patch a string", map[string]any{
+			"method":  "PATCH",
+			"url":     "/echo",
+			"headers": map[string]any{"Content-Type": "text/plain"},
+			"body":    "plain",
+		}, func(r map[string]any) bool {
+			echo := r["json"].(map[string]any)
+			return echo["content_type"] == "text/plain" && echo["body"] == "plain"
+		}},
+		{"follow redirects", map[string]any{"url": "/redirect"}, func(r map[string]any) bool {
+			return r["status"] == json.Number("200") && strings.HasSuffix(r["url"].(string), "/echo")
+		}},
+		{"keep redirects", map[string]any{"url": "/redirect", "follow_redirects": false}, func(r map[string]any) bool {
+			return r["status"] == json.Number("302")
+		}},
+		{"max body", map[string]any{"url": "/big", "max_body": 1024}, func(r map[string]any) bool {
+			return strings.Contains(r["error"].(string), "max_body")
+		}},
+		{"timeout", map[string]any{"url": "/slow", "timeout": 0.1}, func(r map[string]any) bool {
+			return strings.Contains(r["error"].(string), "deadline exceeded")
+		}},
+		{"no url", map[string]any{}, func(r map[string]any) bool {
+			return r["error"] == "url is required"
+		}},
+	}
+	for _, tt := range tests {
+		resp := runScript(t, httpScript, map[string]any{"url": srv.URL, "req": tt.req})
+		result, ok := resp.Result.(map[string]any)
+		if resp.Error != nil || !ok || !tt.check(result) {
+			t.Errorf("%s: %+v %+v", tt.name, resp.Result, resp.Error)
+		}
+	}
+}
+
+func TestHTTPRequest_CanceledWithScript(t *testing.T) {
+	srv := echoServer(t)
+	script := `--[[meta
+{"timeout": "200ms"}
+]]
+local net = require("internal.net")
+local s = require("internal.session")
+net.http.request({url = s.request.params.get("url") .. "/slow", timeout = 10})
+`
+	start := time.Now()
+	resp := runScript(t, script, map[string]any{"url": srv.URL})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the request outlived the script by %v", elapsed)
+	}
+	if resp.Error == nil {
+		t.Errorf("result = %+v, want a timeout", resp.Result)
+	}
+}
+
+func TestHTTPRequest_Compat(t *testing.T) {
+	srv := echoServer(t)
+	script := `local net = require("internal.net")
+local s = require("internal.session")
+local get = net.http.get_request(false, s.request.params.get("url") .. "/echo")
+local post = net.http.post_request(false, s.request.params.get("url") .. "/echo", "text/plain", "hi")
+s.response.send({get = get.json.method, post = post.json.body, ctype = post.json.content_type})
+`
+	resp := runScript(t, script, map[string]any{"url": srv.URL})
+	want := map[string]any{"get": "GET", "post": "hi", "ctype": "text/plain"}
+	result, _ := resp.Result.(map[string]any)
+	for k, v := range want {
+		if result[k] != v {
+			t.Errorf("%s = %v, want %v (%+v)", k, result[k], v, resp.Error)
+		}
+	}
+}
+
+func TestHTTPRequest_Egress(t *testing.T) {
+	srv := echoServer(t)
+	params := map[string]any{"url": srv.URL, "req": map[string]any{"url": "/echo"}}
+
+	tests := []struct {
+		name  string
+		init  *egress.Init
+		check func(result map[string]any) bool
+	}{
+		{"loopback is blocked", &egress.Init{BlockPrivate: true}, func(r map[string]any) bool {
+			return strings.Contains(r["error"].(string), egress.ErrDenied.Error())
+		}},
+		{"denied by default", &egress.Init{Default: "deny", Rules: []egress.Rule{{Methods: "Other"}}}, func(r map[string]any) bool {
+			return strings.Contains(r["error"].(string), egress.ErrDenied.Error())
+		}},
+		{"allowed without the initiator", &egress.Init{
+			BlockPrivate: true,
+			Rules:        []egress.Rule{{Methods: "Test", CIDRs: []string{"127.0.0.0/8"}}},
+		}, func(r map[string]any) bool {
+			return r["status"] == json.Number("200") && r["json"].(map[string]any)["session"] == ""
+		}},
+		{"allowed with the initiator", &egress.Init{
+			BlockPrivate: true,
+			Rules:        []egress.Rule{{Methods: "Test", CIDRs: []string{"127.0.0.0/8"}, ForwardInitiator: true}},
+		}, func(r map[string]any) bool {
+			return r["status"] == json.Number("200") && r["json"].(map[string]any)["session"] == "test"
+		}},
+	}
+	for _, tt := range tests {
+		policy, err := egress.New(tt.init)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := runScriptWith(t, policy, httpScript, params)
+		result, ok := resp.Result.(map[string]any)
+		if resp.Error != nil || !ok || !tt.check(result) {
+			t.Errorf("%s: %+v %+v", tt.name, resp.Result, resp.Error)
+		}
+	}
+}
+
+func TestParseHTTPOptions_Limits(t *testing.T) {
+	h := newTestHandlerIn(t, t.TempDir())
+	t.Cleanup(h.Shutdown)
+	timeout, maxBody := 5*time.Second, int64(1024)
+	h.x.Config.Conf.Lua.HTTP = &config.LuaHTTP{Timeout: &timeout, MaxBody: &maxBody}
+	L := lua.NewState()
+	defer L.Close()
+
+	tests := []struct {
+		fields  string
+		timeout time.Duration
+		maxBody int64
+	}{
+		{``, 5 * time.Second, 1024},
+		{`timeout = 1, max_body = 10`, time.Second, 10},
+		{`timeout = "100ms"`, 100 * time.Millisecond, 1024},
+		// the script cannot remove or raise the limits of the config
+		{`timeout = 0, max_body = 0`, 5 * time.Second, 1024},
+		{`timeout = -1, max_body = -1`, 5 * time.Second, 1024},
+		{`timeout = "1h", max_body = 1e9`, 5 * time.Second, 1024},
+	}
+	for _, tt := range tests {
+		if err := L.DoString(`return {url = "http://example.com", ` + tt.fields + `}`); err != nil {
+			t.Fatal(err)
+		}
+		o, err := h.parseHTTPOptions(L, L.CheckTable(-1))
+		L.Pop(1)
+		if err != nil || o.timeout != tt.timeout || o.maxBody != tt.maxBody {
+			t.Errorf("{%s}: timeout %v, max_body %d, %v; want %v, %d", tt.fields, o.timeout, o.maxBody, err, tt.timeout, tt.maxBody)
+		}
+	}
+
+	// without limits in the config any positive value is taken
+	var unlimited int64
+	h.x.Config.Conf.Lua.HTTP.MaxBody = &unlimited
+	if err := L.DoString(`return {url = "http://example.com", max_body = 1e9}`); err != nil {
+		t.Fatal(err)
+	}
+	if o, err := h.parseHTTPOptions(L, L.CheckTable(-1)); err != nil || o.maxBody != 1e9 {
+		t.Errorf("max_body under an unlimited config = %v, %v", o, err)
+	}
+}
@@ -0,0 +1,123 @@
+package sv1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// loadJSONMod returns the loader of internal.json. Values are converted
+// by the rules of lua_types.go, the ones of the params and the response
+// of a script, and:
+//
+//	json.null              stands for null inside tables, decode returns it for null
+//	json.array(t)          marks t to be encoded as an array, even when empty
+//	json.object(t)         marks t to be encoded as an object, even when empty
+//	json.encode(v, opts)   opts are {pretty = true} or {indent = "\t" or 4}, 16 at most
+//	json.decode(s)         arrays and objects come back marked, integers a
+//	                       Lua number cannot hold come back as strings
+func loadJSONMod(llog *slog.Logger, seed string) func(*lua.LState) int {
+	return func(L *lua.LState) int {
+		llog.Debug("import module json")
+		jsonMod := L.NewTable()
+
+		null := jsonNullValue(L)
+		arrayMeta, objectMeta := jsonMeta(L, jsonArray), jsonMeta(L, jsonObject)
+
+		mark := func(meta *lua.LTable) *lua.LFunction {
+			return L.NewFunction(func(L *lua.LState) int {
+				tbl := L.OptTable(1, L.NewTable())
+				tbl.Metatable = meta
+				L.Push(tbl)
+				return 1
+			})
+		}
+
+		L.SetField(jsonMod, "null", null)
+		L.SetField(jsonMod, "array", mark(arrayMeta))
+		L.SetField(jsonMod, "object", mark(objectMeta))
+		L.SetField(jsonMod, "encode", L.NewFunction(jsonEncode))
+		L.SetField(jsonMod, "decode", L.NewFunction(func(L *lua.LState) int {
+			value, err := jsonDecode(L, []byte(L.CheckString(1)))
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(value)
+			return 1
+		}))
+
+		L.SetField(jsonMod, "__seed", lua.LString(seed))
+		L.Push(jsonMod)
+		return 1
+	}
+}
+
+// maxIndent is the longest indent of json.encode
+const maxIndent = 16
+
+func jsonEncode(L *lua.LState) int {
+	value := L.CheckAny(1)
+	indent := ""
+	if opts, ok := L.Get(2).(*lua.LTable); ok {
+		if lua.LVAsBool(opts.RawGetString("pretty")) {
+			indent = "  "
+		}
+		switch v := opts.RawGetString("indent").(type) {
+		case lua.LNumber:
+			indent = strings.Repeat(" ", min(max(int(v), 0), maxIndent))
+		case lua.LString:
+			indent = string(v)
+		}
+		if len(indent) > maxIndent {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(fmt.Sprintf("the indent is longer than %d bytes", maxIndent)))
+			return 2
+		}
+	}
+
+	converted, size, err := luaToGo(value)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	if err := luaengine.Allocate(L, size.json(indent)); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// the output goes to other programs rather than to HTML pages
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(converted); err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LString(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))))
+	return 1
+}
+
+// jsonDecode decodes a document into a Lua value, null becomes json.null
+// wherever it is, so it is told apart from a missing key
+func jsonDecode(L *lua.LState, data []byte) (lua.LValue, error) {
+	decoded, err := rpc.DecodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if decoded == nil {
+		return jsonNullValue(L), nil
+	}
+	c := &goConverter{L: L, keepNull: true, seen: make(map[goRef]struct{})}
+	return c.convert(decoded, 0)
+}
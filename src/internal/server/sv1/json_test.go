@@ -0,0 +1,82 @@
+package sv1
+
+import "testing"
+
+// jsonScript evaluates the expression and sends its two results
+func jsonScript(expr string) string {
+	return `local s = require("internal.session")
+local json = require("internal.json")
+local result, err = ` + expr + `
+s.response.send({result = result, err = err})
+`
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want any
+	}{
+		{"encode an object", `json.encode({name = "unit", tags = {"a", "b"}, on = true})`, `{"name":"unit","on":true,"tags":["a","b"]}`},
+		{"encode an empty table as an object", `json.encode({})`, `{}`},
+		{"encode an empty object", `json.encode(json.object())`, `{}`},
+		{"encode an empty array", `json.encode({list = json.array({})})`, `{"list":[]}`},
+		{"encode null", `json.encode({1, json.null, 3})`, `[1,null,3]`},
+		{"encode an object with null", `json.encode({a = json.null})`, `{"a":null}`},
+		{"encode without escaping HTML", `json.encode("<a & b>")`, `"<a & b>"`},
+		{"encode pretty", `json.encode({a = {1}}, {pretty = true})`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{"encode with an indent", `json.encode({a = 1}, {indent = "\t"})`, "{\n\t\"a\": 1\n}"},
+		{"encode with an indent width", `json.encode({a = 1}, {indent = 4})`, "{\n    \"a\": 1\n}"},
+		{"decode", `json.decode('{"a":[1,"x",false]}').a[2]`, "x"},
+		{"decode null", `json.decode('[null]')[1] == json.null`, true},
+		{"decode top-level null", `json.decode('null') == json.null`, true},
+		{"null is a string for tostring", `tostring(json.null)`, "null"},
+		{"round trip an empty object", `json.encode(json.decode('{"a":{},"b":[]}'))`, `{"a":{},"b":[]}`},
+		{"round trip null", `json.encode(json.decode('{"a":null,"b":[null,1]}'))`, `{"a":null,"b":[null,1]}`},
+		{"round trip an object with index keys", `json.encode(json.decode('{"1":"x","2":"y"}'))`, `{"1":"x","2":"y"}`},
+	}
+	for _, tt := range tests {
+		resp := runScript(t, jsonScript(tt.expr), nil)
+		result, ok := resp.Result.(map[string]any)
+		if resp.Error != nil || !ok || result["result"] != tt.want {
+			t.Errorf("%s: %+v %+v, want %q", tt.name, resp.Result, resp.Error, tt.want)
+		}
+	}
+}
+
+func TestJSON_Errors(t *testing.T) {
+	for _, expr := range []string{
+		`json.decode('{"a":')`,
+		`json.decode('{} trailing')`,
+		`json.encode(0/0)`,
+		`json.encode({}, {indent = string.rep(" ", 17)})`,
+		`(function() local t = {string.rep("x", 1000)} for i = 1, 30 do t = {t, t} end return json.encode(t) end)()`,
+	} {
+		resp := runScript(t, jsonScript(expr), nil)
+		result, _ := resp.Result.(map[string]any)
+		if msg, _ := result["err"].(string); msg == "" || result["result"] != nil {
+			t.Errorf("%s: %+v %+v, want an error", expr, resp.Result, resp.Error)
+		}
+	}
+}
+
+func TestJSON_Response(t *testing.T) {
+	// the markers and null also shape the response of a script
+	resp := runScript(t, `local s = require("internal.session")
+local json = require("internal.json")
+s.response.send({empty = json.object(), none = json.null, list = json.array()})
+`, nil)
+	result, ok := resp.Result.(map[string]any)
+	if !ok {
+		t.Fatalf("%+v %+v", resp.Result, resp.Error)
+	}
+	if _, ok := result["empty"].(map[string]any); !ok {
+		t.Errorf("empty = %#v, want an object", result["empty"])
+	}
+	if list, ok := result["list"].([]any); !ok || len(list) != 0 {
+		t.Errorf("list = %#v, want an empty array", result["list"])
+	}
+	if v, ok := result["none"]; !ok || v != nil {
+		t.Errorf("none = %#v, present %v, want null", v, ok)
+	}
+}
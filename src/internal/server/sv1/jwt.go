@@ -2,6 +2,7 @@ package sv1
 
 import (
 	"log/slog"
+	"maps"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -29,15 +30,20 @@ func jwtEncode(L *lua.LState) int {
 	expiresIn := L.GetField(payloadTbl, "expires_in")
 	expDuration := time.Hour
 
-	if expiresIn.Type() == lua.LTNumber {
-		floatVal := ConvertLuaTypesToGolang(expiresIn).(float64)
-		expDuration = time.Duration(floatVal) * time.Second
+	if seconds, ok := expiresIn.(lua.LNumber); ok {
+		expDuration = time.Duration(float64(seconds) * float64(time.Second))
 	}
 
+	converted, err := LuaToGo(payload)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("invalid payload: " + err.Error()))
+		return 2
+	}
 	claims := jwt.MapClaims{}
-	payload.ForEach(func(key, value lua.LValue) {
-		claims[key.String()] = ConvertLuaTypesToGolang(value)
-	})
+	if fields, ok := converted.(map[string]any); ok {
+		maps.Copy(claims, fields)
+	}
 	claims["iat"] = time.Now().Unix()
 	claims["exp"] = time.Now().Add(expDuration).Unix()
 
@@ -1,23 +1,27 @@
 package sv1
 
-// TODO: make a lua state pool using sync.Pool
-
 import (
+	"context"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"log/slog"
 	"math/rand/v2"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/colors"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 	lua "github.com/yuin/gopher-lua"
 	_ "modernc.org/sqlite"
@@ -35,6 +39,48 @@ func addInitiatorHeaders(sid string, req *http.Request, headers http.Header) {
 	headers.Set("X-Initiator-Referer", req.Referer())
 }
 
+// sandbox removes everything that lets a script touch the node process itself.
+// It is applied once per state, the pool restores the result between requests.
+func sandbox(L *lua.LState) {
+	osMod := L.GetGlobal("os").(*lua.LTable)
+	L.SetField(osMod, "exit", lua.LNil)
+
+	ioMod := L.GetGlobal("io").(*lua.LTable)
+	for _, k := range []string{"write", "output", "flush", "read", "input"} {
+		ioMod.RawSetString(k, lua.LNil)
+	}
+	L.Env.RawSetString("print", lua.LNil)
+
+	for _, name := range []string{"stdout", "stderr", "stdin"} {
+		stream := ioMod.RawGetString(name)
+		if t, ok := stream.(*lua.LUserData); ok {
+			t.Metatable = lua.LNil
+		}
+	}
+	luaengine.LimitAllocations(L)
+}
+
+// methodMetadata returns the metadata of the script, cached along with its chunk
+func (h *HandlerV1) methodMetadata(path string) (*metadata.Metadata, error) {
+	meta, err := h.protos.Attached(path, metadata.SidecarPath(path), func() (any, error) {
+		return metadata.Load(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta.(*metadata.Metadata), nil
+}
+
+// getState takes a state from the pool, waiting at most timeout if it is positive
+func (h *HandlerV1) getState(ctx context.Context, timeout time.Duration) (*lua.LState, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return h.pool.Get(ctx)
+}
+
 // A small reminder: this code is only at the MVP stage,
 // and some parts of the code may cause shock from the
 // incompetence of the developer. But, in the end,
@@ -42,29 +88,80 @@ func addInitiatorHeaders(sid string, req *http.Request, headers http.Header) {
 // contribute to the development of the code,
 // I will be only glad.
 // TODO: make this huge function more harmonious by dividing responsibilities
-func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest, path string) *rpc.RPCResponse {
+func (h *HandlerV1) handleLUA(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest, path string) *rpc.RPCResponse {
 	var __exit = -1
 
 	llog := h.x.SLog.With(slog.String("session-id", sid))
 	llog.Debug("handling LUA")
-	L := lua.NewState()
-	defer L.Close()
 
-	osMod := L.GetGlobal("os").(*lua.LTable)
-	L.SetField(osMod, "exit", lua.LNil)
+	meta, err := h.methodMetadata(path)
+	if err != nil {
+		llog.Error("cannot load method metadata", slog.String("script", path), slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	if err := auth.Authorize(ctx, meta.Permissions); err != nil {
+		llog.Info("request rejected", slog.String("issue", err.Error()), slog.String("requested-method", req.Method))
+		return auth.ErrorResponse(err, req.ID)
+	}
+	if errs := meta.ValidateParams(req.Params); errs != nil {
+		llog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidParamsS), slog.String("requested-method", req.Method))
+		return rpc.NewError(rpc.ErrInvalidParams, rpc.ErrInvalidParamsS, map[string]any{"errors": errs}, req.ID)
+	}
+	timeout := utils.SafeFetch(h.x.Config.Conf.Lua.Timeout, 10*time.Second)
+	if meta.Timeout != nil {
+		timeout = time.Duration(*meta.Timeout)
+	}
+	limit := utils.SafeFetch(h.x.Config.Conf.Lua.InstructionLimit, 0)
+	if meta.InstructionLimit != nil {
+		limit = *meta.InstructionLimit
+	}
+	memLimit := utils.SafeFetch(h.x.Config.Conf.Lua.MemoryLimit, 0)
+	if meta.MemoryLimit != nil {
+		memLimit = *meta.MemoryLimit
+	}
 
-	ioMod := L.GetGlobal("io").(*lua.LTable)
-	for _, k := range []string{"write", "output", "flush", "read", "input"} {
-		ioMod.RawSetString(k, lua.LNil)
+	// waiting for a free state does not eat into the time of the script,
+	// it is bounded by the same timeout on its own
+	L, err := h.getState(ctx, timeout)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			llog.Warn("no lua state became available in time", slog.String("script", path), slog.Duration("timeout", timeout))
+			return rpc.NewError(rpc.ErrTimeout, rpc.ErrTimeoutS, nil, req.ID)
+		}
+		llog.Error("no lua state available", slog.String("script", path), slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
 	}
-	L.Env.RawSetString("print", lua.LNil)
 
-	for _, name := range []string{"stdout", "stderr", "stdin"} {
-		stream := ioMod.RawGetString(name)
-		if t, ok := stream.(*lua.LUserData); ok {
-			t.Metatable = lua.LNil
+	// ctx is canceled when the client disconnects,
+	// the script is stopped in that case as well
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	execCtx, cancelExec := luaengine.NewExecContext(ctx, limit)
+	defer cancelExec()
+	// exhausted is set when the script hit one of the memory limits
+	var exhausted bool
+	defer func() {
+		// a state that saw a Go panic is not returned to the pool
+		if rec := recover(); rec != nil {
+			h.pool.Discard(L)
+			panic(rec)
+		}
+		// neither is a state that ran out of memory, the script may have
+		// left big values where the baseline restore does not look
+		if exhausted || errors.Is(context.Cause(execCtx), luaengine.ErrMemoryLimit) {
+			h.pool.Discard(L)
+			return
 		}
+		h.pool.Put(L)
+	}()
+	resetJSONValues(L)
+	if memLimit > 0 {
+		execCtx.WatchMemory(L, memLimit, utils.SafeFetch(h.x.Config.Conf.Lua.MemoryCheckInterval, 50000))
 	}
+	L.SetContext(execCtx)
 
 	seed := rand.Int()
 
@@ -114,10 +211,12 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 			for k, v := range params {
 				L.SetField(fetchedParamsTable, k, ConvertGolangTypesToLua(L, v))
 			}
+			fetchedParamsTable.Metatable = jsonMeta(L, jsonObject)
 		case []any:
 			for i, v := range params {
 				fetchedParamsTable.RawSetInt(i+1, ConvertGolangTypesToLua(L, v))
 			}
+			fetchedParamsTable.Metatable = jsonMeta(L, jsonArray)
 		}
 
 		paramsGetter := L.NewFunction(func(L *lua.LState) int {
@@ -275,6 +374,71 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 			return 0
 		}))
 
+		// stream sends an item to the client right away, as a notification.
+		// It returns false when the transport cannot push (a plain HTTP POST)
+		// or the client is gone, the script may then keep the items for the result.
+		notifier, streaming := rpc.NotifierFrom(ctx)
+		var streamSeq int
+		L.SetField(outTable, "streaming", lua.LBool(streaming))
+		L.SetField(outTable, "stream", L.NewFunction(func(L *lua.LState) int {
+			if !streaming {
+				L.Push(lua.LFalse)
+				L.Push(lua.LString("the client cannot receive notifications"))
+				return 2
+			}
+			item, err := LuaToGo(L.Get(1))
+			if err != nil {
+				L.Push(lua.LFalse)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			streamSeq++
+			err = notifier.Notify(rpc.NewNotification(rpc.StreamMethod, &rpc.StreamParams{
+				ID:     req.ID,
+				Method: req.Method,
+				Seq:    streamSeq,
+				Item:   item,
+			}))
+			if err != nil {
+				llog.Debug("cannot stream an item", slog.String("script", path), slog.String("error", err.Error()))
+				L.Push(lua.LFalse)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
+			L.Push(lua.LTrue)
+			return 1
+		}))
+
+		// auth is the principal the gateway authenticated, if any
+		authTable := L.NewTable()
+		if p, ok := auth.PrincipalFrom(ctx); ok {
+			authTable.RawSetString("authenticated", lua.LTrue)
+			authTable.RawSetString("principal", lua.LString(p.ID))
+			authTable.RawSetString("method", lua.LString(p.Method))
+			authTable.RawSetString("roles", ConvertGolangTypesToLua(L, p.Roles))
+			authTable.RawSetString("permissions", ConvertGolangTypesToLua(L, p.Permissions))
+			authTable.RawSetString("claims", ConvertGolangTypesToLua(L, p.Claims))
+		} else {
+			authTable.RawSetString("authenticated", lua.LFalse)
+			authTable.RawSetString("roles", ConvertGolangTypesToLua(L, []string{}))
+			authTable.RawSetString("permissions", ConvertGolangTypesToLua(L, []string{}))
+		}
+		if cert, ok := auth.CertificateFrom(ctx); ok {
+			certTable := L.NewTable()
+			certTable.RawSetString("subject", lua.LString(cert.Subject))
+			certTable.RawSetString("common_name", lua.LString(cert.CommonName))
+			certTable.RawSetString("organization", ConvertGolangTypesToLua(L, cert.Organization))
+			certTable.RawSetString("organizational_unit", ConvertGolangTypesToLua(L, cert.OrganizationalUnit))
+			certTable.RawSetString("issuer", lua.LString(cert.Issuer))
+			certTable.RawSetString("serial", lua.LString(cert.Serial))
+			certTable.RawSetString("fingerprint", lua.LString(cert.Fingerprint))
+			certTable.RawSetString("dns_names", ConvertGolangTypesToLua(L, cert.DNSNames))
+			certTable.RawSetString("email_addresses", ConvertGolangTypesToLua(L, cert.EmailAddresses))
+			certTable.RawSetString("ip_addresses", ConvertGolangTypesToLua(L, cert.IPAddresses))
+			certTable.RawSetString("uris", ConvertGolangTypesToLua(L, cert.URIs))
+			authTable.RawSetString("certificate", certTable)
+		}
+		L.SetField(sessionMod, "auth", authTable)
 		L.SetField(sessionMod, "request", inTable)
 		L.SetField(sessionMod, "response", outTable)
 
@@ -335,125 +499,9 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 	loadNetMod := func(L *lua.LState) int {
 		llog.Debug("import module net", slog.String("script", path))
 		netMod := L.NewTable()
-		netModhttp := L.NewTable()
-
-		L.SetField(netModhttp, "get_request", L.NewFunction(func(L *lua.LState) int {
-			logRequest := L.ToBool(1)
-			url := L.ToString(2)
-
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-
-			addInitiatorHeaders(sid, r, req.Header)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-			defer resp.Body.Close()
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-
-			if logRequest {
-				llog.Info("HTTP GET request",
-					slog.String("script", path),
-					slog.String("url", url),
-					slog.Int("status", resp.StatusCode),
-					slog.String("status_text", resp.Status),
-					slog.String("initiator_ip", req.Header.Get("X-Initiator-IP")),
-				)
-			}
-
-			result := L.NewTable()
-			L.SetField(result, "status", lua.LNumber(resp.StatusCode))
-			L.SetField(result, "status_text", lua.LString(resp.Status))
-			L.SetField(result, "body", lua.LString(body))
-			L.SetField(result, "content_length", lua.LNumber(resp.ContentLength))
-
-			headers := L.NewTable()
-			for k, v := range resp.Header {
-				L.SetField(headers, k, ConvertGolangTypesToLua(L, v))
-			}
-			L.SetField(result, "headers", headers)
-
-			L.Push(result)
-			return 1
-		}))
-
-		L.SetField(netModhttp, "post_request", L.NewFunction(func(L *lua.LState) int {
-			logRequest := L.ToBool(1)
-			url := L.ToString(2)
-			contentType := L.ToString(3)
-			payload := L.ToString(4)
-
-			body := strings.NewReader(payload)
-
-			req, err := http.NewRequest("POST", url, body)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-
-			req.Header.Set("Content-Type", contentType)
-
-			addInitiatorHeaders(sid, r, req.Header)
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-			defer resp.Body.Close()
-
-			respBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				L.Push(lua.LNil)
-				L.Push(lua.LString(err.Error()))
-				return 2
-			}
-
-			if logRequest {
-				llog.Info("HTTP POST request",
-					slog.String("script", path),
-					slog.String("url", url),
-					slog.String("content_type", contentType),
-					slog.Int("status", resp.StatusCode),
-					slog.String("status_text", resp.Status),
-					slog.String("initiator_ip", req.Header.Get("X-Initiator-IP")),
-				)
-			}
-
-			result := L.NewTable()
-			L.SetField(result, "status", lua.LNumber(resp.StatusCode))
-			L.SetField(result, "status_text", lua.LString(resp.Status))
-			L.SetField(result, "body", lua.LString(respBody))
-			L.SetField(result, "content_length", lua.LNumber(resp.ContentLength))
-
-			headers := L.NewTable()
-			for k, v := range resp.Header {
-				L.SetField(headers, k, ConvertGolangTypesToLua(L, v))
-			}
-			L.SetField(result, "headers", headers)
-
-			L.Push(result)
-			return 1
-		}))
-
+		netModhttp := h.loadHTTPMod(L, llog, path, req.Method, func(headers http.Header) {
+			addInitiatorHeaders(sid, r, headers)
+		})
 		L.SetField(netMod, "http", netModhttp)
 
 		L.SetField(netMod, "__seed", lua.LString(fmt.Sprint(seed)))
@@ -481,10 +529,9 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 			cost := ConvertLuaTypesToGolang(L.Get(2))
 			costInt := bcrypt.DefaultCost
 			switch v := cost.(type) {
-			case int:
-				costInt = v
-			case float64:
-				costInt = int(v)
+			case json.Number:
+				n, _ := v.Int64()
+				costInt = int(n)
 			case nil:
 				// ok, use DefaultCost
 			default:
@@ -537,13 +584,31 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 		llog.Debug("import module crypt.sha256", slog.String("script", path))
 		sha265mod := L.NewTable()
 
+		// hash(data, encoding) hashes the bytes of the string, hex by default.
+		// A number is hashed as the string tostring makes of it: integers in
+		// the int64 range in plain digits (123456789012), other numbers in
+		// Go's shortest %g form ("1.5", "1e+20", "1e-05").
 		L.SetField(sha265mod, "hash", L.NewFunction(func(l *lua.LState) int {
-			data := ConvertLuaTypesToGolang(L.Get(1))
-			var dataStr = fmt.Sprint(data)
-
-			hash := sha256.Sum256([]byte(dataStr))
+			var data string
+			switch v := L.Get(1).(type) {
+			case lua.LString:
+				data = string(v)
+			case lua.LNumber:
+				data = v.String()
+			default:
+				L.Push(lua.LNil)
+				L.Push(lua.LString("data must be a string or a number"))
+				return 2
+			}
+			hash := sha256.Sum256([]byte(data))
+			out, err := encodeBytes(hash[:], L.OptString(2, encodingHex))
+			if err != nil {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(err.Error()))
+				return 2
+			}
 
-			L.Push(lua.LString(hex.EncodeToString(hash[:])))
+			L.Push(lua.LString(out))
 			L.Push(lua.LNil)
 			return 2
 		}))
@@ -556,25 +621,46 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 	L.PreloadModule("internal.session", loadSessionMod)
 	L.PreloadModule("internal.log", loadLogMod)
 	L.PreloadModule("internal.net", loadNetMod)
+	L.PreloadModule("internal.json", loadJSONMod(llog, fmt.Sprint(seed)))
 	L.PreloadModule("internal.database.sqlite", loadDBMod(llog, fmt.Sprint(seed)))
 	L.PreloadModule("internal.crypt.bcrypt", loadCryptbcryptMod)
 	L.PreloadModule("internal.crypt.sha256", loadCryptbsha256Mod)
 	L.PreloadModule("internal.crypt.jwt", loadJWTMod(llog, fmt.Sprint(seed)))
+	for name, funcs := range map[string]map[string]lua.LGFunction{
+		"crypt.hmac":      hmacFuncs,
+		"crypt.random":    randomFuncs,
+		"crypt.aes":       aesFuncs,
+		"crypt.ed25519":   ed25519Funcs,
+		"crypt.argon2":    h.argon2.funcs(),
+		"encoding.base64": base64Funcs,
+		"encoding.hex":    hexFuncs,
+	} {
+		L.PreloadModule("internal."+name, newModLoader(llog, name, fmt.Sprint(seed), funcs))
+	}
 
 	llog.Debug("preparing environment")
 	prep := filepath.Join(*h.x.Config.Conf.Node.ComDir, "_prepare.lua")
-	if _, err := os.Stat(prep); err == nil {
-		if err := L.DoFile(prep); err != nil {
-			llog.Error("script error", slog.String("script", path), slog.String("error", err.Error()))
-			return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	if proto, err := h.protos.Get(prep); err == nil {
+		if err := luaengine.DoProto(L, proto); err != nil {
+			exhausted = luaengine.IsStackOverflow(err)
+			return scriptFailure(execCtx, llog, prep, err, req.ID)
 		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		llog.Error("script error", slog.String("script", prep), slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
 	}
 	llog.Debug("executing script", slog.String("script", path))
-	err := L.DoFile(path)
-	if err != nil && __exit != 0 && __exit != 1 {
+	proto, err := h.protos.Get(path)
+	if err != nil {
 		llog.Error("script error", slog.String("script", path), slog.String("error", err.Error()))
 		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
 	}
+	err = luaengine.DoProto(L, proto)
+	if err != nil && __exit != 0 && __exit != 1 {
+		exhausted = luaengine.IsStackOverflow(err)
+		return scriptFailure(execCtx, llog, path, err, req.ID)
+	}
+	llog.Debug("script finished", slog.String("script", path), slog.Int64("instructions", execCtx.Instructions()))
 
 	pkg := L.GetGlobal("package")
 	pkgTbl, ok := pkg.(*lua.LTable)
@@ -622,15 +708,47 @@ func (h *HandlerV1) handleLUA(sid string, r *http.Request, req *rpc.RPCRequest,
 				if msg := errTbl.RawGetString("message"); msg.Type() == lua.LTString {
 					message = msg.String()
 				}
-				data := ConvertLuaTypesToGolang(errTbl.RawGetString("data"))
+				data, err := LuaToGo(errTbl.RawGetString("data"))
+				if err != nil {
+					llog.Error("script error", slog.String("script", path), slog.String("error", "cannot convert the error data: "+err.Error()))
+					return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+				}
 				llog.Error("the script terminated with an error", slog.Int("code", code), slog.String("message", message), slog.Any("data", data))
 				return rpc.NewError(code, message, data, req.ID)
 			}
 			return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
 		case 0:
-			resVal := ConvertLuaTypesToGolang(scriptDataTable.RawGetString("result"))
+			resVal, err := LuaToGo(scriptDataTable.RawGetString("result"))
+			if err != nil {
+				llog.Error("script error", slog.String("script", path), slog.String("error", "cannot convert the result: "+err.Error()))
+				return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+			}
 			return rpc.NewResponse(resVal, req.ID)
 		}
 	}
 	return rpc.NewResponse(nil, req.ID)
 }
+
+// scriptFailure turns the reason a script stopped into a JSON-RPC error
+func scriptFailure(ctx context.Context, llog *slog.Logger, path string, err error, id *json.RawMessage) *rpc.RPCResponse {
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, context.DeadlineExceeded):
+		llog.Error("script execution timed out", slog.String("script", path))
+		return rpc.NewError(rpc.ErrTimeout, rpc.ErrTimeoutS, nil, id)
+	case errors.Is(cause, luaengine.ErrInstructionLimit):
+		llog.Error("script exceeded its instruction limit", slog.String("script", path))
+		return rpc.NewError(rpc.ErrInstructionLimit, rpc.ErrInstructionLimitS, nil, id)
+	case errors.Is(cause, luaengine.ErrMemoryLimit):
+		llog.Error("script exceeded its memory limit", slog.String("script", path))
+		return rpc.NewError(rpc.ErrMemoryLimit, rpc.ErrMemoryLimitS, nil, id)
+	case errors.Is(cause, context.Canceled):
+		llog.Info("script canceled, the client has gone away", slog.String("script", path))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, id)
+	}
+	if luaengine.IsStackOverflow(err) {
+		llog.Error("script exceeded its stack size", slog.String("script", path), slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrMemoryLimit, rpc.ErrMemoryLimitS, nil, id)
+	}
+	llog.Error("script error", slog.String("script", path), slog.String("error", err.Error()))
+	return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, id)
+}
@@ -0,0 +1,184 @@
+package sv1
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// newTestHandler returns a handler serving the repository's com directory
+func newTestHandler(tb testing.TB) *HandlerV1 {
+	tb.Helper()
+	return newTestHandlerIn(tb, "../../../../com")
+}
+
+func newTestHandlerIn(tb testing.TB, comDir string) *HandlerV1 {
+	tb.Helper()
+	poolSize := 4
+	x := &app.AppX{
+		Log:  log.New(io.Discard, "", 0),
+		SLog: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Config: &config.Compositor{Conf: &config.Conf{
+			Node: &config.Node{ComDir: &comDir},
+			Lua:  &config.Lua{PoolSize: &poolSize},
+		}},
+	}
+	return InitV1Server(&HandlerV1InitStruct{
+		X:          x,
+		CS:         &corestate.CoreState{},
+		AllowedCmd: regexp.MustCompile(`^[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*$`),
+		Ver:        "v1",
+	})
+}
+
+func BenchmarkHandleLUA_Echo(b *testing.B) {
+	id := json.RawMessage("1")
+	req := &rpc.RPCRequest{
+		JSONRPC: rpc.JSONRPCVersion,
+		ID:      &id,
+		Method:  "Echo",
+		Params:  map[string]any{"data": "Hi!!"},
+	}
+	r := httptest.NewRequest("POST", "/com", nil)
+
+	for _, bb := range []struct {
+		name    string
+		maxIdle int
+	}{
+		// every request builds and sandboxes a new state, as before pooling
+		{"fresh", 0},
+		{"pooled", 4},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			h := newTestHandler(b)
+			h.pool = luaengine.NewLuaPool(&luaengine.LuaPoolInit{
+				Size:    4,
+				MaxIdle: bb.maxIdle,
+				Prepare: sandbox,
+			})
+			defer h.Shutdown()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				resp := h.Handle(context.Background(), "bench", r, req)
+				if resp.Error != nil {
+					b.Fatalf("unexpected error: %v", resp.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleLUA_Limits(t *testing.T) {
+	code := func(resp *rpc.RPCResponse) any {
+		e, _ := resp.Error.(map[string]any)
+		return e["code"]
+	}
+	newHandler := func(tb testing.TB, script string, lua *config.Lua) *HandlerV1 {
+		comDir := tb.TempDir()
+		if err := os.WriteFile(filepath.Join(comDir, "Test.lua"), []byte(script), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		h := newTestHandlerIn(tb, comDir)
+		poolSize := 1
+		lua.PoolSize = &poolSize
+		h.x.Config.Conf.Lua = lua
+		tb.Cleanup(h.Shutdown)
+		return h
+	}
+	call := func(h *HandlerV1) *rpc.RPCResponse {
+		id := json.RawMessage("1")
+		return h.Handle(context.Background(), "test", httptest.NewRequest("POST", "/com", nil), &rpc.RPCRequest{
+			JSONRPC: rpc.JSONRPCVersion,
+			ID:      &id,
+			Method:  "Test",
+		})
+	}
+	instructions, memory, timeout := int64(10000), int64(4<<20), 50*time.Millisecond
+
+	tests := []struct {
+		name   string
+		script string
+		lua    *config.Lua
+		want   int
+	}{
+		{"instructions", `while true do end`, &config.Lua{InstructionLimit: &instructions}, rpc.ErrInstructionLimit},
+		{"timeout", `while true do end`, &config.Lua{Timeout: &timeout}, rpc.ErrTimeout},
+		{"string.rep", `local s = string.rep("x", 1e9)`, &config.Lua{MemoryLimit: &memory}, rpc.ErrMemoryLimit},
+		{"concat", `local s = "x" while true do s = s .. s end`, &config.Lua{MemoryLimit: &memory}, rpc.ErrMemoryLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := call(newHandler(t, tt.script, tt.lua))
+			if code(resp) != tt.want {
+				t.Errorf("error = %+v, want %d", resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleLUA_PoolWait(t *testing.T) {
+	comDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(comDir, "Test.lua"), []byte(`require("internal.session").response.send(1)`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandlerIn(t, comDir)
+	h.pool = luaengine.NewLuaPool(&luaengine.LuaPoolInit{Size: 1, MaxIdle: 1, Prepare: sandbox})
+	t.Cleanup(h.Shutdown)
+	timeout := 100 * time.Millisecond
+	h.x.Config.Conf.Lua.Timeout = &timeout
+	call := func() *rpc.RPCResponse {
+		id := json.RawMessage("1")
+		return h.Handle(context.Background(), "test", httptest.NewRequest("POST", "/com", nil), &rpc.RPCRequest{
+			JSONRPC: rpc.JSONRPCVersion,
+			ID:      &id,
+			Method:  "Test",
+		})
+	}
+
+	busy, err := h.pool.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every state stays busy for longer than the timeout
+	resp := call()
+	if e, _ := resp.Error.(map[string]any); e["code"] != rpc.ErrTimeout {
+		t.Errorf("error = %+v, want %d", resp.Error, rpc.ErrTimeout)
+	}
+
+	// the state is freed before the timeout, the script still gets all of it
+	time.AfterFunc(60*time.Millisecond, func() { h.pool.Put(busy) })
+	if resp := call(); resp.Error != nil {
+		t.Errorf("error = %+v after the state was freed", resp.Error)
+	}
+}
+
+func TestHandleLUA_LargeIntegerParams(t *testing.T) {
+	// 2^53+1 has no float64, the script gets its digits
+	req, err := rpc.ParseRequest([]byte(`{"jsonrpc": "2.0", "id": 1, "method": "Test", "params": {"n": 9007199254740993, "small": 42}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := runScript(t, `local s = require("internal.session")
+local n, small = s.request.params.get("n"), s.request.params.get("small")
+s.response.send({n = n, n_type = type(n), small_type = type(small)})
+`, req.Params.(map[string]any))
+	result, _ := resp.Result.(map[string]any)
+	if resp.Error != nil || result["n"] != "9007199254740993" || result["n_type"] != "string" || result["small_type"] != "number" {
+		t.Errorf("got %+v %+v", resp.Result, resp.Error)
+	}
+}
@@ -1,126 +1,475 @@
 package sv1
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
+	"unsafe"
 
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 	lua "github.com/yuin/gopher-lua"
 )
 
+// Values cross between Lua and Go by these rules:
+//
+//   - a Lua number without a fraction becomes a json.Number holding the integer,
+//     so it is written without an exponent, other numbers become float64
+//   - a table marked by json.array or json.object becomes a slice or a map
+//   - an unmarked table whose keys are all indexes 1..n becomes a slice, unless
+//     it is sparse, holes become nil. Any other table, the empty one too, is a map
+//   - json.null becomes nil
+//   - Go slices and maps become tables marked as arrays and objects, so they
+//     come back as they were; nil in a slice becomes json.null
+//   - integers a Lua number cannot hold exactly become strings of digits
+//   - structs and values with a MarshalJSON method go through encoding/json
+//
+// Cycles, nesting deeper than ConvertMaxDepth and values larger than
+// ConvertMaxSize are errors.
+
+// ConvertMaxDepth is the deepest nesting of tables converted
+var ConvertMaxDepth = 100
+
+// ConvertMaxSize caps the size of a Lua value converted to Go, roughly its
+// size as JSON. A table referenced many times is converted every time,
+// without the cap a few small tables could expand into gigabytes.
+var ConvertMaxSize int64 = 64 << 20
+
+// valueSize is what a converted value costs on top of its strings
+const valueSize = 16
+
+// A table of indexes is sparse when its largest index is over sparseRatio times
+// the number of its keys and over sparseSafe
+const (
+	sparseRatio = 2
+	sparseSafe  = 10
+)
+
+// maxSafeInteger is the largest integer a Lua number holds exactly
+const maxSafeInteger = 1 << 53
+
+var (
+	errConvertCycle    = errors.New("cannot convert a value that contains itself")
+	errConvertTooDeep  = errors.New("value is nested too deep")
+	errConvertTooLarge = errors.New("value is too large")
+)
+
+// jsonNull is the value of the json.null userdata, it converts to nil
+type jsonNull struct{}
+
+// json.array and json.object mark a table with a metatable
+// holding the kind of the table in this field
+const (
+	jsonTypeField = "__jsontype"
+	jsonArray     = "array"
+	jsonObject    = "object"
+)
+
+// The registry keeps json.null and the markers of a state
+const (
+	registryJSONNull   = "gosally.json.null"
+	registryJSONArray  = "gosally.json." + jsonArray
+	registryJSONObject = "gosally.json." + jsonObject
+)
+
+// jsonNullValue returns json.null of the state
+func jsonNullValue(L *lua.LState) *lua.LUserData {
+	if null, ok := L.G.Registry.RawGetString(registryJSONNull).(*lua.LUserData); ok {
+		return null
+	}
+	null := L.NewUserData()
+	null.Value = jsonNull{}
+	meta := L.NewTable()
+	meta.RawSetString("__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString("null"))
+		return 1
+	}))
+	null.Metatable = meta
+	L.G.Registry.RawSetString(registryJSONNull, null)
+	return null
+}
+
+// jsonMeta returns the metatable marking the tables of the kind in the state
+func jsonMeta(L *lua.LState, kind string) *lua.LTable {
+	key := "gosally.json." + kind
+	if meta, ok := L.G.Registry.RawGetString(key).(*lua.LTable); ok {
+		return meta
+	}
+	meta := L.NewTable()
+	meta.RawSetString(jsonTypeField, lua.LString(kind))
+	L.G.Registry.RawSetString(key, meta)
+	return meta
+}
+
+// resetJSONValues drops json.null and the markers of the state, whatever
+// a script did to their metatables does not reach the next script
+func resetJSONValues(L *lua.LState) {
+	for _, key := range []string{registryJSONNull, registryJSONArray, registryJSONObject} {
+		L.G.Registry.RawSetString(key, lua.LNil)
+	}
+}
+
+// jsonType returns the kind a table was marked with, if any
+func jsonType(tbl *lua.LTable) string {
+	meta, ok := tbl.Metatable.(*lua.LTable)
+	if !ok {
+		return ""
+	}
+	kind, _ := meta.RawGetString(jsonTypeField).(lua.LString)
+	return string(kind)
+}
+
+// LuaToGo converts a Lua value into nil, bool, string, json.Number, float64,
+// []any or map[string]any
+func LuaToGo(value lua.LValue) (any, error) {
+	v, _, err := luaToGo(value)
+	return v, err
+}
+
+// convertSize is what it took to convert a value: the bytes of its strings
+// plus valueSize for every value, and the sum of the depths of the values
+type convertSize struct {
+	bytes, depths int64
+}
+
+// json returns how large the value is as JSON indented with indent, roughly
+func (s convertSize) json(indent string) int64 {
+	if indent == "" {
+		return s.bytes
+	}
+	return s.bytes + s.depths*int64(len(indent))
+}
+
+// luaToGo is LuaToGo telling how large the result is
+func luaToGo(value lua.LValue) (any, convertSize, error) {
+	c := &luaConverter{seen: make(map[*lua.LTable]struct{})}
+	v, err := c.convert(value, 0)
+	return v, c.size, err
+}
+
+// ConvertLuaTypesToGolang is LuaToGo for values that cannot fail to convert,
+// it returns nil for a value that does
 func ConvertLuaTypesToGolang(value lua.LValue) any {
-	switch value.Type() {
-	case lua.LTString:
-		return value.String()
-	case lua.LTNumber:
-		return float64(value.(lua.LNumber))
-	case lua.LTBool:
-		return bool(value.(lua.LBool))
-	case lua.LTTable:
-		tbl := value.(*lua.LTable)
-
-		maxIdx := 0
-		isArray := true
-
-		var isNumeric = false
-		tbl.ForEach(func(key, _ lua.LValue) {
-			var numKey lua.LValue
-			var ok bool
-			switch key.Type() {
-			case lua.LTString:
-				numKey, ok = key.(lua.LString)
-				if !ok {
-					isArray = false
-					return
-				}
-			case lua.LTNumber:
-				numKey, ok = key.(lua.LNumber)
-				if !ok {
-					isArray = false
-					return
-				}
-				isNumeric = true
-			}
+	v, err := LuaToGo(value)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+type luaConverter struct {
+	// seen holds the tables being converted, the ones above the current value
+	seen map[*lua.LTable]struct{}
+	size convertSize
+}
 
-			num, err := strconv.Atoi(numKey.String())
+// count adds a value of n bytes at the depth to the size
+func (c *luaConverter) count(n, depth int) error {
+	c.size.bytes += int64(n) + valueSize
+	c.size.depths += int64(depth)
+	if c.size.bytes > ConvertMaxSize {
+		return fmt.Errorf("%w, the limit is %d bytes", errConvertTooLarge, ConvertMaxSize)
+	}
+	return nil
+}
+
+func (c *luaConverter) convert(value lua.LValue, depth int) (any, error) {
+	var n int
+	if s, ok := value.(lua.LString); ok {
+		n = len(s)
+	}
+	if err := c.count(n, depth); err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		return luaNumber(v), nil
+	case *lua.LTable:
+		return c.table(v, depth)
+	case *lua.LUserData:
+		if _, ok := v.Value.(jsonNull); ok {
+			return nil, nil
+		}
+	}
+	// functions, coroutines and userdata are only described
+	return value.String(), nil
+}
+
+func luaNumber(n lua.LNumber) any {
+	f := float64(n)
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return json.Number(strconv.FormatInt(int64(f), 10))
+	}
+	return f
+}
+
+func (c *luaConverter) table(tbl *lua.LTable, depth int) (any, error) {
+	if depth >= ConvertMaxDepth {
+		return nil, fmt.Errorf("%w, the limit is %d", errConvertTooDeep, ConvertMaxDepth)
+	}
+	if _, ok := c.seen[tbl]; ok {
+		return nil, errConvertCycle
+	}
+	c.seen[tbl] = struct{}{}
+	defer delete(c.seen, tbl)
+
+	n, count, indexes := arrayShape(tbl)
+	sparse := n > sparseSafe && n > count*sparseRatio
+	kind := jsonType(tbl)
+	switch {
+	case kind == jsonArray && !indexes:
+		return nil, errors.New("a table marked as an array has keys other than indexes")
+	case kind == jsonArray && sparse:
+		return nil, fmt.Errorf("a table marked as an array is too sparse, %d keys up to index %d", count, n)
+	case kind == "" && count > 0 && indexes && !sparse:
+		kind = jsonArray
+	}
+
+	if kind == jsonArray {
+		arr := make([]any, n)
+		for i := range arr {
+			item, err := c.convert(tbl.RawGetInt(i+1), depth+1)
 			if err != nil {
-				isArray = false
-				return
-			}
-			if num < 1 {
-				isArray = false
-				return
+				return nil, err
 			}
-			if num > maxIdx {
-				maxIdx = num
-			}
-		})
-
-		if isArray {
-			arr := make([]any, maxIdx)
-			if isNumeric {
-				for i := 1; i <= maxIdx; i++ {
-					arr[i-1] = ConvertLuaTypesToGolang(tbl.RawGetInt(i))
-				}
-			} else {
-				for i := 1; i <= maxIdx; i++ {
-					arr[i-1] = ConvertLuaTypesToGolang(tbl.RawGetString(strconv.Itoa(i)))
-				}
-			}
-			return arr
+			arr[i] = item
 		}
-		result := make(map[string]any)
-		tbl.ForEach(func(key, val lua.LValue) {
-			result[key.String()] = ConvertLuaTypesToGolang(val)
-		})
-		return result
+		return arr, nil
+	}
 
-	case lua.LTNil:
-		return nil
-	default:
-		return value.String()
+	obj := make(map[string]any, count)
+	var err error
+	tbl.ForEach(func(k, v lua.LValue) {
+		if err != nil {
+			return
+		}
+		var key string
+		if key, err = luaKey(k); err != nil {
+			return
+		}
+		if err = c.count(len(key), 0); err != nil {
+			return
+		}
+		obj[key], err = c.convert(v, depth+1)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return obj, nil
+}
+
+// arrayShape returns the largest index and the number of keys of the table,
+// and whether every key is an index, a positive integer
+func arrayShape(tbl *lua.LTable) (n, count int, indexes bool) {
+	indexes = true
+	tbl.ForEach(func(k, _ lua.LValue) {
+		count++
+		num, ok := k.(lua.LNumber)
+		if !ok || float64(num) != math.Trunc(float64(num)) || num < 1 || num > math.MaxInt32 {
+			indexes = false
+			return
+		}
+		n = max(n, int(num))
+	})
+	return n, count, indexes
+}
+
+func luaKey(k lua.LValue) (string, error) {
+	switch k := k.(type) {
+	case lua.LString:
+		return string(k), nil
+	case lua.LNumber:
+		return fmt.Sprint(luaNumber(k)), nil
+	case lua.LBool:
+		return k.String(), nil
+	}
+	return "", fmt.Errorf("a table key cannot be a %s", k.Type())
+}
+
+// GoToLua converts a Go value into a Lua value, nil in a map leaves the key out
+func GoToLua(L *lua.LState, value any) (lua.LValue, error) {
+	c := &goConverter{L: L, seen: make(map[goRef]struct{})}
+	return c.convert(value, 0)
 }
 
+// ConvertGolangTypesToLua is GoToLua for values that cannot fail to convert,
+// it returns nil for a value that does
 func ConvertGolangTypesToLua(L *lua.LState, val any) lua.LValue {
-	if val == nil {
+	v, err := GoToLua(L, val)
+	if err != nil {
 		return lua.LNil
 	}
+	return v
+}
+
+type goConverter struct {
+	L *lua.LState
+	// keepNull turns nil in a map into json.null instead of leaving the key out
+	keepNull bool
+	// seen holds the maps, slices and pointers above the current value
+	seen map[goRef]struct{}
+}
 
-	rv := reflect.ValueOf(val)
-	rt := rv.Type()
+// goRef tells a container apart, a slice by its length as well
+type goRef struct {
+	ptr unsafe.Pointer
+	typ reflect.Type
+	len int
+}
+
+var jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+
+func (c *goConverter) convert(value any, depth int) (lua.LValue, error) {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil, nil
+	case json.Number:
+		return luaInteger(v), nil
+	case []byte:
+		return lua.LString(v), nil
+	}
 
-	switch rt.Kind() {
+	rv := reflect.ValueOf(value)
+	if rv.Type().Implements(jsonMarshaler) || rv.Kind() == reflect.Struct {
+		return c.viaJSON(value, depth)
+	}
+	switch rv.Kind() {
 	case reflect.String:
-		return lua.LString(rv.String())
+		return lua.LString(rv.String()), nil
 	case reflect.Bool:
-		return lua.LBool(rv.Bool())
+		return lua.LBool(rv.Bool()), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return lua.LNumber(rv.Int())
+		return luaInteger(json.Number(strconv.FormatInt(rv.Int(), 10))), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return lua.LNumber(rv.Uint())
+		return luaInteger(json.Number(strconv.FormatUint(rv.Uint(), 10))), nil
 	case reflect.Float32, reflect.Float64:
-		return lua.LNumber(rv.Float())
+		return lua.LNumber(rv.Float()), nil
+
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return lua.LNil, nil
+		}
+		if rv.Kind() == reflect.Pointer {
+			release, err := c.enter(goRef{ptr: rv.UnsafePointer(), typ: rv.Type()}, depth)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		}
+		return c.convert(rv.Elem().Interface(), depth)
 
 	case reflect.Slice, reflect.Array:
-		tbl := L.NewTable()
+		if rv.Kind() == reflect.Slice && !rv.IsNil() {
+			release, err := c.enter(goRef{ptr: rv.UnsafePointer(), typ: rv.Type(), len: rv.Len()}, depth)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		} else if depth >= ConvertMaxDepth {
+			return nil, fmt.Errorf("%w, the limit is %d", errConvertTooDeep, ConvertMaxDepth)
+		}
+		tbl := c.L.CreateTable(rv.Len(), 0)
 		for i := 0; i < rv.Len(); i++ {
-			tbl.RawSetInt(i+1, ConvertGolangTypesToLua(L, rv.Index(i).Interface()))
+			item, err := c.convert(rv.Index(i).Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if item == lua.LNil {
+				item = jsonNullValue(c.L)
+			}
+			tbl.RawSetInt(i+1, item)
 		}
-		return tbl
+		tbl.Metatable = jsonMeta(c.L, jsonArray)
+		return tbl, nil
 
 	case reflect.Map:
-		if rt.Key().Kind() == reflect.String {
-			tbl := L.NewTable()
-			for _, key := range rv.MapKeys() {
-				val := rv.MapIndex(key)
-				tbl.RawSetString(key.String(), ConvertGolangTypesToLua(L, val.Interface()))
+		if !rv.IsNil() {
+			release, err := c.enter(goRef{ptr: rv.UnsafePointer(), typ: rv.Type()}, depth)
+			if err != nil {
+				return nil, err
 			}
-			return tbl
+			defer release()
 		}
+		tbl := c.L.CreateTable(0, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := c.convert(iter.Key().Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := key.(*lua.LTable); ok || key == lua.LNil {
+				return nil, fmt.Errorf("a map key cannot be %v", iter.Key().Interface())
+			}
+			item, err := c.convert(iter.Value().Interface(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if item == lua.LNil && c.keepNull {
+				item = jsonNullValue(c.L)
+			}
+			tbl.RawSet(key, item)
+		}
+		tbl.Metatable = jsonMeta(c.L, jsonObject)
+		return tbl, nil
+	}
+	return nil, fmt.Errorf("cannot convert a value of type %T", value)
+}
 
-	default:
-		return lua.LString(fmt.Sprintf("%v", val))
+// enter notes the container until release is called and fails if it is
+// already being converted or the nesting is too deep
+func (c *goConverter) enter(ref goRef, depth int) (release func(), err error) {
+	if depth >= ConvertMaxDepth {
+		return nil, fmt.Errorf("%w, the limit is %d", errConvertTooDeep, ConvertMaxDepth)
+	}
+	if _, ok := c.seen[ref]; ok {
+		return nil, errConvertCycle
+	}
+	c.seen[ref] = struct{}{}
+	return func() { delete(c.seen, ref) }, nil
+}
+
+// viaJSON converts a struct the way encoding/json would send it
+func (c *goConverter) viaJSON(value any, depth int) (lua.LValue, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := rpc.DecodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.convert(decoded, depth)
+}
+
+// luaInteger returns the number, or the digits of an integer
+// a Lua number cannot hold exactly
+func luaInteger(n json.Number) lua.LValue {
+	if i, err := n.Int64(); err == nil {
+		if i >= -maxSafeInteger && i <= maxSafeInteger {
+			return lua.LNumber(i)
+		}
+		return lua.LString(n)
+	}
+	if f, err := n.Float64(); err == nil && !isInteger(n) {
+		return lua.LNumber(f)
+	}
+	return lua.LString(n)
+}
+
+// isInteger reports whether the number is written as an integer
+func isInteger(n json.Number) bool {
+	for i := 0; i < len(n); i++ {
+		if c := n[i]; c == '.' || c == 'e' || c == 'E' {
+			return false
+		}
 	}
-	return lua.LString(fmt.Sprintf("%v", val))
+	return true
 }
@@ -0,0 +1,235 @@
+package sv1
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// newTypesState returns a state with the globals null, array and object
+// standing for json.null, json.array and json.object
+func newTypesState(tb testing.TB) *lua.LState {
+	tb.Helper()
+	L := lua.NewState()
+	tb.Cleanup(L.Close)
+	L.SetGlobal("null", jsonNullValue(L))
+	for _, kind := range []string{jsonArray, jsonObject} {
+		meta := jsonMeta(L, kind)
+		L.SetGlobal(kind, L.NewFunction(func(L *lua.LState) int {
+			tbl := L.OptTable(1, L.NewTable())
+			tbl.Metatable = meta
+			L.Push(tbl)
+			return 1
+		}))
+	}
+	return L
+}
+
+// eval runs the chunk and returns its value
+func eval(tb testing.TB, L *lua.LState, chunk string) lua.LValue {
+	tb.Helper()
+	if err := L.DoString(chunk); err != nil {
+		tb.Fatalf("%s: %v", chunk, err)
+	}
+	defer L.Pop(1)
+	return L.Get(-1)
+}
+
+func TestLuaToGo(t *testing.T) {
+	L := newTypesState(t)
+	n := func(s string) json.Number { return json.Number(s) }
+
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{`nil`, nil},
+		{`true`, true},
+		{`"text"`, "text"},
+		{`42`, n("42")},
+		{`-7`, n("-7")},
+		{`1.5`, 1.5},
+		{`2^53 + 2`, n("9007199254740994")},
+		{`1e300`, 1e300},
+		{`{}`, map[string]any{}},
+		{`{1, "a", false}`, []any{n("1"), "a", false}},
+		{`{["1"] = "a", ["2"] = "b"}`, map[string]any{"1": "a", "2": "b"}},
+		{`{[1] = "a", [3] = "c"}`, []any{"a", nil, "c"}},
+		{`{[1] = "a", [100] = "b"}`, map[string]any{"1": "a", "100": "b"}},
+		{`{1, 2, x = 3}`, map[string]any{"1": n("1"), "2": n("2"), "x": n("3")}},
+		{`{[1.5] = "x", [true] = "y"}`, map[string]any{"1.5": "x", "true": "y"}},
+		{`array({})`, []any{}},
+		{`array({[1] = 1, [3] = 3})`, []any{n("1"), nil, n("3")}},
+		{`object({})`, map[string]any{}},
+		{`object({"a"})`, map[string]any{"1": "a"}},
+		{`{null, 2}`, []any{nil, n("2")}},
+		{`{a = null}`, map[string]any{"a": nil}},
+		{`{list = {}, items = array()}`, map[string]any{"list": map[string]any{}, "items": []any{}}},
+		{`local shared = {1}; return {a = shared, b = shared}`, map[string]any{"a": []any{n("1")}, "b": []any{n("1")}}},
+	}
+	for _, tt := range tests {
+		chunk := tt.expr
+		if !strings.HasPrefix(chunk, "local") {
+			chunk = "return " + chunk
+		}
+		got, err := LuaToGo(eval(t, L, chunk))
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, %v; want %#v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestLuaToGo_Errors(t *testing.T) {
+	L := newTypesState(t)
+	tests := []struct {
+		chunk string
+		err   error
+	}{
+		{`local t = {}; t.self = t; return t`, errConvertCycle},
+		{`local t = {}; t[1] = {t}; return t`, errConvertCycle},
+		{`local t = {}; for i = 1, 200 do t = {t} end; return t`, errConvertTooDeep},
+		{`local t = {string.rep("x", 1000)}; for i = 1, 30 do t = {t, t} end; return t`, errConvertTooLarge},
+		{`return {[{}] = 1}`, nil},
+		{`return array({x = 1})`, nil},
+		{`return array({[1000] = 1})`, nil},
+	}
+	for _, tt := range tests {
+		got, err := LuaToGo(eval(t, L, tt.chunk))
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+			t.Errorf("%s = %#v, %v; want %v", tt.chunk, got, err, tt.err)
+		}
+		if ConvertLuaTypesToGolang(eval(t, L, tt.chunk)) != nil {
+			t.Errorf("%s: ConvertLuaTypesToGolang is not nil", tt.chunk)
+		}
+	}
+
+	// just below the limit still converts
+	if _, err := LuaToGo(eval(t, L, `local t = {}; for i = 1, 99 do t = {t} end; return t`)); err != nil {
+		t.Error(err)
+	}
+}
+
+type testUnit struct {
+	Name   string `json:"name"`
+	Hidden string `json:"-"`
+	Ports  []int  `json:"ports,omitempty"`
+}
+
+func TestGoToLua(t *testing.T) {
+	L := newTypesState(t)
+	port := 8080
+	tests := []struct {
+		value any
+		// check is evaluated with the converted value in v
+		check string
+	}{
+		{nil, `v == nil`},
+		{"text", `v == "text"`},
+		{int8(-3), `v == -3`},
+		{json.Number("42"), `v == 42`},
+		{json.Number("1.5"), `v == 1.5`},
+		{json.Number("1e3"), `v == 1000`},
+		{int64(1) << 60, `v == "1152921504606846976"`},
+		{uint64(math.MaxUint64), `v == "18446744073709551615"`},
+		{json.Number("12345678901234567890"), `v == "12345678901234567890"`},
+		{int64(maxSafeInteger), `v == 2^53`},
+		{[]byte("raw"), `v == "raw"`},
+		{&port, `v == 8080`},
+		{(*int)(nil), `v == nil`},
+		{[]string{}, `getmetatable(v).__jsontype == "array" and #v == 0`},
+		{[]string(nil), `getmetatable(v).__jsontype == "array" and #v == 0`},
+		{map[string]any{}, `getmetatable(v).__jsontype == "object" and next(v) == nil`},
+		{[]any{1, nil, 3}, `#v == 3 and v[2] == null and v[3] == 3`},
+		{map[string]any{"a": nil, "b": 1}, `v.a == nil and v.b == 1`},
+		{map[any]any{1: "x", "k": true}, `v[1] == "x" and v.k == true`},
+		{map[int]string{2: "b"}, `v[2] == "b"`},
+		{[2]int{1, 2}, `v[1] == 1 and v[2] == 2`},
+		{[][]int{{1, 2}}, `v[1][2] == 2`},
+		{testUnit{Name: "unit", Hidden: "x"}, `v.name == "unit" and v.Hidden == nil and v.ports == nil`},
+		{time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), `v == "2026-01-02T03:04:05Z"`},
+		{json.RawMessage(`{"a":[1]}`), `v.a[1] == 1`},
+	}
+	for _, tt := range tests {
+		got, err := GoToLua(L, tt.value)
+		if err != nil {
+			t.Errorf("%#v: %v", tt.value, err)
+			continue
+		}
+		L.SetGlobal("v", got)
+		if ok := eval(t, L, "return "+tt.check); ok != lua.LTrue {
+			t.Errorf("%#v: %s is false", tt.value, tt.check)
+		}
+	}
+}
+
+func TestGoToLua_Errors(t *testing.T) {
+	L := newTypesState(t)
+	cyclicMap := map[string]any{}
+	cyclicMap["self"] = cyclicMap
+	cyclicSlice := make([]any, 1)
+	cyclicSlice[0] = cyclicSlice
+	deep := any("leaf")
+	for range 200 {
+		deep = []any{deep}
+	}
+
+	tests := []struct {
+		value any
+		err   error
+	}{
+		{cyclicMap, errConvertCycle},
+		{cyclicSlice, errConvertCycle},
+		{deep, errConvertTooDeep},
+		{func() {}, nil},
+		{make(chan int), nil},
+		{map[string]any{"f": func() {}}, nil},
+	}
+	for _, tt := range tests {
+		got, err := GoToLua(L, tt.value)
+		if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+			t.Errorf("%T: %v, %v; want %v", tt.value, got, err, tt.err)
+		}
+		if ConvertGolangTypesToLua(L, tt.value) != lua.LNil {
+			t.Errorf("%T: ConvertGolangTypesToLua is not nil", tt.value)
+		}
+	}
+
+	// the same map twice is not a cycle
+	shared := map[string]any{"a": 1}
+	if _, err := GoToLua(L, []any{shared, shared}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConvert_RoundTrip(t *testing.T) {
+	L := newTypesState(t)
+	values := []any{
+		map[string]any{
+			"empty_array":  []any{},
+			"empty_object": map[string]any{},
+			"index_keys":   map[string]any{"1": "a", "2": "b"},
+			"holes":        []any{nil, nil, json.Number("3")},
+			"ints":         []any{json.Number("1"), json.Number("-9007199254740992")},
+			"float":        1.5,
+			"nested":       map[string]any{"deep": []any{map[string]any{}}},
+		},
+		[]any{},
+		map[string]any{},
+	}
+	for _, value := range values {
+		lv, err := GoToLua(L, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		back, err := LuaToGo(lv)
+		if err != nil || !reflect.DeepEqual(back, value) {
+			t.Errorf("%#v came back as %#v, %v", value, back, err)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package sv1
 
 import (
 	"errors"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 )
 
@@ -26,3 +29,47 @@ func (h *HandlerV1) resolveMethodPath(method string) (string, error) {
 
 	return fullPath, nil
 }
+
+// ListMethods walks the com directory and returns every script that
+// resolveMethodPath would serve
+func (h *HandlerV1) ListMethods() ([]metadata.Method, error) {
+	comDir := *h.x.Config.Conf.Node.ComDir
+	var methods []metadata.Method
+	err := filepath.WalkDir(comDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == comDir {
+				return err
+			}
+			// one unreadable entry does not hide the others
+			h.x.SLog.Warn("cannot list methods", slog.String("path", path), slog.String("error", err.Error()))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || filepath.Ext(path) != ".lua" {
+			return nil
+		}
+		rel, err := filepath.Rel(comDir, strings.TrimSuffix(path, ".lua"))
+		if err != nil {
+			return err
+		}
+		name := strings.Join(strings.Split(rel, string(filepath.Separator)), RPCMethodSeparator)
+		if resolved, err := h.resolveMethodPath(name); err != nil || resolved != path {
+			return nil
+		}
+		meta, err := metadata.Load(path)
+		if err != nil {
+			h.x.SLog.Warn("method is not listed, its metadata is invalid", slog.String("method", name), slog.String("error", err.Error()))
+			return nil
+		}
+		methods = append(methods, metadata.Method{
+			Name:           name,
+			ContextVersion: h.ver,
+			Metadata:       meta,
+			Path:           path,
+		})
+		return nil
+	})
+	return methods, err
+}
@@ -6,7 +6,11 @@ import (
 	"regexp"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	luaengine "github.com/akyaiy/GoSally-mvp/src/internal/engine/lua"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/egress"
+	lua "github.com/yuin/gopher-lua"
 )
 
 var SV1Version = "v1"
@@ -17,6 +21,9 @@ type HandlerV1InitStruct struct {
 	CS         *corestate.CoreState
 	X          *app.AppX
 	AllowedCmd *regexp.Regexp
+	// Egress restricts the HTTP requests of scripts, nil allows every destination
+	// and forwards the initiator headers to none
+	Egress *egress.Policy
 }
 
 // HandlerV1 implements the ServerV1UtilsContract and serves as the main handler for API requests.
@@ -27,6 +34,15 @@ type HandlerV1 struct {
 	// allowedCmd and listAllowedCmd are regular expressions used to validate command names.
 	allowedCmd *regexp.Regexp
 
+	// pool holds sandboxed Lua states between requests
+	pool *luaengine.LuaPool
+	// protos holds compiled scripts of the com directory
+	protos *luaengine.ProtoCache
+	// egress decides where net.http may connect and holds its transports
+	egress *egress.Policy
+	// argon2 bounds the memory and concurrency of internal.crypt.argon2
+	argon2 *argon2Limiter
+
 	ver string
 }
 
@@ -34,14 +50,53 @@ type HandlerV1 struct {
 // Should be carefull with giving to this function invalid parameters,
 // because there is no validation of parameters in this function.
 func InitV1Server(o *HandlerV1InitStruct) *HandlerV1 {
+	conf := o.X.Config.Conf.Lua
+	poolSize := utils.SafeFetch(conf.PoolSize, 16)
+	policy := o.Egress
+	if policy == nil {
+		policy, _ = egress.New(&egress.Init{})
+	}
 	return &HandlerV1{
 		cs:         o.CS,
 		x:          o.X,
 		allowedCmd: o.AllowedCmd,
-		ver:        o.Ver,
+		pool: luaengine.NewLuaPool(&luaengine.LuaPoolInit{
+			Size:    poolSize,
+			MaxIdle: poolSize,
+			Prepare: sandbox,
+			Options: lua.Options{
+				RegistrySize:    utils.SafeFetch(conf.RegistrySize, 1024*20),
+				RegistryMaxSize: utils.SafeFetch(conf.RegistryMaxSize, 1024*80),
+				CallStackSize:   utils.SafeFetch(conf.CallStackSize, 256),
+			},
+		}),
+		protos: luaengine.NewProtoCache(),
+		egress: policy,
+		argon2: newArgon2Limiter(conf.Argon2),
+		ver:    o.Ver,
 	}
 }
 
+// Precompile compiles every script of the com directory, so syntax errors
+// are reported before the node starts serving. It returns the number of scripts.
+func (h *HandlerV1) Precompile() (int, error) {
+	return h.protos.Precompile(*h.x.Config.Conf.Node.ComDir)
+}
+
+// Reload drops every compiled script and compiles the com directory again,
+// so broken scripts are reported right after they are changed
+func (h *HandlerV1) Reload(_ []string) error {
+	h.protos.Purge()
+	_, err := h.Precompile()
+	return err
+}
+
+// Shutdown closes the idle Lua states and HTTP connections
+func (h *HandlerV1) Shutdown() {
+	h.pool.Close()
+	h.egress.CloseIdleConnections()
+}
+
 // GetVersion returns the API version of the HandlerV1, which is set during initialization.
 // This version is used to identify the API version in the request routing.
 func (h *HandlerV1) GetVersion() string {
@@ -2,11 +2,60 @@ package sv2
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
 	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
 )
 
-func (h *Handler) Handle(_ context.Context, sid string, r *http.Request, req *rpc.RPCRequest) *rpc.RPCResponse {
-	return nil
+func (h *Handler) Handle(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest) *rpc.RPCResponse {
+	if req.Method == "" {
+		h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrMethodNotFoundS), slog.String("requested-method", req.Method))
+		return rpc.NewError(rpc.ErrMethodIsMissing, rpc.ErrMethodIsMissingS, nil, req.ID)
+	}
+
+	method, err := h.resolveMethodPath(req.Method)
+	if err != nil {
+		if err.Error() == rpc.ErrInvalidMethodFormatS {
+			h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidMethodFormatS), slog.String("requested-method", req.Method))
+			return rpc.NewError(rpc.ErrInvalidMethodFormat, rpc.ErrInvalidMethodFormatS, nil, req.ID)
+		}
+		h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrMethodNotFoundS), slog.String("requested-method", req.Method))
+		return rpc.NewError(rpc.ErrMethodNotFound, rpc.ErrMethodNotFoundS, nil, req.ID)
+	}
+	switch req.Params.(type) {
+	case map[string]any, []any, nil:
+		meta, err := metadata.Load(method)
+		if err != nil {
+			h.x.SLog.Error("cannot load method metadata", slog.String("module", method), slog.String("error", err.Error()))
+			return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+		}
+		if err := auth.Authorize(ctx, meta.Permissions); err != nil {
+			h.x.SLog.Info("request rejected", slog.String("issue", err.Error()), slog.String("requested-method", req.Method))
+			return auth.ErrorResponse(err, req.ID)
+		}
+		if errs := meta.ValidateParams(req.Params); errs != nil {
+			h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidParamsS), slog.String("requested-method", req.Method))
+			return rpc.NewError(rpc.ErrInvalidParams, rpc.ErrInvalidParamsS, map[string]any{"errors": errs}, req.ID)
+		}
+		pool, err := h.workerPool(method)
+		if err != nil {
+			h.x.SLog.Error("cannot start worker pool", slog.String("module", method), slog.String("error", err.Error()))
+			return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+		}
+		if pool != nil {
+			return h.handleWorker(ctx, sid, r, req, pool)
+		}
+		return h.handleProcess(ctx, sid, r, req, method)
+	default:
+		// JSON-RPC 2.0 Specification:
+		// https://www.jsonrpc.org/specification#parameter_structures
+		//
+		// "params" MUST be either an *array* or an *object* if included.
+		// Any other type (e.g., a number, string, or boolean) is INVALID.
+		h.x.SLog.Info("invalid request received", slog.String("issue", rpc.ErrInvalidParamsS))
+		return rpc.NewError(rpc.ErrInvalidParams, rpc.ErrInvalidParamsS, nil, req.ID)
+	}
 }
@@ -0,0 +1,127 @@
+package sv2
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary play a module: testModule writes a script
+// running it again with GS_TEST_MODULE set to the behavior wanted
+func TestMain(m *testing.M) {
+	switch mode := os.Getenv("GS_TEST_MODULE"); mode {
+	case "":
+		os.Exit(m.Run())
+	case "worker":
+		runTestWorker()
+	case "stall":
+		// never reads its stdin
+		select {}
+	case "echo", "fail", "crash", "garbage", "chatty", "sleep":
+		runTestProcess(mode)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown test module %q\n", mode)
+		os.Exit(2)
+	}
+}
+
+// testModule returns the path of an executable running the test binary as the module
+func testModule(tb testing.TB, mode string) string {
+	tb.Helper()
+	self, err := os.Executable()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(tb.TempDir(), "Module")
+	script := fmt.Sprintf("#!/bin/sh\nGS_TEST_MODULE=%s exec %q\n", mode, self)
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// runTestProcess plays a one-shot module
+func runTestProcess(mode string) {
+	var req struct {
+		Method string `json:"method"`
+		Params any    `json:"params"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	switch mode {
+	case "echo":
+		fmt.Fprintln(os.Stderr, "echoing", req.Method)
+		_ = json.NewEncoder(os.Stdout).Encode(map[string]any{"result": map[string]any{
+			"params": req.Params,
+			"method": os.Getenv("GS_METHOD"),
+		}})
+	case "fail":
+		fmt.Println(`{"error": {"code": -32001, "message": "disk is full", "data": {"free": 0}}}`)
+		os.Exit(1)
+	case "crash":
+		fmt.Fprintln(os.Stderr, "panic: something broke")
+		os.Exit(2)
+	case "garbage":
+		fmt.Println("this is not JSON")
+	case "chatty":
+		fmt.Print(strings.Repeat("x", 2<<20))
+	case "sleep":
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+// workerParams are the params understood by the test worker
+type workerParams struct {
+	// Sleep delays the response, responses of later lines may come first
+	Sleep time.Duration `json:"sleep"`
+	// Exit makes the process exit without responding
+	Exit bool `json:"exit"`
+	// Value is sent back in the result
+	Value any `json:"value"`
+}
+
+// runTestWorker answers every line with its value, the pid of the
+// process and the principal it received
+func runTestWorker() {
+	var mu sync.Mutex
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var req struct {
+			ID     uint64          `json:"id"`
+			Params workerParams    `json:"params"`
+			Auth   json.RawMessage `json:"auth"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if req.Params.Exit {
+			os.Exit(1)
+		}
+		go func() {
+			time.Sleep(req.Params.Sleep)
+			mu.Lock()
+			defer mu.Unlock()
+			_ = out.Encode(map[string]any{
+				"id": req.ID,
+				"result": map[string]any{
+					"value": req.Params.Value,
+					"pid":   os.Getpid(),
+					"auth":  req.Auth,
+				},
+			})
+		}()
+	}
+	os.Exit(0)
+}
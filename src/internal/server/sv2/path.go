@@ -0,0 +1,112 @@
+package sv2
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+var RPCMethodSeparator = "."
+
+// resolveMethodPath finds an executable module for the method.
+// Unlike sv1, the extension is not fixed: the method "Tools.Disk" can be
+// served by com/Tools/Disk, com/Tools/Disk.sh, com/Tools/Disk.py and so on.
+// Lua scripts are left to sv1.
+func (h *Handler) resolveMethodPath(method string) (string, error) {
+	if !h.allowedCmd.MatchString(method) {
+		return "", errors.New(rpc.ErrInvalidMethodFormatS)
+	}
+
+	parts := strings.Split(method, RPCMethodSeparator)
+	fullPath := filepath.Join(*h.x.Config.Conf.Node.ComDir, filepath.Join(parts...))
+	dir, stem := filepath.Split(fullPath)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", errors.New(rpc.ErrMethodNotFoundS)
+	}
+	for _, entry := range entries {
+		if !isModuleName(stem, entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !isExecutable(info) {
+			continue
+		}
+		return filepath.Join(dir, entry.Name()), nil
+	}
+
+	return "", errors.New(rpc.ErrMethodNotFoundS)
+}
+
+// isModuleName reports whether the file name can hold the module stem:
+// either the bare stem or the stem with a single extension other than ".lua".
+func isModuleName(stem, name string) bool {
+	if name == stem {
+		return true
+	}
+	ext, ok := strings.CutPrefix(name, stem)
+	if !ok || ext != filepath.Ext(name) {
+		return false
+	}
+	return ext != ".lua"
+}
+
+func isExecutable(info os.FileInfo) bool {
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0
+}
+
+// ListMethods walks the com directory and returns every module that
+// resolveMethodPath would serve. When several files hold the
+// same stem, only the one resolveMethodPath picks is listed.
+func (h *Handler) ListMethods() ([]metadata.Method, error) {
+	comDir := *h.x.Config.Conf.Node.ComDir
+	var methods []metadata.Method
+	err := filepath.WalkDir(comDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == comDir {
+				return err
+			}
+			// one unreadable entry does not hide the others
+			h.x.SLog.Warn("cannot list methods", slog.String("path", path), slog.String("error", err.Error()))
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(path, metadata.SidecarExt) {
+			return nil
+		}
+		stem := strings.TrimSuffix(path, filepath.Ext(path))
+		if !isModuleName(filepath.Base(stem), d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(comDir, stem)
+		if err != nil {
+			return err
+		}
+		name := strings.Join(strings.Split(rel, string(filepath.Separator)), RPCMethodSeparator)
+		if resolved, err := h.resolveMethodPath(name); err != nil || resolved != path {
+			return nil
+		}
+		meta, err := metadata.Load(path)
+		if err != nil {
+			h.x.SLog.Warn("method is not listed, its metadata is invalid", slog.String("method", name), slog.String("error", err.Error()))
+			return nil
+		}
+		methods = append(methods, metadata.Method{
+			Name:           name,
+			ContextVersion: h.ver,
+			Metadata:       meta,
+			Path:           path,
+		})
+		return nil
+	})
+	return methods, err
+}
@@ -0,0 +1,225 @@
+package sv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+// moduleResponse is what a module is expected to print to stdout.
+// Any other JSON-RPC response fields (jsonrpc, id) are accepted and ignored,
+// the node fills them in itself.
+type moduleResponse struct {
+	Result any          `json:"result"`
+	Error  *moduleError `json:"error"`
+}
+
+type moduleError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// handleProcess runs the module once: the JSON-RPC request is written to
+// stdin, the response is read from stdout, and every stderr line is logged.
+func (h *Handler) handleProcess(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest, path string) *rpc.RPCResponse {
+	llog := h.x.SLog.With(slog.String("session-id", sid), slog.String("module", path))
+	llog.Debug("handling process module")
+
+	input, err := json.Marshal(req)
+	if err != nil {
+		llog.Error("cannot encode request", slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, utils.SafeFetch(h.x.Config.Conf.SV2.Timeout, 10*time.Second))
+	defer cancel()
+
+	stdout := &limitedBuffer{max: utils.SafeFetch(h.x.Config.Conf.SV2.MaxOutput, 1<<20)}
+	stderr := &lineLogger{log: llog}
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = h.moduleEnv(ctx, sid, r, req)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// the module may spawn its own children, kill the whole group on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	llog.Debug("starting module")
+	runErr := cmd.Run()
+	stderr.Flush()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		llog.Error("module execution timed out")
+		return rpc.NewError(rpc.ErrTimeout, rpc.ErrTimeoutS, nil, req.ID)
+	}
+	if stdout.overflow {
+		llog.Error("module output is too large", slog.Int("limit", stdout.max))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+
+	out := bytes.TrimSpace(stdout.Bytes())
+	if len(out) == 0 {
+		if runErr != nil {
+			llog.Error("module error", slog.String("error", runErr.Error()))
+			return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+		}
+		return rpc.NewResponse(nil, req.ID)
+	}
+
+	var resp moduleResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		llog.Error("module returned malformed response", slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	if resp.Error == nil && runErr != nil {
+		llog.Error("module error", slog.String("error", runErr.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	return moduleResult(llog, &resp, req.ID)
+}
+
+// moduleResult turns the module's answer into the JSON-RPC response
+func moduleResult(llog *slog.Logger, resp *moduleResponse, id *json.RawMessage) *rpc.RPCResponse {
+	if resp.Error != nil {
+		code := resp.Error.Code
+		message := resp.Error.Message
+		if code == 0 {
+			code = rpc.ErrInternalError
+		}
+		if message == "" {
+			message = rpc.ErrInternalErrorS
+		}
+		llog.Error("the module terminated with an error", slog.Int("code", code), slog.String("message", message), slog.Any("data", resp.Error.Data))
+		return rpc.NewError(code, message, resp.Error.Data, id)
+	}
+	return rpc.NewResponse(resp.Result, id)
+}
+
+// moduleEnv prepares the environment of the module process.
+// The request itself goes to stdin, the variables only describe the call.
+// GS_PRINCIPAL is only set when the gateway authenticated the client,
+// GS_CLIENT_CERT_* only when the client presented a verified certificate.
+func (h *Handler) moduleEnv(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest) []string {
+	vars := []string{
+		"GS_SESSION_UUID=" + sid,
+		"GS_NODE_UUID=" + h.cs.UUID32,
+		"GS_METHOD=" + req.Method,
+		"GS_CONTEXT_VERSION=" + h.ver,
+		"GS_REMOTE_ADDR=" + r.RemoteAddr,
+		"GS_COM_DIR=" + *h.x.Config.Conf.Node.ComDir,
+	}
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		vars = append(vars, "GS_PRINCIPAL="+p.ID, "GS_ROLES="+strings.Join(p.Roles, ","))
+	}
+	if cert, ok := auth.CertificateFrom(ctx); ok {
+		vars = append(vars,
+			"GS_CLIENT_CERT_SUBJECT="+cert.Subject,
+			"GS_CLIENT_CERT_FINGERPRINT="+cert.Fingerprint,
+			"GS_CLIENT_CERT_NAMES="+strings.Join(cert.Names(), ","),
+		)
+	}
+	return utils.SetEviron(os.Environ(), vars...)
+}
+
+// limitedBuffer keeps at most max bytes and silently drops the rest,
+// so a chatty module cannot block on a full pipe or eat the node's memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if free := b.max - b.Len(); len(p) > free {
+		b.overflow = true
+		if free > 0 {
+			b.Buffer.Write(p[:free])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// MaxStderrLine is the longest stderr line of a module logged,
+// the rest of a longer line is dropped
+var MaxStderrLine = 4 << 10
+
+// lineLogger writes every complete stderr line of a module to the logger.
+// A line is kept in memory only up to MaxStderrLine.
+type lineLogger struct {
+	log     *slog.Logger
+	pending []byte
+	// truncated is set once the current line was logged cut,
+	// the rest of it is skipped up to the next newline
+	truncated bool
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if !l.truncated {
+			if room := MaxStderrLine - len(l.pending); len(chunk) > room {
+				l.pending = append(l.pending, chunk[:room]...)
+				l.emit(l.pending, true)
+				l.pending = l.pending[:0]
+				l.truncated = true
+			} else {
+				l.pending = append(l.pending, chunk...)
+			}
+		}
+		if i < 0 {
+			break
+		}
+		if !l.truncated {
+			l.emit(l.pending, false)
+		}
+		l.pending = l.pending[:0]
+		l.truncated = false
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Flush logs the last line if the module did not terminate it.
+func (l *lineLogger) Flush() {
+	if !l.truncated {
+		l.emit(l.pending, false)
+	}
+	l.pending = nil
+	l.truncated = false
+}
+
+func (l *lineLogger) emit(line []byte, truncated bool) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return
+	}
+	if truncated {
+		l.log.Warn("the module says", slog.String("stderr", string(line)), slog.Bool("truncated", true))
+		return
+	}
+	l.log.Warn("the module says", slog.String("stderr", string(line)))
+}
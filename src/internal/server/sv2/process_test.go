@@ -0,0 +1,133 @@
+package sv2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
+	"github.com/akyaiy/GoSally-mvp/src/internal/engine/config"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+func newTestHandler(tb testing.TB, logs io.Writer) *Handler {
+	tb.Helper()
+	comDir := tb.TempDir()
+	timeout, maxOutput := 2*time.Second, 1<<20
+	x := &app.AppX{
+		Log:  log.New(io.Discard, "", 0),
+		SLog: slog.New(slog.NewTextHandler(logs, nil)),
+		Config: &config.Compositor{Conf: &config.Conf{
+			Node: &config.Node{ComDir: &comDir},
+			SV2:  &config.SV2{Timeout: &timeout, MaxOutput: &maxOutput},
+		}},
+	}
+	h := InitServer(&HandlerInitStruct{
+		X:          x,
+		CS:         &corestate.CoreState{},
+		AllowedCmd: regexp.MustCompile(`^[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)*$`),
+		Ver:        "v2",
+	})
+	tb.Cleanup(h.Shutdown)
+	return h
+}
+
+func TestHandleProcess(t *testing.T) {
+	tests := []struct {
+		mode string
+		// result is checked when code is 0
+		code   int
+		result string
+	}{
+		{mode: "echo", result: `{"method":"Module","params":{"n":1}}`},
+		// the error of the module is passed on as it is
+		{mode: "fail", code: -32001},
+		{mode: "crash", code: rpc.ErrInternalError},
+		{mode: "garbage", code: rpc.ErrInternalError},
+		{mode: "chatty", code: rpc.ErrInternalError},
+		{mode: "sleep", code: rpc.ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			h := newTestHandler(t, io.Discard)
+			id := json.RawMessage("7")
+			start := time.Now()
+			resp := h.handleProcess(context.Background(), "sid", httptest.NewRequest("POST", "/com", nil), &rpc.RPCRequest{
+				JSONRPC: rpc.JSONRPCVersion,
+				ID:      &id,
+				Method:  "Module",
+				Params:  map[string]any{"n": 1},
+			}, testModule(t, tt.mode))
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("took %v", elapsed)
+			}
+			if resp.ID == nil || string(*resp.ID) != "7" {
+				t.Errorf("id = %v, want 7", resp.ID)
+			}
+
+			if tt.code == 0 {
+				result, _ := json.Marshal(resp.Result)
+				if resp.Error != nil || string(result) != tt.result {
+					t.Errorf("result = %s, error %+v; want %s", result, resp.Error, tt.result)
+				}
+				return
+			}
+			data, _ := json.Marshal(resp.Error)
+			var e struct {
+				Code int `json:"code"`
+			}
+			if err := json.Unmarshal(data, &e); err != nil || e.Code != tt.code {
+				t.Errorf("error = %s, want code %d", data, tt.code)
+			}
+		})
+	}
+}
+
+func TestHandleProcess_Stderr(t *testing.T) {
+	var logs bytes.Buffer
+	h := newTestHandler(t, &logs)
+	h.handleProcess(context.Background(), "sid", httptest.NewRequest("POST", "/com", nil), &rpc.RPCRequest{
+		JSONRPC: rpc.JSONRPCVersion,
+		Method:  "Module",
+	}, testModule(t, "crash"))
+	if !strings.Contains(logs.String(), "panic: something broke") {
+		t.Errorf("stderr is not logged: %s", logs.String())
+	}
+}
+
+func TestLineLogger(t *testing.T) {
+	limit := MaxStderrLine
+	MaxStderrLine = 8
+	t.Cleanup(func() { MaxStderrLine = limit })
+
+	var logs bytes.Buffer
+	l := &lineLogger{log: slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key != "stderr" && a.Key != "truncated" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))}
+	for _, chunk := range []string{"one\r\ntw", "o\n", "a very long line", " goes on and on", "\nlast"} {
+		l.Write([]byte(chunk))
+		if len(l.pending) > MaxStderrLine {
+			t.Errorf("%d bytes pending", len(l.pending))
+		}
+	}
+	l.Flush()
+
+	want := "stderr=one\nstderr=two\nstderr=\"a very l\" truncated=true\nstderr=last\n"
+	if logs.String() != want {
+		t.Errorf("logged\n%s\nwant\n%s", logs.String(), want)
+	}
+}
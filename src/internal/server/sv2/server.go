@@ -1,9 +1,20 @@
 // SV2 works with binaries, scripts, and anything else that has access to stdin/stdout.
 // Modules run in a separate process and communicate via I/O.
+//
+// A module is an executable file in the com directory, the method "Tools.Disk"
+// is served by com/Tools/Disk with any extension except ".lua". For every call
+// the node starts the module, writes the JSON-RPC request to its stdin and reads
+// a JSON object with "result" or "error" fields from its stdout. Everything
+// the module writes to stderr goes to the node log.
+//
+// A module whose metadata contains a "worker" section is long-lived instead:
+// the node keeps a supervised pool of its processes and sends them one request
+// per line, matching responses by the "id" the node assigned to each line.
 package sv2
 
 import (
 	"regexp"
+	"sync"
 
 	"github.com/akyaiy/GoSally-mvp/src/internal/core/corestate"
 	"github.com/akyaiy/GoSally-mvp/src/internal/engine/app"
@@ -25,6 +36,11 @@ type Handler struct {
 	allowedCmd *regexp.Regexp
 
 	ver string
+
+	// workers holds the pools of persistent modules by module path
+	workersMu sync.Mutex
+	workers   map[string]*workerPool
+	closed    bool
 }
 
 func InitServer(o *HandlerInitStruct) *Handler {
@@ -33,6 +49,7 @@ func InitServer(o *HandlerInitStruct) *Handler {
 		x:          o.X,
 		allowedCmd: o.AllowedCmd,
 		ver:        o.Ver,
+		workers:    make(map[string]*workerPool),
 	}
 }
 
@@ -0,0 +1,470 @@
+package sv2
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/core/utils"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/metadata"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+var (
+	// WorkerMinBackoff and WorkerMaxBackoff bound the delay before a crashed worker is restarted.
+	// The delay doubles after every crash and resets once a worker stays alive for WorkerMaxBackoff.
+	WorkerMinBackoff = 500 * time.Millisecond
+	WorkerMaxBackoff = 30 * time.Second
+
+	// WorkerStopTimeout is how long a worker may take to exit after its stdin is closed
+	WorkerStopTimeout = 3 * time.Second
+)
+
+var (
+	errWorkerUnavailable = errors.New("no worker process is available")
+	errWorkerExited      = errors.New("worker process exited before responding")
+)
+
+// workerRequest is a single line written to a worker's stdin.
+// The id is assigned by the node, so requests of different sessions never clash.
+type workerRequest struct {
+	*rpc.RPCRequest
+	ID          uint64 `json:"id"`
+	SessionUUID string `json:"session-uuid"`
+	RemoteAddr  string `json:"remote-addr"`
+	// Auth is the principal the gateway authenticated, if any
+	Auth *auth.Principal `json:"auth,omitempty"`
+	// Certificate is the verified client certificate, if any
+	Certificate *auth.Certificate `json:"certificate,omitempty"`
+}
+
+// workerResponse is a single line read from a worker's stdout
+type workerResponse struct {
+	ID *uint64 `json:"id"`
+	moduleResponse
+}
+
+// workerPool supervises the processes of one persistent module
+type workerPool struct {
+	path    string
+	log     *slog.Logger
+	env     []string
+	workers []*worker
+	next    atomic.Uint64
+
+	maxOutput int
+
+	// ready is closed and replaced every time a worker process comes up
+	readyMu sync.Mutex
+	ready   chan struct{}
+
+	// stopping is set before the workers are asked to exit,
+	// so a clean exit is not mistaken for a crash
+	stopping atomic.Bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type worker struct {
+	pool *workerPool
+
+	mu      sync.Mutex
+	stdin   io.WriteCloser // nil while the process is down
+	pending map[uint64]chan *moduleResponse
+	seq     uint64
+
+	// lines are written to stdin by the writer of the running process,
+	// so a worker not reading its stdin blocks no one holding mu.
+	// exited is closed when the process is gone.
+	lines  chan workerLine
+	exited chan struct{}
+}
+
+// workerLine is a request waiting for the writer
+type workerLine struct {
+	id   uint64
+	data []byte
+}
+
+// workerPool returns the running pool for the module, starting it if the
+// module's metadata asks for worker mode. nil means the module is one-shot.
+func (h *Handler) workerPool(path string) (*workerPool, error) {
+	h.workersMu.Lock()
+	defer h.workersMu.Unlock()
+
+	if pool, ok := h.workers[path]; ok {
+		return pool, nil
+	}
+	if h.closed {
+		return nil, nil
+	}
+
+	meta, err := metadata.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Worker == nil {
+		return nil, nil
+	}
+
+	pool := newWorkerPool(path, h.x.SLog.With(slog.String("module", path)),
+		utils.SetEviron(os.Environ(),
+			"GS_NODE_UUID="+h.cs.UUID32,
+			"GS_CONTEXT_VERSION="+h.ver,
+			"GS_COM_DIR="+*h.x.Config.Conf.Node.ComDir,
+			"GS_WORKER=1",
+		),
+		utils.SafeFetch(h.x.Config.Conf.SV2.MaxOutput, 1<<20),
+		meta.Worker.Processes,
+	)
+	pool.log.Info("worker pool started", slog.Int("processes", meta.Worker.Processes))
+
+	h.workers[path] = pool
+	return pool, nil
+}
+
+// newWorkerPool starts the supervisors of the processes of the module
+func newWorkerPool(path string, log *slog.Logger, env []string, maxOutput, processes int) *workerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &workerPool{
+		path:      path,
+		log:       log,
+		env:       env,
+		maxOutput: maxOutput,
+		ready:     make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	for range processes {
+		w := &worker{pool: pool, pending: make(map[uint64]chan *moduleResponse)}
+		pool.workers = append(pool.workers, w)
+		pool.wg.Add(1)
+		go w.supervise()
+	}
+	return pool
+}
+
+// Shutdown stops every worker pool. Workers get their stdin closed
+// and are killed if they do not exit within WorkerStopTimeout.
+func (h *Handler) Shutdown() {
+	h.workersMu.Lock()
+	h.closed = true
+	pools := h.workers
+	h.workers = make(map[string]*workerPool)
+	h.workersMu.Unlock()
+
+	stopPools(pools)
+}
+
+// Reload stops the worker pools of the changed modules,
+// the next call starts them again with the new file and metadata
+func (h *Handler) Reload(changed []string) error {
+	h.workersMu.Lock()
+	pools := make(map[string]*workerPool)
+	for _, path := range changed {
+		if pool, ok := h.workers[path]; ok {
+			pools[path] = pool
+			delete(h.workers, path)
+		}
+	}
+	h.workersMu.Unlock()
+
+	stopPools(pools)
+	return nil
+}
+
+func stopPools(pools map[string]*workerPool) {
+	var wg sync.WaitGroup
+	for _, pool := range pools {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.stop()
+		}()
+	}
+	wg.Wait()
+}
+
+func (h *Handler) handleWorker(ctx context.Context, sid string, r *http.Request, req *rpc.RPCRequest, pool *workerPool) *rpc.RPCResponse {
+	llog := h.x.SLog.With(slog.String("session-id", sid), slog.String("module", pool.path))
+	llog.Debug("handling worker module")
+
+	ctx, cancel := context.WithTimeout(ctx, utils.SafeFetch(h.x.Config.Conf.SV2.Timeout, 10*time.Second))
+	defer cancel()
+
+	principal, _ := auth.PrincipalFrom(ctx)
+	cert, _ := auth.CertificateFrom(ctx)
+	resp, err := pool.call(ctx, &workerRequest{
+		RPCRequest:  req,
+		SessionUUID: sid,
+		RemoteAddr:  r.RemoteAddr,
+		Auth:        principal,
+		Certificate: cert,
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		llog.Error("module execution timed out")
+		return rpc.NewError(rpc.ErrTimeout, rpc.ErrTimeoutS, nil, req.ID)
+	}
+	if err != nil {
+		llog.Error("worker error", slog.String("error", err.Error()))
+		return rpc.NewError(rpc.ErrInternalError, rpc.ErrInternalErrorS, nil, req.ID)
+	}
+	return moduleResult(llog, resp, req.ID)
+}
+
+// call sends the request to the next available worker in round-robin order.
+// While every worker is down (starting or restarting) the call waits for one.
+func (p *workerPool) call(ctx context.Context, req *workerRequest) (*moduleResponse, error) {
+	for {
+		ready := p.readyChan()
+
+		start := p.next.Add(1)
+		for i := range uint64(len(p.workers)) {
+			w := p.workers[(start+i)%uint64(len(p.workers))]
+			resp, err := w.call(ctx, req)
+			if errors.Is(err, errWorkerUnavailable) {
+				continue
+			}
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ready:
+		}
+	}
+}
+
+func (p *workerPool) readyChan() chan struct{} {
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	return p.ready
+}
+
+func (p *workerPool) notifyReady() {
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	close(p.ready)
+	p.ready = make(chan struct{})
+}
+
+func (p *workerPool) stop() {
+	p.stopping.Store(true)
+	for _, w := range p.workers {
+		w.mu.Lock()
+		if w.stdin != nil {
+			w.stdin.Close()
+		}
+		w.mu.Unlock()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	// closing the context stops the supervisors from restarting
+	// the workers and kills whatever is still running after the timeout
+	select {
+	case <-done:
+		p.cancel()
+	case <-time.After(WorkerStopTimeout):
+		p.log.Warn("workers did not exit in time, killing")
+		p.cancel()
+		<-done
+	}
+	p.log.Info("worker pool stopped")
+}
+
+func (w *worker) call(ctx context.Context, req *workerRequest) (*moduleResponse, error) {
+	w.mu.Lock()
+	if w.stdin == nil {
+		w.mu.Unlock()
+		return nil, errWorkerUnavailable
+	}
+	w.seq++
+	id := w.seq
+	line := *req
+	line.ID = id
+	data, err := json.Marshal(&line)
+	if err != nil {
+		w.mu.Unlock()
+		return nil, err
+	}
+	ch := make(chan *moduleResponse, 1)
+	w.pending[id] = ch
+	lines, exited := w.lines, w.exited
+	w.mu.Unlock()
+
+	select {
+	case lines <- workerLine{id: id, data: append(data, '\n')}:
+	case <-exited:
+	case <-ctx.Done():
+		w.forget(id)
+		return nil, ctx.Err()
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, errWorkerExited
+		}
+		return resp, nil
+	case <-ctx.Done():
+		w.forget(id)
+		return nil, ctx.Err()
+	}
+}
+
+// forget drops the call waiting for the response with the id
+func (w *worker) forget(id uint64) {
+	w.mu.Lock()
+	delete(w.pending, id)
+	w.mu.Unlock()
+}
+
+// write sends the lines to stdin until the process exits.
+// A line whose call is gone is skipped, a failed write fails its call.
+func (w *worker) write(stdin io.Writer, lines <-chan workerLine, exited <-chan struct{}) {
+	for {
+		select {
+		case <-exited:
+			return
+		case line := <-lines:
+			w.mu.Lock()
+			_, ok := w.pending[line.id]
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if _, err := stdin.Write(line.data); err != nil {
+				w.pool.log.Warn("cannot write to worker", slog.String("error", err.Error()))
+				w.mu.Lock()
+				if ch, ok := w.pending[line.id]; ok {
+					close(ch)
+					delete(w.pending, line.id)
+				}
+				w.mu.Unlock()
+			}
+		}
+	}
+}
+
+// supervise keeps the worker process running until the pool is stopped
+func (w *worker) supervise() {
+	defer w.pool.wg.Done()
+
+	backoff := WorkerMinBackoff
+	for !w.pool.stopping.Load() {
+		started := time.Now()
+		err := w.run()
+		if w.pool.stopping.Load() {
+			return
+		}
+		if time.Since(started) > WorkerMaxBackoff {
+			backoff = WorkerMinBackoff
+		}
+
+		w.pool.log.Error("worker exited, restarting", slog.Any("error", err), slog.Duration("backoff", backoff))
+		select {
+		case <-w.pool.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, WorkerMaxBackoff)
+	}
+}
+
+// run starts the process and serves it until it exits
+func (w *worker) run() error {
+	cmd := exec.CommandContext(w.pool.ctx, w.pool.path)
+	cmd.Env = w.pool.env
+	cmd.Stderr = &lineLogger{log: w.pool.log}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	w.pool.log.Debug("worker started", slog.Int("pid", cmd.Process.Pid))
+
+	lines, exited := make(chan workerLine), make(chan struct{})
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		w.write(stdin, lines, exited)
+	}()
+
+	w.mu.Lock()
+	w.stdin = stdin
+	w.lines, w.exited = lines, exited
+	w.mu.Unlock()
+	w.pool.notifyReady()
+
+	if err := w.read(stdout); err != nil {
+		// the stream is out of sync, the process cannot be trusted anymore
+		w.pool.log.Error("cannot read worker output", slog.String("error", err.Error()))
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	err = cmd.Wait()
+
+	w.mu.Lock()
+	w.stdin = nil
+	w.lines, w.exited = nil, nil
+	for id, ch := range w.pending {
+		close(ch)
+		delete(w.pending, id)
+	}
+	w.mu.Unlock()
+	close(exited)
+	<-written
+	return err
+}
+
+// read dispatches response lines to the waiting calls until stdout is closed
+func (w *worker) read(stdout io.Reader) error {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), w.pool.maxOutput)
+	for scanner.Scan() {
+		var resp workerResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || resp.ID == nil {
+			w.pool.log.Warn("unexpected worker output", slog.String("line", scanner.Text()))
+			continue
+		}
+
+		w.mu.Lock()
+		ch, ok := w.pending[*resp.ID]
+		delete(w.pending, *resp.ID)
+		w.mu.Unlock()
+		if ok {
+			ch <- &resp.moduleResponse
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,167 @@
+package sv2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/auth"
+	"github.com/akyaiy/GoSally-mvp/src/internal/server/rpc"
+)
+
+func newTestPool(tb testing.TB, mode string, processes int) *workerPool {
+	tb.Helper()
+	pool := newWorkerPool(testModule(tb, mode), slog.New(slog.NewTextHandler(io.Discard, nil)), nil, 1<<20, processes)
+	tb.Cleanup(pool.stop)
+	return pool
+}
+
+// waitWorkers waits for every process of the pool to be up
+func waitWorkers(ctx context.Context, tb testing.TB, pool *workerPool) {
+	tb.Helper()
+	for _, w := range pool.workers {
+		for {
+			w.mu.Lock()
+			up := w.stdin != nil
+			w.mu.Unlock()
+			if up {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				tb.Fatal("the workers did not start")
+			case <-pool.readyChan():
+			}
+		}
+	}
+}
+
+// callWorker sends the params to the pool and returns the result
+func callWorker(ctx context.Context, pool *workerPool, params map[string]any) (map[string]any, error) {
+	resp, err := pool.call(ctx, &workerRequest{
+		RPCRequest: &rpc.RPCRequest{JSONRPC: rpc.JSONRPCVersion, Method: "Module", Params: params},
+		Auth:       &auth.Principal{ID: "alice", Method: auth.MethodAPIKey},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, _ := resp.Result.(map[string]any)
+	return result, nil
+}
+
+func TestWorker_Concurrent(t *testing.T) {
+	pool := newTestPool(t, "worker", 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	waitWorkers(ctx, t, pool)
+
+	var wg sync.WaitGroup
+	pids := sync.Map{}
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// later calls answer first, responses must still reach their own call
+			result, err := callWorker(ctx, pool, map[string]any{"value": i, "sleep": (50 - i) * int(time.Millisecond)})
+			if err != nil {
+				t.Errorf("call %d: %v", i, err)
+				return
+			}
+			if result["value"] != float64(i) {
+				t.Errorf("call %d got the result of %v", i, result["value"])
+			}
+			if auth, _ := result["auth"].(map[string]any); auth["id"] != "alice" {
+				t.Errorf("call %d: the worker got the principal %v", i, result["auth"])
+			}
+			pids.Store(result["pid"], true)
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	pids.Range(func(_, _ any) bool { n++; return true })
+	if n != 2 {
+		t.Errorf("calls went to %d processes, want 2", n)
+	}
+}
+
+func TestWorker_Restart(t *testing.T) {
+	minBackoff := WorkerMinBackoff
+	WorkerMinBackoff = 10 * time.Millisecond
+	t.Cleanup(func() { WorkerMinBackoff = minBackoff })
+
+	pool := newTestPool(t, "worker", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	before, err := callWorker(ctx, pool, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := callWorker(ctx, pool, map[string]any{"exit": true}); !errors.Is(err, errWorkerExited) {
+		t.Fatalf("call to an exiting worker = %v, want %v", err, errWorkerExited)
+	}
+	// the call waits for the restarted process
+	after, err := callWorker(ctx, pool, map[string]any{"value": "again"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after["value"] != "again" || after["pid"] == before["pid"] {
+		t.Errorf("after the crash got %v, want a new process answering (it was %v)", after, before["pid"])
+	}
+}
+
+func TestWorker_Timeout(t *testing.T) {
+	pool := newTestPool(t, "worker", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := callWorker(ctx, pool, map[string]any{"sleep": int(time.Minute)}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow call = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the late response is dropped and the worker keeps serving
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if result, err := callWorker(ctx, pool, map[string]any{"value": 1}); err != nil || result["value"] != float64(1) {
+		t.Errorf("call after a timeout = %v, %v", result, err)
+	}
+}
+
+func TestWorker_StalledStdin(t *testing.T) {
+	stopTimeout := WorkerStopTimeout
+	WorkerStopTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { WorkerStopTimeout = stopTimeout })
+
+	pool := newTestPool(t, "stall", 1)
+	big := strings.Repeat("x", 1<<20)
+
+	// the pipe fills up, every call must still give up at its deadline
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				_, err := callWorker(ctx, pool, map[string]any{"value": big})
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("call %d = %v, want %v", i, err, context.DeadlineExceeded)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("call %d is blocked writing to the worker", i)
+			}
+		}()
+	}
+	wg.Wait()
+}